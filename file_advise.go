@@ -0,0 +1,69 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+)
+
+/*
+AccessHint tells the operating system how a file is going to be read, so
+that it can tune read-ahead and caching accordingly.
+*/
+type AccessHint int
+
+const (
+	// AccessNormal gives no advice, leaving the defaults in place.
+	AccessNormal AccessHint = iota
+
+	// AccessSequential announces that the file will be read from start to
+	// end, which usually makes the operating system read ahead further.
+	AccessSequential
+
+	// AccessRandom announces that the file will be read at random offsets,
+	// which usually disables read-ahead.
+	AccessRandom
+
+	// AccessWillNeed asks the operating system to start loading the whole
+	// file into the page cache right away, e.g. because many readers are
+	// about to be opened over it.
+	AccessWillNeed
+)
+
+/*
+openWithHint returns a function opening files for reading and passing hint
+on to the operating system for them. Errors from doing the latter are
+ignored.
+*/
+func openWithHint(hint AccessHint) func(string) (*os.File, error) {
+	return func(fpath string) (*os.File, error) {
+		var f *os.File
+		var err error
+
+		f, err = os.Open(fpath)
+		if err == nil {
+			adviseFile(f, hint)
+		}
+		return f, err
+	}
+}
+
+/*
+OpenReaderWithHint works like OpenReader, but passes hint on to the
+operating system after opening the file, using posix_fadvise where
+available. Hints are advisory only: on platforms without support for them,
+or for files they don't apply to, they are silently ignored.
+*/
+func (file *FileAdapter) OpenReaderWithHint(
+	ctx context.Context, fileurl *url.URL, hint AccessHint) (filesystem.ReadCloser, error) {
+	var f *ContextRespectingIoFile
+	var err error
+
+	f, err = file.openForReading(ctx, fileurl, openWithHint(hint))
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
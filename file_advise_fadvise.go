@@ -0,0 +1,45 @@
+//go:build linux || freebsd || netbsd
+
+package file
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+	"syscall"
+)
+
+/*
+adviseFile passes hint on to the operating system for the whole file. The
+descriptor is accessed through SyscallConn, since calling Fd would switch
+pollable files to blocking mode.
+*/
+func adviseFile(file *os.File, hint AccessHint) error {
+	var conn syscall.RawConn
+	var advice int
+	var err error
+	var aerr error
+
+	switch hint {
+	case AccessSequential:
+		advice = unix.FADV_SEQUENTIAL
+	case AccessRandom:
+		advice = unix.FADV_RANDOM
+	case AccessWillNeed:
+		advice = unix.FADV_WILLNEED
+	default:
+		return nil
+	}
+
+	conn, err = file.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	err = conn.Control(func(fd uintptr) {
+		aerr = unix.Fadvise(int(fd), 0, 0, advice)
+	})
+	if err != nil {
+		return err
+	}
+	return aerr
+}
@@ -0,0 +1,14 @@
+//go:build !(linux || freebsd || netbsd)
+
+package file
+
+import (
+	"os"
+)
+
+/*
+adviseFile does nothing since there is no posix_fadvise on this platform.
+*/
+func adviseFile(file *os.File, hint AccessHint) error {
+	return nil
+}
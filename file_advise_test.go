@@ -0,0 +1,54 @@
+package file
+
+import (
+	"golang.org/x/net/context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestOpenReaderWithHint(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+
+	writeTestFile(t, fpath, "hinted")
+
+	for _, hint := range []AccessHint{AccessNormal, AccessSequential, AccessRandom, AccessWillNeed} {
+		var r, err = DefaultAdapter().OpenReaderWithHint(context.Background(), fileURL(fpath), hint)
+
+		if err != nil {
+			t.Errorf("opening with hint %d: %v", hint, err)
+			continue
+		}
+		if got := readAndClose(t, r); got != "hinted" {
+			t.Errorf("read %q with hint %d, want %q", got, hint, "hinted")
+		}
+	}
+}
+
+func TestAdviseFile(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var f *os.File
+	var err error
+
+	switch runtime.GOOS {
+	case "linux", "freebsd", "netbsd":
+	default:
+		t.Skipf("no posix_fadvise on %s", runtime.GOOS)
+	}
+
+	writeTestFile(t, fpath, "hinted")
+	f, err = os.Open(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The hints are ignored by OpenReaderWithHint, so check them here.
+	for _, hint := range []AccessHint{AccessNormal, AccessSequential, AccessRandom, AccessWillNeed} {
+		err = adviseFile(f, hint)
+		if err != nil {
+			t.Errorf("hint %d was rejected: %v", hint, err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+)
+
+/*
+ErrFileReplaced is returned by OpenAppenderWithReader if the file at the path
+was replaced between opening it for writing and for reading, so that the two
+would not refer to the same file. SecureRemove returns it if the file was
+replaced between being checked and being opened.
+*/
+var ErrFileReplaced = errors.New("file replaced while opening")
+
+/*
+OpenAppenderWithReader opens the file pointed to for appending like
+OpenAppender, and additionally for reading from the start, e.g. for one
+goroutine to append to a log while another one follows it. The writer and the
+reader are separate open files with their own offsets, and each has to be
+closed on its own; closing one doesn't affect the other.
+
+Since writes are passed on to the operating system right away, the reader
+sees everything the writer has written once the corresponding Write has
+returned. When the reader has caught up with the writer, reads report
+io.EOF, but reading again later returns whatever has been appended in the
+meantime.
+*/
+func (file *FileAdapter) OpenAppenderWithReader(ctx context.Context, fileurl *url.URL) (
+	filesystem.WriteCloser, filesystem.ReadCloser, error) {
+	var w, r *ContextRespectingIoFile
+	var wfi, rfi os.FileInfo
+	var err error
+
+	w, err = file.openForWriting(ctx, fileurl, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r, err = file.openForReading(ctx, fileurl, os.Open)
+	if err != nil {
+		w.Close(ctx)
+		return nil, nil, err
+	}
+
+	wfi, err = w.stat(ctx)
+	if err == nil {
+		rfi, err = r.stat(ctx)
+	}
+	if err != nil {
+		err = urlError("stat", fileurl, err)
+	} else if !os.SameFile(wfi, rfi) {
+		err = urlError("open", fileurl, ErrFileReplaced)
+	}
+	if err != nil {
+		r.Close(ctx)
+		w.Close(ctx)
+		return nil, nil, err
+	}
+
+	return w, r, nil
+}
@@ -0,0 +1,81 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"golang.org/x/net/context"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenAppenderWithReader(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "log")
+	var w filesystem.WriteCloser
+	var r filesystem.ReadCloser
+	var buf = make([]byte, 100)
+	var n int
+	var err error
+
+	writeTestFile(t, fpath, "old\n")
+
+	w, r, err = DefaultAdapter().OpenAppenderWithReader(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The reader starts at the beginning, the writer at the end.
+	n, err = r.Read(context.Background(), buf)
+	if err != nil || string(buf[:n]) != "old\n" {
+		t.Errorf("read %q, %v, want %q", buf[:n], err, "old\n")
+	}
+	_, err = r.Read(context.Background(), buf)
+	if err != io.EOF {
+		t.Errorf("reading after catching up returned %v, want io.EOF", err)
+	}
+
+	// Appended data shows up once the write has returned.
+	for _, line := range []string{"first\n", "second\n"} {
+		_, err = w.Write(context.Background(), []byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err = r.Read(context.Background(), buf)
+		if err != nil || string(buf[:n]) != line {
+			t.Errorf("read %q, %v after appending, want %q", buf[:n], err, line)
+		}
+	}
+
+	// Closing the writer leaves the reader usable.
+	err = w.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Read(context.Background(), buf)
+	if err != io.EOF {
+		t.Errorf("reading after closing the writer returned %v, want io.EOF", err)
+	}
+	err = r.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectContents(t, fpath, "old\nfirst\nsecond\n")
+
+	// And the other way round.
+	w, r, err = DefaultAdapter().OpenAppenderWithReader(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(context.Background(), []byte("third\n"))
+	if err == nil {
+		err = w.Close(context.Background())
+	}
+	if err != nil {
+		t.Fatalf("writing after closing the reader: %v", err)
+	}
+	expectContents(t, fpath, "old\nfirst\nsecond\nthird\n")
+}
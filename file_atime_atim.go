@@ -0,0 +1,24 @@
+//go:build linux || openbsd || dragonfly || solaris
+
+package file
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+/*
+accessTime determines the time of the last access to the file described by
+fi from its raw stat information.
+*/
+func accessTime(fi os.FileInfo) (time.Time, bool) {
+	var st *syscall.Stat_t
+	var ok bool
+
+	st, ok = fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(st.Atim.Unix()), true
+}
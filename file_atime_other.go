@@ -0,0 +1,16 @@
+//go:build !(linux || openbsd || dragonfly || solaris || darwin || freebsd || netbsd || windows)
+
+package file
+
+import (
+	"os"
+	"time"
+)
+
+/*
+accessTime reports that the access time of files cannot be determined on
+this platform.
+*/
+func accessTime(fi os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
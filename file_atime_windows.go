@@ -0,0 +1,22 @@
+package file
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+/*
+accessTime determines the time of the last access to the file described by
+fi from its file attributes.
+*/
+func accessTime(fi os.FileInfo) (time.Time, bool) {
+	var attrs *syscall.Win32FileAttributeData
+	var ok bool
+
+	attrs, ok = fi.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, attrs.LastAccessTime.Nanoseconds()), true
+}
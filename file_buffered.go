@@ -0,0 +1,111 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+)
+
+/*
+defaultBufferSize is the buffer size used by buffered writers if the caller
+doesn't specify a positive size.
+*/
+const defaultBufferSize = 4096
+
+/*
+BufferedWriter collects small writes in memory and only passes them on to the
+underlying file once the buffer is full, when it is flushed explicitly or when
+the writer is closed. This saves a system call and a goroutine per write for
+workloads consisting of many small writes.
+*/
+type BufferedWriter struct {
+	file *ContextRespectingIoFile
+	buf  []byte
+}
+
+/*
+NewBufferedWriter wraps the specified file into a BufferedWriter with a
+buffer of the given size.
+*/
+func NewBufferedWriter(file *ContextRespectingIoFile, size int) *BufferedWriter {
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	return &BufferedWriter{
+		file: file,
+		buf:  make([]byte, 0, size),
+	}
+}
+
+/*
+Write() appends the data to the buffer, flushing it to the file first if it
+would overflow. Writes larger than the buffer are passed through directly.
+*/
+func (w *BufferedWriter) Write(ctx context.Context, p []byte) (int, error) {
+	var err error
+
+	if len(w.buf)+len(p) > cap(w.buf) {
+		err = w.Flush(ctx)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if len(p) >= cap(w.buf) {
+		return w.file.Write(ctx, p)
+	}
+
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+/*
+Flush() writes all buffered data to the underlying file. If only part of the
+data could be written, the remainder stays in the buffer.
+*/
+func (w *BufferedWriter) Flush(ctx context.Context) error {
+	var n int
+	var err error
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	n, err = w.file.Write(ctx, w.buf)
+	w.buf = w.buf[:copy(w.buf, w.buf[n:])]
+	return err
+}
+
+/*
+Close() flushes any buffered data and closes the underlying file. The file is
+closed even if flushing fails, in which case the data which couldn't be
+written is lost; call Flush first to be able to retry. If both flushing and
+closing fail, both errors are reported, combined using errors.Join.
+*/
+func (w *BufferedWriter) Close(ctx context.Context) error {
+	var err error
+
+	err = w.Flush(ctx)
+	w.buf = w.buf[:0]
+	return combineErrors(err, w.file.Close(ctx))
+}
+
+/*
+OpenBufferedWriter works like OpenWriter, but the resulting writer buffers up
+to size bytes in memory before writing them to the file. A size of zero or
+less selects a default buffer size.
+*/
+func (file *FileAdapter) OpenBufferedWriter(
+	ctx context.Context, fileurl *url.URL, size int) (filesystem.WriteCloser, error) {
+	var f *ContextRespectingIoFile
+	var err error
+
+	f, err = file.openForWriting(ctx, fileurl, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBufferedWriter(f, size), nil
+}
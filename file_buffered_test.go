@@ -0,0 +1,179 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+writeSyscalls returns the number of write system calls the process has made
+so far, as far as the operating system keeps count; only Linux does.
+*/
+func writeSyscalls() (int64, bool) {
+	var data []byte
+	var n int64
+	var err error
+
+	data, err = os.ReadFile("/proc/self/io")
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if _, err = fmt.Sscanf(string(line), "syscw: %d", &n); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+func TestBufferedWriter(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "buffered")
+	var w filesystem.WriteCloser
+	var want bytes.Buffer
+	var i int
+	var err error
+
+	w, err = DefaultAdapter().OpenBufferedWriter(context.Background(), fileURL(fpath), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Small writes are collected, large ones passed through in order.
+	for i = 0; i < 100; i++ {
+		var record = []byte(fmt.Sprintf("record %d\n", i))
+
+		if i%10 == 9 {
+			record = bytes.Repeat(record, 10)
+		}
+		want.Write(record)
+
+		_, err = w.Write(context.Background(), record)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = w.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectContents(t, fpath, want.String())
+}
+
+/*
+benchmarkSmallWrites writes many small records to a file opened by open and
+reports the number of write system calls needed per record where possible.
+*/
+func benchmarkSmallWrites(b *testing.B,
+	open func(fileurl *url.URL) (filesystem.WriteCloser, error)) {
+	var fileurl = fileURL(filepath.Join(b.TempDir(), "records"))
+	var record = bytes.Repeat([]byte("x"), 99)
+	var w filesystem.WriteCloser
+	var before, after int64
+	var counted bool
+	var i int
+	var err error
+
+	w, err = open(fileurl)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	before, counted = writeSyscalls()
+	b.ResetTimer()
+	for i = 0; i < b.N; i++ {
+		_, err = w.Write(context.Background(), record)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	err = w.Close(context.Background())
+	b.StopTimer()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	after, _ = writeSyscalls()
+	if counted {
+		b.ReportMetric(float64(after-before)/float64(b.N), "syscalls/op")
+	}
+}
+
+func BenchmarkWriteUnbuffered(b *testing.B) {
+	benchmarkSmallWrites(b, func(fileurl *url.URL) (filesystem.WriteCloser, error) {
+		return DefaultAdapter().OpenWriter(context.Background(), fileurl)
+	})
+}
+
+func BenchmarkWriteBuffered(b *testing.B) {
+	benchmarkSmallWrites(b, func(fileurl *url.URL) (filesystem.WriteCloser, error) {
+		return DefaultAdapter().OpenBufferedWriter(context.Background(), fileurl, 0)
+	})
+}
+
+/*
+brokenPipe returns the write end of a pipe whose read end is closed, so that
+all writes to it fail.
+*/
+func brokenPipe(t *testing.T) *ContextRespectingIoFile {
+	var r, w *os.File
+	var err error
+
+	r, w, err = os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	return NewContextRespectingIoFile(w)
+}
+
+func TestCloseAfterFailedFlush(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		wrap func(f *ContextRespectingIoFile) filesystem.WriteCloser
+	}{
+		{"buffered", func(f *ContextRespectingIoFile) filesystem.WriteCloser {
+			return NewBufferedWriter(f, 0)
+		}},
+		{"gzip", func(f *ContextRespectingIoFile) filesystem.WriteCloser {
+			var cw = &contextWriter{ctx: context.Background(), w: f}
+			return &GzipWriter{file: f, cw: cw, gz: gzip.NewWriter(cw)}
+		}},
+	} {
+		var before, after int
+		var counted bool
+		var f *ContextRespectingIoFile
+		var w filesystem.WriteCloser
+		var err error
+
+		before, counted = openDescriptors()
+		f = brokenPipe(t)
+		w = test.wrap(f)
+
+		// The data stays in memory until the writer is closed, but the gzip
+		// header may already fail to be written.
+		w.Write(context.Background(), []byte("data"))
+		err = w.Close(context.Background())
+		if err == nil {
+			t.Errorf("%s: closing succeeded although the data couldn't be written", test.name)
+		}
+
+		err = f.Close(context.Background())
+		if !errors.Is(err, os.ErrClosed) {
+			t.Errorf("%s: file was left open after the failed close: %v", test.name, err)
+		}
+		after, _ = openDescriptors()
+		if counted && after != before {
+			t.Errorf("%s: %d descriptors open after closing, %d before", test.name, after, before)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package file
+
+import (
+	"math/bits"
+	"sync"
+)
+
+/*
+Sizes of the smallest and the largest pooled read buffers, as powers of two.
+Reads larger than the largest pooled size get a freshly allocated buffer.
+*/
+const (
+	minPooledBufferShift = 9
+	maxPooledBufferShift = 20
+)
+
+/*
+readBufferPools holds reusable read buffers, one pool per power-of-two size
+class, so that reading doesn't allocate a new buffer for every call.
+*/
+var readBufferPools [maxPooledBufferShift - minPooledBufferShift + 1]sync.Pool
+
+/*
+bufferSizeClass determines the index of the pool to use for buffers of the
+specified length, or -1 if buffers this large are not pooled.
+*/
+func bufferSizeClass(length int) int {
+	var shift = bits.Len(uint(length - 1))
+
+	if shift < minPooledBufferShift {
+		shift = minPooledBufferShift
+	}
+	if shift > maxPooledBufferShift {
+		return -1
+	}
+	return shift - minPooledBufferShift
+}
+
+/*
+getReadBuffer returns a buffer with room for at least the specified number of
+bytes, reusing a pooled one if possible.
+*/
+func getReadBuffer(length int) *[]byte {
+	var class = bufferSizeClass(length)
+	var pooled interface{}
+	var buf []byte
+
+	if class >= 0 {
+		pooled = readBufferPools[class].Get()
+		if pooled != nil {
+			return pooled.(*[]byte)
+		}
+		length = 1 << (class + minPooledBufferShift)
+	}
+
+	buf = make([]byte, length)
+	return &buf
+}
+
+/*
+putReadBuffer returns a buffer obtained from getReadBuffer to its pool. It
+must only be called once nothing can write to the buffer anymore; in
+particular, buffers handed to reads which may still be running after a
+cancellation must never be put back.
+*/
+func putReadBuffer(buf *[]byte) {
+	var class = bufferSizeClass(len(*buf))
+
+	if class < 0 || len(*buf) != 1<<(class+minPooledBufferShift) {
+		return
+	}
+
+	readBufferPools[class].Put(buf)
+}
+
+/*
+defaultChunkSize is the chunk size used by CopyBufferSize if it isn't set to
+a positive value.
+*/
+const defaultChunkSize = 32 * 1024
+
+/*
+CopyBufferSize is the size of the chunks in which data is streamed by
+operations reading or writing entire files, like Copy, CopyTree, Digest and
+SecureRemove, which check their context between chunks. Larger chunks mean
+fewer system calls, which may help on high-bandwidth or high-latency storage,
+at the expense of memory and of a slower reaction to cancellation. Values of
+zero or less select the default of 32KiB. It should only be changed before
+starting any such operations.
+*/
+var CopyBufferSize = defaultChunkSize
+
+/*
+newChunkBuffer allocates a buffer for streaming data in chunks of
+CopyBufferSize bytes.
+*/
+func newChunkBuffer() []byte {
+	var size = CopyBufferSize
+
+	if size <= 0 {
+		size = defaultChunkSize
+	}
+	return make([]byte, size)
+}
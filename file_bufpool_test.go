@@ -0,0 +1,169 @@
+package file
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadBufferPool(t *testing.T) {
+	var buf *[]byte
+
+	for _, length := range []int{1, 511, 512, 513, 4096, 1 << maxPooledBufferShift} {
+		buf = getReadBuffer(length)
+		if len(*buf) < length {
+			t.Errorf("buffer for %d bytes only holds %d", length, len(*buf))
+		}
+		if len(*buf)&(len(*buf)-1) != 0 {
+			t.Errorf("buffer for %d bytes holds %d, not a power of two", length, len(*buf))
+		}
+		putReadBuffer(buf)
+	}
+
+	// Buffers too large for the pools are allocated exactly.
+	buf = getReadBuffer(1<<maxPooledBufferShift + 1)
+	if len(*buf) != 1<<maxPooledBufferShift+1 {
+		t.Errorf("unpooled buffer holds %d bytes", len(*buf))
+	}
+	putReadBuffer(buf)
+}
+
+/*
+sink keeps buffers allocated in benchmarks reachable, so that the allocations
+aren't optimized away.
+*/
+var sink []byte
+
+func BenchmarkReadBufferPooled(b *testing.B) {
+	var buf *[]byte
+	var i int
+
+	b.ReportAllocs()
+	for i = 0; i < b.N; i++ {
+		buf = getReadBuffer(32 * 1024)
+		sink = *buf
+		putReadBuffer(buf)
+	}
+}
+
+func BenchmarkReadBufferAllocated(b *testing.B) {
+	var i int
+
+	b.ReportAllocs()
+	for i = 0; i < b.N; i++ {
+		sink = make([]byte, 32*1024)
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	var fpath = filepath.Join(b.TempDir(), "data")
+	var buf = make([]byte, 32*1024)
+	var f *ContextRespectingIoFile
+	var i int
+	var err error
+
+	writeTestFile(b, fpath, strings.Repeat("x", 32*len(buf)))
+	f = openTestFile(b, fpath)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(buf)))
+	for i = 0; i < b.N; i++ {
+		_, err = f.Read(context.Background(), buf)
+		if err == io.EOF {
+			_, err = f.Seek(context.Background(), 0, io.SeekStart)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+/*
+withCopyBufferSize sets CopyBufferSize to size until the end of the test or
+benchmark.
+*/
+func withCopyBufferSize(tb testing.TB, size int) {
+	var saved = CopyBufferSize
+
+	CopyBufferSize = size
+	tb.Cleanup(func() {
+		CopyBufferSize = saved
+	})
+}
+
+func TestCopyBufferSize(t *testing.T) {
+	var dir = testDir(t)
+	var data = bytes.Repeat([]byte("0123456789"), 10*1024+3)
+	var want = sha256.Sum256(data)
+
+	writeTestFile(t, filepath.Join(dir, "src"), string(data))
+
+	// Odd sizes make the last chunk a partial one.
+	for _, size := range []int{1, 7, 4096, defaultChunkSize, 1 << 20, 0, -1} {
+		var dst = filepath.Join(dir, fmt.Sprintf("copy%d", size))
+		var copied []byte
+		var sum []byte
+		var err error
+
+		withCopyBufferSize(t, size)
+
+		err = DefaultAdapter().Copy(context.Background(), fileURL(filepath.Join(dir, "src")),
+			fileURL(dst))
+		if err != nil {
+			t.Errorf("copying with %d byte chunks: %v", size, err)
+			continue
+		}
+		copied, err = os.ReadFile(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(copied, data) {
+			t.Errorf("copy made with %d byte chunks differs from the original", size)
+		}
+
+		sum, err = DefaultAdapter().Digest(context.Background(), fileURL(dst), sha256.New())
+		if err != nil {
+			t.Errorf("digest with %d byte chunks: %v", size, err)
+		} else if !bytes.Equal(sum, want[:]) {
+			t.Errorf("digest with %d byte chunks is %x, want %x", size, sum, want)
+		}
+	}
+}
+
+func benchmarkCopyBufferSize(b *testing.B, size int) {
+	var dir = b.TempDir()
+	var src = fileURL(filepath.Join(dir, "src"))
+	var dst = fileURL(filepath.Join(dir, "dst"))
+	var i int
+	var err error
+
+	writeTestFile(b, filepath.Join(dir, "src"), strings.Repeat("x", 4<<20))
+	withCopyBufferSize(b, size)
+
+	b.SetBytes(4 << 20)
+	b.ResetTimer()
+	for i = 0; i < b.N; i++ {
+		err = DefaultAdapter().Copy(context.Background(), src, dst)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCopyBufferSize4K(b *testing.B) {
+	benchmarkCopyBufferSize(b, 4*1024)
+}
+
+func BenchmarkCopyBufferSize32K(b *testing.B) {
+	benchmarkCopyBufferSize(b, 32*1024)
+}
+
+func BenchmarkCopyBufferSize1M(b *testing.B) {
+	benchmarkCopyBufferSize(b, 1<<20)
+}
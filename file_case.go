@@ -0,0 +1,83 @@
+package file
+
+import (
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func asyncIsCaseSensitive(dirpath string, rch chan bool, errch chan error) {
+	var probe *os.File
+	var fi, upper os.FileInfo
+	var name string
+	var err error
+
+	// The pattern contains only lower case letters, so the upper case
+	// version of the name is guaranteed to be different.
+	probe, err = os.CreateTemp(dirpath, ".casetest-*")
+	if err != nil {
+		errch <- err
+		return
+	}
+	name = probe.Name()
+
+	fi, err = probe.Stat()
+	probe.Close()
+	if err != nil {
+		os.Remove(name)
+		errch <- err
+		return
+	}
+
+	upper, err = os.Lstat(filepath.Join(dirpath, strings.ToUpper(filepath.Base(name))))
+
+	// Clean up before reporting, so the caller never gets to see the probe.
+	os.Remove(name)
+
+	if os.IsNotExist(err) {
+		rch <- true
+		return
+	} else if err != nil {
+		errch <- err
+		return
+	}
+
+	// Some other file may happen to have the upper case name.
+	rch <- !os.SameFile(fi, upper)
+}
+
+/*
+IsCaseSensitive determines whether file names in the directory pointed to are
+case sensitive, i.e. whether "Foo" and "foo" refer to different files. Since
+this depends on the file system and can even differ between directories on
+the same one, it is probed by creating a temporary file in the directory and
+looking it up under a name differing only in case. The temporary file is
+removed again afterwards, so the directory must be writable. The probe runs
+in a subthread so that we have a guaranteed response time from this function
+in case the operation exceeds the alotted time limits.
+*/
+func (file *FileAdapter) IsCaseSensitive(ctx context.Context, dirurl *url.URL) (bool, error) {
+	var rch = make(chan bool, 1)
+	var errch = make(chan error, 1)
+	var sensitive bool
+	var dirpath string
+	var err error
+
+	dirpath, err = file.localPath(dirurl)
+	if err != nil {
+		return false, err
+	}
+
+	go asyncIsCaseSensitive(dirpath, rch, errch)
+
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case err = <-errch:
+		return false, urlError("probe", dirurl, err)
+	case sensitive = <-rch:
+		return sensitive, nil
+	}
+}
@@ -0,0 +1,35 @@
+package file
+
+import (
+	"golang.org/x/net/context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsCaseSensitive(t *testing.T) {
+	var dir = testDir(t)
+	var sensitive, want bool
+	var entries []os.DirEntry
+	var err error
+
+	writeTestFile(t, filepath.Join(dir, "keep"), "")
+	_, err = os.Stat(filepath.Join(dir, "KEEP"))
+	want = os.IsNotExist(err)
+
+	sensitive, err = DefaultAdapter().IsCaseSensitive(context.Background(), fileURL(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sensitive != want {
+		t.Errorf("reported case sensitivity %v, but looking up KEEP says %v", sensitive, want)
+	}
+
+	entries, err = os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "keep" {
+		t.Errorf("probing left files behind: %v", entries)
+	}
+}
@@ -0,0 +1,68 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"golang.org/x/net/context"
+	"io"
+)
+
+/*
+contextReader adapts a filesystem.ReadCloser to the io.Reader interface, so
+it can be handed to standard library code which knows nothing about contexts.
+All reads use the context currently stored in ctx.
+*/
+type contextReader struct {
+	ctx context.Context
+	r   filesystem.ReadCloser
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	return c.r.Read(c.ctx, p)
+}
+
+/*
+contextWriter adapts a filesystem.WriteCloser to the io.Writer interface, so
+it can be handed to standard library code which knows nothing about contexts.
+All writes use the context currently stored in ctx.
+*/
+type contextWriter struct {
+	ctx context.Context
+	w   filesystem.WriteCloser
+}
+
+func (c *contextWriter) Write(p []byte) (int, error) {
+	return c.w.Write(c.ctx, p)
+}
+
+/*
+contextFile adapts a ContextRespectingIoFile to io.ReadSeekCloser, using the
+same context for all operations.
+*/
+type contextFile struct {
+	ctx context.Context
+	f   *ContextRespectingIoFile
+}
+
+func (c *contextFile) Read(p []byte) (int, error) {
+	return c.f.Read(c.ctx, p)
+}
+
+func (c *contextFile) Seek(offset int64, whence int) (int64, error) {
+	return c.f.Seek(c.ctx, offset, whence)
+}
+
+func (c *contextFile) Close() error {
+	return c.f.Close(c.ctx)
+}
+
+/*
+WithContext() returns a view of the file as an io.ReadSeekCloser, for use with
+standard library functions like http.ServeContent which know nothing about
+contexts. All operations on it are bound to ctx, so once ctx is done, they
+fail with its error. The view shares the file and its offset with f; closing
+it closes f.
+*/
+func (f *ContextRespectingIoFile) WithContext(ctx context.Context) io.ReadSeekCloser {
+	return &contextFile{ctx: ctx, f: f}
+}
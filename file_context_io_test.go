@@ -0,0 +1,91 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"golang.org/x/net/context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWithContext(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var ctx, cancel = context.WithCancel(context.Background())
+	var f *ContextRespectingIoFile
+	var rsc io.ReadSeekCloser
+	var buf bytes.Buffer
+	var data []byte
+	var n int64
+	var err error
+
+	defer cancel()
+	writeTestFile(t, fpath, "0123456789")
+	f = openTestFile(t, fpath)
+	rsc = f.WithContext(ctx)
+
+	n, err = io.Copy(&buf, rsc)
+	if err != nil || buf.String() != "0123456789" {
+		t.Errorf("copied %d bytes %q, %v, want all of the file", n, buf.String(), err)
+	}
+
+	n, err = rsc.Seek(-4, io.SeekEnd)
+	if err != nil || n != 6 {
+		t.Fatalf("seeking to 4 bytes before the end returned %d, %v", n, err)
+	}
+	data, err = io.ReadAll(rsc)
+	if err != nil || string(data) != "6789" {
+		t.Errorf("read %q, %v after seeking, want %q", data, err, "6789")
+	}
+
+	// The offset is shared with the file.
+	n, err = f.Tell(context.Background())
+	if err != nil || n != 10 {
+		t.Errorf("file is at offset %d, %v, want 10", n, err)
+	}
+
+	cancel()
+	_, err = rsc.Seek(0, io.SeekStart)
+	if err != context.Canceled {
+		t.Errorf("seeking after the context was cancelled returned %v, want %v",
+			err, context.Canceled)
+	}
+	_, err = rsc.Read(make([]byte, 10))
+	if err != context.Canceled {
+		t.Errorf("reading after the context was cancelled returned %v, want %v",
+			err, context.Canceled)
+	}
+}
+
+func TestWithContextServeContent(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var f *ContextRespectingIoFile
+	var req *http.Request
+	var rec = httptest.NewRecorder()
+	var err error
+
+	writeTestFile(t, fpath, "0123456789")
+	f = openTestFile(t, fpath)
+
+	req = httptest.NewRequest("GET", "/data", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	http.ServeContent(rec, req, "data", time.Time{}, f.WithContext(context.Background()))
+
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "2345" {
+		t.Errorf("served %d %q, want %d %q", rec.Code, rec.Body.String(),
+			http.StatusPartialContent, "2345")
+	}
+
+	err = f.WithContext(context.Background()).Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Read(context.Background(), make([]byte, 10))
+	if !errors.Is(err, os.ErrClosed) {
+		t.Errorf("reading after closing the view returned %v, want %v", err, os.ErrClosed)
+	}
+}
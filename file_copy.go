@@ -0,0 +1,915 @@
+package file
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ErrUnsupportedFileType is returned when copying encounters something other
+than a regular file, directory or symbolic link, e.g. a device or socket.
+*/
+var ErrUnsupportedFileType = errors.New("unsupported file type")
+
+/*
+ErrSameFile is returned when the source and destination of a copy are the same
+file, e.g. because they are hard links to each other. Copying would truncate
+the source.
+*/
+var ErrSameFile = errors.New("source and destination are the same file")
+
+/*
+ErrCopyIntoItself is returned by CopyTree when the destination lies inside the
+source directory, which would make the copy recurse into itself.
+*/
+var ErrCopyIntoItself = errors.New("cannot copy a directory into itself")
+
+/*
+errSparseUnsupported is returned by copySparse when holes in the source file
+cannot be determined, before anything has been copied.
+*/
+var errSparseUnsupported = errors.New("sparse copies not supported")
+
+/*
+CopyOptions controls the behavior of copy operations. A nil *CopyOptions is
+equivalent to the zero value.
+*/
+type CopyOptions struct {
+	// FollowSymlinks makes symbolic links be copied as whatever they point
+	// to. By default, symbolic links are recreated as links with the same
+	// target at the destination.
+	FollowSymlinks bool
+
+	// Sparse makes holes in the source files be skipped rather than copied
+	// as zeros, so the copies stay sparse as well. This is only done where
+	// the operating system and file system can report holes; elsewhere,
+	// files are copied in full.
+	Sparse bool
+
+	// PreserveTimes makes copied files and directories get the access and
+	// modification times of their source, as they were before the copy
+	// read it. On platforms where the access time cannot be determined, it
+	// is set to the time of the copy instead. The times of symbolic links
+	// themselves are not preserved.
+	PreserveTimes bool
+
+	// Concurrency is the number of files CopyTree copies at the same time.
+	// Values below 2 make it copy one file after the other. If several files
+	// are copied at once, the copy carries on after failing to copy a file,
+	// and all errors are reported together at the end.
+	Concurrency int
+}
+
+/*
+progressInterval is the minimum time between two progress reports during a
+copy, apart from the final one.
+*/
+const progressInterval = 100 * time.Millisecond
+
+/*
+copyFile copies the contents of the regular file src, described by fi, to
+dst, creating or truncating dst and giving it the permission bits of src.
+The context is checked between chunks. If report is not nil, it is called
+with the number of bytes copied so far after every chunk. Holes are skipped
+and times preserved as requested in opts.
+*/
+func copyFile(ctx context.Context, src, dst string, fi os.FileInfo, opts *CopyOptions,
+	report func(copied int64)) error {
+	var perm = fi.Mode().Perm()
+	var in, out *os.File
+	var buf = newChunkBuffer()
+	var err error
+
+	in, err = os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	err = checkNotSameFile(in, dst)
+	if err != nil {
+		return err
+	}
+
+	out, err = os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	err = errSparseUnsupported
+	if opts.Sparse {
+		err = copySparse(ctx, in, out, buf, report)
+	}
+	if err == errSparseUnsupported {
+		err = copyDense(ctx, in, out, buf, report)
+	}
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	// The mode passed to OpenFile is subject to the umask and ignored for
+	// existing files, so set it explicitly.
+	err = out.Chmod(perm)
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+
+	return copyTimes(dst, fi, opts)
+}
+
+/*
+checkNotSameFile returns an error wrapping ErrSameFile if dst refers to the
+already opened source file in, so that opening dst for writing doesn't
+truncate it.
+*/
+func checkNotSameFile(in *os.File, dst string) error {
+	var infi, dstfi os.FileInfo
+	var err error
+
+	dstfi, err = os.Stat(dst)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	infi, err = in.Stat()
+	if err != nil {
+		return err
+	}
+
+	if os.SameFile(infi, dstfi) {
+		return &os.PathError{Op: "copy", Path: dst, Err: ErrSameFile}
+	}
+	return nil
+}
+
+/*
+checkNotInside returns an error wrapping ErrCopyIntoItself if dst is the
+directory described by dirfi or lies anywhere below it.
+*/
+func checkNotInside(dst string, dirfi os.FileInfo) error {
+	var current string
+	var err error
+
+	current, err = filepath.Abs(dst)
+	if err != nil {
+		return err
+	}
+
+	for {
+		var fi os.FileInfo
+		var parent string
+
+		fi, err = os.Stat(current)
+		if err == nil && os.SameFile(fi, dirfi) {
+			return &os.PathError{Op: "copy", Path: dst, Err: ErrCopyIntoItself}
+		}
+
+		parent = filepath.Dir(current)
+		if parent == current {
+			return nil
+		}
+		current = parent
+	}
+}
+
+/*
+copySymlink recreates the symbolic link src at dst with the same target,
+replacing whatever non-directory is at dst already.
+*/
+func copySymlink(src, dst string) error {
+	var target string
+	var fi os.FileInfo
+	var err error
+
+	target, err = os.Readlink(src)
+	if err != nil {
+		return err
+	}
+
+	err = os.Symlink(target, dst)
+	if !errors.Is(err, os.ErrExist) {
+		return err
+	}
+
+	fi, err = os.Lstat(dst)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return &os.PathError{Op: "symlink", Path: dst, Err: ErrIsDirectory}
+	}
+
+	err = os.Remove(dst)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(target, dst)
+}
+
+/*
+copyTimes gives dst the access and modification times from fi if opts asks
+for it.
+*/
+func copyTimes(dst string, fi os.FileInfo, opts *CopyOptions) error {
+	var atime time.Time
+	var ok bool
+
+	if !opts.PreserveTimes {
+		return nil
+	}
+
+	atime, ok = accessTime(fi)
+	if !ok {
+		atime = time.Now()
+	}
+	return os.Chtimes(dst, atime, fi.ModTime())
+}
+
+/*
+copyDense copies everything from in to out using buf, checking the context
+between chunks.
+*/
+func copyDense(ctx context.Context, in, out *os.File, buf []byte,
+	report func(copied int64)) error {
+	var copied int64
+	var err error
+
+	for {
+		var n int
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		n, err = in.Read(buf)
+		if n > 0 {
+			_, err = out.Write(buf[:n])
+			if err != nil {
+				return err
+			}
+			copied += int64(n)
+			if report != nil {
+				report(copied)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+/*
+copyRange copies the length bytes at offset off from in to the same offset in
+out using buf, checking the context between chunks. Progress is reported as
+the offset up to which the file has been copied.
+*/
+func copyRange(ctx context.Context, in, out *os.File, off, length int64, buf []byte,
+	report func(copied int64)) error {
+	var end = off + length
+	var err error
+
+	for off < end {
+		var chunk = buf
+		var n int
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if int64(len(chunk)) > end-off {
+			chunk = chunk[:end-off]
+		}
+
+		n, err = in.ReadAt(chunk, off)
+		if n > 0 {
+			_, err = out.WriteAt(chunk[:n], off)
+			if err != nil {
+				return err
+			}
+			off += int64(n)
+			if report != nil {
+				report(off)
+			}
+		}
+		if err == io.EOF {
+			// The file shrank while copying.
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+treeCopier holds the state of a CopyTree operation. If files are copied
+concurrently, the tree is walked by one goroutine, which creates all
+directories and hands the files to a pool of workers. Since directories may
+need to stay writable until their files have been copied, their modes and
+times are only applied once all workers are done.
+*/
+type treeCopier struct {
+	opts *CopyOptions
+
+	// ancestors holds the resolved paths of the directories currently being
+	// walked, to detect loops formed by symbolic links when following them.
+	ancestors map[string]bool
+
+	// jobs is nil if files are copied one by one.
+	jobs    chan copyJob
+	workers sync.WaitGroup
+
+	// mu protects errs, which collects errors from the workers.
+	mu   sync.Mutex
+	errs []error
+
+	// dirs holds the directories to be finished after copying all files,
+	// innermost last.
+	dirs []copyJob
+}
+
+/*
+copyJob describes a file or directory to which copying must be applied.
+*/
+type copyJob struct {
+	src, dst string
+	fi       os.FileInfo
+}
+
+func newTreeCopier(opts *CopyOptions) *treeCopier {
+	return &treeCopier{
+		opts:      opts,
+		ancestors: make(map[string]bool),
+	}
+}
+
+/*
+startWorkers starts the pool of goroutines copying files concurrently, if
+asked for by the options.
+*/
+func (c *treeCopier) startWorkers(ctx context.Context) {
+	var i int
+
+	if c.opts.Concurrency < 2 {
+		return
+	}
+
+	c.jobs = make(chan copyJob)
+	for i = 0; i < c.opts.Concurrency; i++ {
+		c.workers.Add(1)
+		go func() {
+			var job copyJob
+			var err error
+
+			defer c.workers.Done()
+
+			for job = range c.jobs {
+				err = copyFile(ctx, job.src, job.dst, job.fi, c.opts, nil)
+				if err != nil {
+					c.mu.Lock()
+					c.errs = append(c.errs, err)
+					c.mu.Unlock()
+				}
+			}
+		}()
+	}
+}
+
+/*
+finish waits for the workers to copy all files handed to them and applies
+the modes and times of the directories, innermost first. The error from
+walking the tree, if any, is reported along with any errors from the
+workers.
+*/
+func (c *treeCopier) finish(walkErr error) error {
+	var i int
+	var err error
+
+	if c.jobs == nil {
+		return walkErr
+	}
+
+	close(c.jobs)
+	c.workers.Wait()
+
+	if walkErr != nil {
+		c.errs = append([]error{walkErr}, c.errs...)
+	}
+
+	for i = len(c.dirs) - 1; i >= 0; i-- {
+		err = finishDir(c.dirs[i].dst, c.dirs[i].fi, c.opts)
+		if err != nil {
+			c.errs = append(c.errs, err)
+		}
+	}
+
+	return errors.Join(c.errs...)
+}
+
+/*
+finishDir gives the copied directory dst the mode and, if requested, the
+times of its source.
+*/
+func finishDir(dst string, fi os.FileInfo, opts *CopyOptions) error {
+	var err error
+
+	err = os.Chmod(dst, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	// Copying the entries has changed the modification time.
+	return copyTimes(dst, fi, opts)
+}
+
+/*
+copyTree recursively copies src to dst.
+*/
+func (c *treeCopier) copyTree(ctx context.Context, src, dst string) error {
+	var fi os.FileInfo
+	var err error
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	fi, err = os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	if fi.Mode()&os.ModeSymlink == os.ModeSymlink {
+		if !c.opts.FollowSymlinks {
+			return copySymlink(src, dst)
+		}
+
+		fi, err = os.Stat(src)
+		if err != nil {
+			return err
+		}
+	}
+
+	if fi.IsDir() {
+		var dir *os.File
+		var names []string
+		var name string
+		var resolved string
+
+		resolved, err = filepath.EvalSymlinks(src)
+		if err != nil {
+			return err
+		}
+		if c.ancestors[resolved] {
+			return &os.PathError{Op: "copy", Path: src, Err: ErrSymlinkCycle}
+		}
+		c.ancestors[resolved] = true
+		defer delete(c.ancestors, resolved)
+
+		// Otherwise, the copy would find itself among the entries.
+		err = checkNotInside(dst, fi)
+		if err != nil {
+			return err
+		}
+
+		// Make sure we can write to the directory while copying, even if
+		// the source directory is read-only; the real mode is set after.
+		err = os.MkdirAll(dst, 0700)
+		if err != nil {
+			return err
+		}
+
+		dir, err = os.Open(src)
+		if err != nil {
+			return err
+		}
+		names, err = readDirNames(ctx, dir)
+		dir.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, name = range names {
+			err = c.copyTree(ctx, filepath.Join(src, name), filepath.Join(dst, name))
+			if err != nil {
+				return err
+			}
+		}
+
+		if c.jobs != nil {
+			c.dirs = append(c.dirs, copyJob{src: src, dst: dst, fi: fi})
+			return nil
+		}
+		return finishDir(dst, fi, c.opts)
+	}
+
+	if !fi.Mode().IsRegular() {
+		return &os.PathError{Op: "copy", Path: src, Err: ErrUnsupportedFileType}
+	}
+
+	if c.jobs != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case c.jobs <- copyJob{src: src, dst: dst, fi: fi}:
+			return nil
+		}
+	}
+
+	return copyFile(ctx, src, dst, fi, c.opts, nil)
+}
+
+func asyncCopyTree(ctx context.Context, src, dst string, opts *CopyOptions, errch chan error) {
+	var c = newTreeCopier(opts)
+
+	c.startWorkers(ctx)
+	errch <- c.finish(c.copyTree(ctx, src, dst))
+}
+
+/*
+CopyTree recursively copies the file or directory tree pointed to by srcurl to
+dsturl, recreating the directory structure and copying all files along with
+their permission bits. Existing directories at the destination are merged
+into, existing files and symbolic links overwritten. The destination must not
+lie inside the source directory, otherwise an error wrapping ErrCopyIntoItself
+is returned before anything is copied; files which would be copied onto
+themselves are rejected with ErrSameFile. Symbolic links are handled according
+to opts, which may be nil, as is the number of files copied at once. The
+actual copying happens in a subthread which checks the context regularly, so
+cancelling the context stops the copy shortly after.
+*/
+func (file *FileAdapter) CopyTree(ctx context.Context, srcurl, dsturl *url.URL,
+	opts *CopyOptions) error {
+	var errch = make(chan error, 1)
+	var src, dst string
+	var err error
+
+	if opts == nil {
+		opts = new(CopyOptions)
+	}
+
+	src, err = file.localPath(srcurl)
+	if err != nil {
+		return err
+	}
+
+	dst, err = file.localPath(dsturl)
+	if err != nil {
+		return err
+	}
+
+	go asyncCopyTree(ctx, src, dst, opts, errch)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-errch:
+		return urlError("copy", srcurl, err)
+	}
+}
+
+/*
+progressReporter hands progress updates to a callback running in its own
+goroutine. Updates arriving while the callback is still busy replace any
+pending one, so a slow callback only gets to see fewer updates and never
+holds up the copy.
+*/
+type progressReporter struct {
+	updates  chan int64
+	finished chan struct{}
+	last     time.Time
+}
+
+func newProgressReporter(total int64, progress func(copied, total int64)) *progressReporter {
+	var p = &progressReporter{
+		updates:  make(chan int64, 1),
+		finished: make(chan struct{}),
+	}
+
+	go func() {
+		var copied int64
+
+		defer close(p.finished)
+		for copied = range p.updates {
+			progress(copied, total)
+		}
+	}()
+
+	return p
+}
+
+/*
+offer passes copied on to the callback, replacing any update it hasn't
+picked up yet. It is only ever called from the copying goroutine.
+*/
+func (p *progressReporter) offer(copied int64) {
+	select {
+	case p.updates <- copied:
+		return
+	default:
+	}
+
+	select {
+	case <-p.updates:
+	default:
+	}
+	p.updates <- copied
+}
+
+/*
+report offers copied to the callback unless the last update was offered less
+than progressInterval ago.
+*/
+func (p *progressReporter) report(copied int64) {
+	var now = time.Now()
+
+	if now.Sub(p.last) < progressInterval {
+		return
+	}
+	p.last = now
+	p.offer(copied)
+}
+
+/*
+finish offers the final state and stops the callback goroutine once it has
+seen it.
+*/
+func (p *progressReporter) finish(copied int64) {
+	p.offer(copied)
+	p.stop()
+}
+
+/*
+stop waits for the callback goroutine to hand the pending update, if any, to
+the callback and to exit, so that the callback isn't running anymore.
+*/
+func (p *progressReporter) stop() {
+	close(p.updates)
+	<-p.finished
+}
+
+func asyncCopy(ctx context.Context, src, dst string, opts *CopyOptions,
+	progress func(copied, total int64), errch chan error) {
+	var fi os.FileInfo
+	var reporter *progressReporter
+	var report func(int64)
+	var err error
+
+	fi, err = os.Stat(src)
+	if err != nil {
+		errch <- err
+		return
+	}
+	if fi.IsDir() {
+		errch <- &os.PathError{Op: "copy", Path: src, Err: ErrIsDirectory}
+		return
+	}
+	if !fi.Mode().IsRegular() {
+		errch <- &os.PathError{Op: "copy", Path: src, Err: ErrUnsupportedFileType}
+		return
+	}
+
+	err = makeDirs(filepath.Dir(dst))
+	if err != nil {
+		errch <- err
+		return
+	}
+
+	if progress != nil {
+		reporter = newProgressReporter(fi.Size(), progress)
+		report = reporter.report
+	}
+
+	err = copyFile(ctx, src, dst, fi, opts, report)
+	if reporter != nil {
+		if err == nil {
+			reporter.finish(fi.Size())
+		} else {
+			reporter.stop()
+		}
+	}
+	errch <- err
+}
+
+/*
+Copy copies the contents and permission bits of the file pointed to by srcurl
+to dsturl, creating the parent directories of dsturl as required and
+overwriting any existing file. Symbolic links are followed; directories are
+rejected with ErrIsDirectory (see CopyTree for those). If srcurl and dsturl
+refer to the same file, e.g. through hard or symbolic links, the error wraps
+ErrSameFile and the file is left alone. The actual copying happens in a
+subthread which checks the context regularly, so cancelling the context stops
+the copy shortly after.
+*/
+func (file *FileAdapter) Copy(ctx context.Context, srcurl, dsturl *url.URL) error {
+	return file.copyURL(ctx, srcurl, dsturl, nil, nil)
+}
+
+/*
+CopyWithOptions works like Copy, but allows for tuning the copy through opts,
+which may be nil. Symbolic links are always followed.
+*/
+func (file *FileAdapter) CopyWithOptions(ctx context.Context, srcurl, dsturl *url.URL,
+	opts *CopyOptions) error {
+	return file.copyURL(ctx, srcurl, dsturl, opts, nil)
+}
+
+/*
+CopyWithProgress works like Copy, but periodically calls progress with the
+number of bytes copied so far and the size of the source file as determined
+when the copy started. The callback runs in a separate goroutine; if it is
+slow, intermediate updates are dropped rather than slowing down the copy.
+Once a copy succeeds, progress has been called with copied == total for the
+last time before CopyWithProgress returns; if it fails, progress isn't called
+anymore either once the error is returned. Only if CopyWithProgress returns
+because the context is done may a last call to progress still be underway.
+progress may be nil.
+*/
+func (file *FileAdapter) CopyWithProgress(ctx context.Context, srcurl, dsturl *url.URL,
+	progress func(copied, total int64)) error {
+	return file.copyURL(ctx, srcurl, dsturl, nil, progress)
+}
+
+func asyncCopyN(ctx context.Context, src, dst string, n int64, copied *atomic.Int64,
+	errch chan error) {
+	var in, out *os.File
+	var fi os.FileInfo
+	var buf = newChunkBuffer()
+	var err error
+
+	in, err = os.Open(src)
+	if err != nil {
+		errch <- err
+		return
+	}
+	defer in.Close()
+
+	fi, err = in.Stat()
+	if err != nil {
+		errch <- err
+		return
+	}
+	if fi.IsDir() {
+		errch <- &os.PathError{Op: "copy", Path: src, Err: ErrIsDirectory}
+		return
+	}
+
+	err = makeDirs(filepath.Dir(dst))
+	if err != nil {
+		errch <- err
+		return
+	}
+
+	err = checkNotSameFile(in, dst)
+	if err != nil {
+		errch <- err
+		return
+	}
+
+	out, err = os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		errch <- err
+		return
+	}
+
+	for copied.Load() < n {
+		var chunk = buf
+		var length int
+
+		if ctx.Err() != nil {
+			out.Close()
+			errch <- ctx.Err()
+			return
+		}
+
+		if n-copied.Load() < int64(len(chunk)) {
+			chunk = chunk[:n-copied.Load()]
+		}
+
+		length, err = readRetrying(in, chunk)
+		if length > 0 {
+			_, err = out.Write(chunk[:length])
+			if err != nil {
+				out.Close()
+				errch <- err
+				return
+			}
+			copied.Add(int64(length))
+		}
+		if err == io.EOF {
+			out.Close()
+			errch <- io.ErrUnexpectedEOF
+			return
+		} else if err != nil {
+			out.Close()
+			errch <- err
+			return
+		}
+	}
+
+	// As for Copy, the mode of existing files is set explicitly.
+	err = out.Chmod(fi.Mode().Perm())
+	if err != nil {
+		out.Close()
+		errch <- err
+		return
+	}
+
+	errch <- out.Close()
+}
+
+/*
+CopyN copies the first n bytes of the file pointed to by srcurl to dsturl,
+creating the parent directories of dsturl as required and overwriting any
+existing file, which gets the permission bits of the source. It returns the
+number of bytes copied, which is n unless an error occurred; if the source
+ends before n bytes could be copied, the error wraps io.ErrUnexpectedEOF and
+the destination holds all the data there was. Unlike Copy, CopyN also accepts
+sources other than regular files, like named pipes, and doesn't skip holes.
+Like Copy, it refuses to copy a file onto itself with ErrSameFile. A negative
+n is rejected with an error wrapping os.ErrInvalid before anything is touched.
+The actual copying happens in a subthread which checks the context between
+chunks. If the context is done first, the number of bytes copied by then is
+returned along with the context's error.
+*/
+func (file *FileAdapter) CopyN(ctx context.Context, srcurl, dsturl *url.URL, n int64) (
+	int64, error) {
+	var copied = new(atomic.Int64)
+	var errch = make(chan error, 1)
+	var src, dst string
+	var err error
+
+	if n < 0 {
+		return 0, urlError("copy", srcurl, os.ErrInvalid)
+	}
+
+	src, err = file.localPath(srcurl)
+	if err != nil {
+		return 0, err
+	}
+
+	dst, err = file.localPath(dsturl)
+	if err != nil {
+		return 0, err
+	}
+
+	go asyncCopyN(ctx, src, dst, n, copied, errch)
+
+	select {
+	case <-ctx.Done():
+		return copied.Load(), ctx.Err()
+	case err = <-errch:
+		return copied.Load(), urlError("copy", srcurl, err)
+	}
+}
+
+/*
+copyURL implements the Copy family of methods.
+*/
+func (file *FileAdapter) copyURL(ctx context.Context, srcurl, dsturl *url.URL,
+	opts *CopyOptions, progress func(copied, total int64)) error {
+	var errch = make(chan error, 1)
+	var src, dst string
+	var err error
+
+	if opts == nil {
+		opts = new(CopyOptions)
+	}
+
+	src, err = file.localPath(srcurl)
+	if err != nil {
+		return err
+	}
+
+	dst, err = file.localPath(dsturl)
+	if err != nil {
+		return err
+	}
+
+	go asyncCopy(ctx, src, dst, opts, progress, errch)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-errch:
+		return urlError("copy", srcurl, err)
+	}
+}
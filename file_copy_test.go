@@ -0,0 +1,647 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+symlinkOrSkip creates a symbolic link, skipping the test on systems where
+that requires privileges the test doesn't have.
+*/
+func symlinkOrSkip(t *testing.T, target, link string) {
+	var err error
+
+	err = os.Symlink(target, link)
+	if err != nil {
+		t.Skipf("cannot create symbolic links: %v", err)
+	}
+}
+
+/*
+expectContents checks that the file fpath holds exactly want.
+*/
+func expectContents(t *testing.T, fpath, want string) {
+	var data []byte
+	var err error
+
+	t.Helper()
+
+	data, err = os.ReadFile(fpath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(data) != want {
+		t.Errorf("%s contains %q, want %q", fpath, string(data), want)
+	}
+}
+
+/*
+makeTestTree creates a small directory tree with nested directories below
+dir, returning the relative paths of the files in it and their contents.
+*/
+func makeTestTree(t *testing.T, dir string) map[string]string {
+	var files = map[string]string{
+		"top.txt":            "top",
+		"a/middle.txt":       "middle",
+		"a/b/bottom.txt":     "bottom",
+		"a/b/c/deepest.txt":  "deepest",
+		"empty/.placeholder": "",
+	}
+	var name, contents string
+	var err error
+
+	for name, contents = range files {
+		var fpath = filepath.Join(dir, filepath.FromSlash(name))
+
+		err = os.MkdirAll(filepath.Dir(fpath), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, fpath, contents)
+	}
+
+	return files
+}
+
+func TestCopyTreeNested(t *testing.T) {
+	var dir = testDir(t)
+	var src = filepath.Join(dir, "src")
+	var dst = filepath.Join(dir, "dst")
+	var files map[string]string
+	var name, contents string
+	var fi os.FileInfo
+	var err error
+
+	files = makeTestTree(t, src)
+	err = os.Chmod(filepath.Join(src, "a", "middle.txt"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = DefaultAdapter().CopyTree(context.Background(), fileURL(src), fileURL(dst), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, contents = range files {
+		expectContents(t, filepath.Join(dst, filepath.FromSlash(name)), contents)
+	}
+
+	fi, err = os.Stat(filepath.Join(dst, "a", "middle.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("copied file has mode %v, want %v", fi.Mode().Perm(), os.FileMode(0600))
+	}
+
+	fi, err = os.Stat(filepath.Join(dst, "a", "b", "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.IsDir() {
+		t.Error("copied directory is not a directory")
+	}
+}
+
+/*
+makeWideTree creates dirs directories below dir with files files of size
+bytes each, returning the relative paths of the files and their contents.
+*/
+func makeWideTree(tb testing.TB, dir string, dirs, files, size int) map[string]string {
+	var tree = make(map[string]string)
+	var i, j int
+	var err error
+
+	for i = 0; i < dirs; i++ {
+		err = os.MkdirAll(filepath.Join(dir, fmt.Sprintf("d%02d", i)), 0755)
+		if err != nil {
+			tb.Fatal(err)
+		}
+
+		for j = 0; j < files; j++ {
+			var name = fmt.Sprintf("d%02d/f%03d", i, j)
+			var contents = string(bytes.Repeat([]byte(name), size/len(name)+1)[:size])
+
+			writeTestFile(tb, filepath.Join(dir, filepath.FromSlash(name)), contents)
+			tree[name] = contents
+		}
+	}
+
+	return tree
+}
+
+func TestCopyTreeConcurrent(t *testing.T) {
+	var dir = testDir(t)
+	var src = filepath.Join(dir, "src")
+	var dst = filepath.Join(dir, "dst")
+	var files map[string]string
+	var name, contents string
+	var fi os.FileInfo
+	var err error
+
+	files = makeWideTree(t, src, 10, 20, 100)
+
+	// The files have to be copied into the directory before it is made
+	// read-only.
+	err = os.Chmod(filepath.Join(src, "d03"), 0555)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(src, "d03"), 0755)
+
+	err = DefaultAdapter().CopyTree(context.Background(), fileURL(src), fileURL(dst),
+		&CopyOptions{Concurrency: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(dst, "d03"), 0755)
+
+	for name, contents = range files {
+		expectContents(t, filepath.Join(dst, filepath.FromSlash(name)), contents)
+	}
+
+	fi, err = os.Stat(filepath.Join(dst, "d03"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0555 {
+		t.Errorf("copied directory has mode %v, want %v", fi.Mode().Perm(), os.FileMode(0555))
+	}
+}
+
+func TestCopyTreeConcurrentErrors(t *testing.T) {
+	var dir = testDir(t)
+	var src = filepath.Join(dir, "src")
+	var dst = filepath.Join(dir, "dst")
+	var files map[string]string
+	var name, contents string
+	var err error
+
+	files = makeWideTree(t, src, 4, 10, 10)
+
+	// Files can't be copied onto directories.
+	for _, name = range []string{"d00/f005", "d02/f009"} {
+		err = os.MkdirAll(filepath.Join(dst, filepath.FromSlash(name)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = DefaultAdapter().CopyTree(context.Background(), fileURL(src), fileURL(dst),
+		&CopyOptions{Concurrency: 4})
+	if err == nil {
+		t.Fatal("copying files onto directories succeeded")
+	}
+	for _, name = range []string{"d00/f005", "d02/f009"} {
+		if !strings.Contains(err.Error(), filepath.Join(dst, filepath.FromSlash(name))) {
+			t.Errorf("error %q doesn't mention %s", err, name)
+		}
+	}
+
+	// All other files are copied anyway.
+	for name, contents = range files {
+		if name != "d00/f005" && name != "d02/f009" {
+			expectContents(t, filepath.Join(dst, filepath.FromSlash(name)), contents)
+		}
+	}
+}
+
+func benchmarkCopyTree(b *testing.B, concurrency int) {
+	var dir = b.TempDir()
+	var src = filepath.Join(dir, "src")
+	var dst = filepath.Join(dir, "dst")
+	var i int
+	var err error
+
+	makeWideTree(b, src, 10, 20, 16*1024)
+
+	b.SetBytes(10 * 20 * 16 * 1024)
+	b.ResetTimer()
+	for i = 0; i < b.N; i++ {
+		err = DefaultAdapter().CopyTree(context.Background(), fileURL(src), fileURL(dst),
+			&CopyOptions{Concurrency: concurrency})
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		err = os.RemoveAll(dst)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+	}
+}
+
+func BenchmarkCopyTreeSerial(b *testing.B) {
+	benchmarkCopyTree(b, 1)
+}
+
+func BenchmarkCopyTreeConcurrent(b *testing.B) {
+	benchmarkCopyTree(b, 8)
+}
+
+func TestCopyTreeSymlink(t *testing.T) {
+	var dir = testDir(t)
+	var src = filepath.Join(dir, "src")
+	var target string
+	var fi os.FileInfo
+	var err error
+
+	makeTestTree(t, src)
+	symlinkOrSkip(t, "top.txt", filepath.Join(src, "link"))
+
+	err = DefaultAdapter().CopyTree(context.Background(), fileURL(src),
+		fileURL(filepath.Join(dir, "links")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The link is recreated with the same, still relative target.
+	target, err = os.Readlink(filepath.Join(dir, "links", "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "top.txt" {
+		t.Errorf("copied link points to %q, want %q", target, "top.txt")
+	}
+
+	err = DefaultAdapter().CopyTree(context.Background(), fileURL(src),
+		fileURL(filepath.Join(dir, "followed")), &CopyOptions{FollowSymlinks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err = os.Lstat(filepath.Join(dir, "followed", "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.Mode().IsRegular() {
+		t.Errorf("followed link copied as %v, want a regular file", fi.Mode())
+	}
+	expectContents(t, filepath.Join(dir, "followed", "link"), "top")
+}
+
+func TestCopyTreeReplacesExistingSymlink(t *testing.T) {
+	var dir = testDir(t)
+	var src = filepath.Join(dir, "src")
+	var dst = filepath.Join(dir, "dst")
+	var target string
+	var err error
+
+	makeTestTree(t, src)
+	symlinkOrSkip(t, "top.txt", filepath.Join(src, "link"))
+
+	err = os.Mkdir(dst, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	symlinkOrSkip(t, "elsewhere", filepath.Join(dst, "link"))
+
+	err = DefaultAdapter().CopyTree(context.Background(), fileURL(src), fileURL(dst), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err = os.Readlink(filepath.Join(dst, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "top.txt" {
+		t.Errorf("overwritten link points to %q, want %q", target, "top.txt")
+	}
+}
+
+func TestCopyTreeIntoItselfRejected(t *testing.T) {
+	var src = filepath.Join(testDir(t), "src")
+	var err error
+
+	makeTestTree(t, src)
+
+	err = DefaultAdapter().CopyTree(context.Background(), fileURL(src),
+		fileURL(filepath.Join(src, "a", "copy")), nil)
+	if !errors.Is(err, ErrCopyIntoItself) {
+		t.Errorf("copying into a subdirectory returned %v, want %v", err, ErrCopyIntoItself)
+	}
+
+	_, err = os.Lstat(filepath.Join(src, "a", "copy"))
+	if !os.IsNotExist(err) {
+		t.Errorf("destination inside the source was created: %v", err)
+	}
+
+	err = DefaultAdapter().CopyTree(context.Background(), fileURL(src), fileURL(src), nil)
+	if !errors.Is(err, ErrCopyIntoItself) {
+		t.Errorf("copying onto itself returned %v, want %v", err, ErrCopyIntoItself)
+	}
+}
+
+func TestCopySameFileRejected(t *testing.T) {
+	var dir = testDir(t)
+	var fpath = filepath.Join(dir, "data")
+	var link = filepath.Join(dir, "hardlink")
+	var ctx = context.Background()
+	var err error
+
+	writeTestFile(t, fpath, "precious")
+	err = os.Link(fpath, link)
+	if err != nil {
+		t.Skipf("cannot create hard links: %v", err)
+	}
+
+	err = DefaultAdapter().Copy(ctx, fileURL(fpath), fileURL(fpath))
+	if !errors.Is(err, ErrSameFile) {
+		t.Errorf("Copy onto itself returned %v, want %v", err, ErrSameFile)
+	}
+
+	err = DefaultAdapter().CopyWithOptions(ctx, fileURL(fpath), fileURL(link), nil)
+	if !errors.Is(err, ErrSameFile) {
+		t.Errorf("CopyWithOptions onto a hard link returned %v, want %v", err, ErrSameFile)
+	}
+
+	err = DefaultAdapter().CopyWithProgress(ctx, fileURL(link), fileURL(fpath), nil)
+	if !errors.Is(err, ErrSameFile) {
+		t.Errorf("CopyWithProgress onto a hard link returned %v, want %v", err, ErrSameFile)
+	}
+
+	err = DefaultAdapter().CopyTree(ctx, fileURL(fpath), fileURL(link), nil)
+	if !errors.Is(err, ErrSameFile) {
+		t.Errorf("CopyTree onto a hard link returned %v, want %v", err, ErrSameFile)
+	}
+
+	_, err = DefaultAdapter().CopyN(ctx, fileURL(fpath), fileURL(link), 3)
+	if !errors.Is(err, ErrSameFile) {
+		t.Errorf("CopyN onto a hard link returned %v, want %v", err, ErrSameFile)
+	}
+
+	expectContents(t, fpath, "precious")
+}
+
+func TestCopyWithProgressMonotonic(t *testing.T) {
+	var dir = testDir(t)
+	var src = filepath.Join(dir, "src")
+	var data = make([]byte, 4<<20)
+	var reports []int64
+	var totals []int64
+	var mu sync.Mutex
+	var i int
+	var err error
+
+	for i = range data {
+		data[i] = byte(i)
+	}
+	err = os.WriteFile(src, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = DefaultAdapter().CopyWithProgress(context.Background(), fileURL(src),
+		fileURL(filepath.Join(dir, "dst")), func(copied, total int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			reports = append(reports, copied)
+			totals = append(totals, total)
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// All reports must have been delivered by now.
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(reports) == 0 {
+		t.Fatal("no progress reported")
+	}
+	for i = range reports {
+		if totals[i] != int64(len(data)) {
+			t.Errorf("report %d has total %d, want %d", i, totals[i], len(data))
+		}
+		if i > 0 && reports[i] < reports[i-1] {
+			t.Errorf("progress went back from %d to %d", reports[i-1], reports[i])
+		}
+	}
+	if reports[len(reports)-1] != int64(len(data)) {
+		t.Errorf("last report is %d, want %d", reports[len(reports)-1], len(data))
+	}
+	expectContents(t, filepath.Join(dir, "dst"), string(data))
+}
+
+func TestCopyWithProgressNoReportsAfterError(t *testing.T) {
+	var dir = testDir(t)
+	var src = filepath.Join(dir, "src")
+	var calls atomic.Int32
+	var returned int32
+	var err error
+
+	writeTestFile(t, src, "data")
+
+	// The destination cannot be opened for writing.
+	err = os.Mkdir(filepath.Join(dir, "dst"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = DefaultAdapter().CopyWithProgress(context.Background(), fileURL(src),
+		fileURL(filepath.Join(dir, "dst")), func(copied, total int64) {
+			calls.Add(1)
+		})
+	if err == nil {
+		t.Fatal("copying onto a directory succeeded")
+	}
+
+	returned = calls.Load()
+	time.Sleep(50 * time.Millisecond)
+	if calls.Load() != returned {
+		t.Errorf("progress reported after CopyWithProgress returned %v", err)
+	}
+}
+
+func TestCopyPreservesTimes(t *testing.T) {
+	var dir = testDir(t)
+	var src = filepath.Join(dir, "src")
+	var atime = time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	var mtime = time.Date(2002, 3, 4, 5, 6, 7, 0, time.UTC)
+	var fpath string
+	var err error
+
+	makeTestTree(t, src)
+	for _, fpath = range []string{
+		filepath.Join(src, "top.txt"),
+		filepath.Join(src, "a", "b"),
+	} {
+		err = os.Chtimes(fpath, atime, mtime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = DefaultAdapter().CopyWithOptions(context.Background(),
+		fileURL(filepath.Join(src, "top.txt")), fileURL(filepath.Join(dir, "copy.txt")),
+		&CopyOptions{PreserveTimes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTimes(t, filepath.Join(dir, "copy.txt"), atime, mtime)
+
+	// Reading the source for the copy may have updated its access time.
+	err = os.Chtimes(filepath.Join(src, "top.txt"), atime, mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = DefaultAdapter().CopyTree(context.Background(), fileURL(src),
+		fileURL(filepath.Join(dir, "tree")), &CopyOptions{PreserveTimes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTimes(t, filepath.Join(dir, "tree", "top.txt"), atime, mtime)
+	expectTimes(t, filepath.Join(dir, "tree", "a", "b"), atime, mtime)
+}
+
+/*
+expectTimes checks the modification and, where it can be determined, the
+access time of the file fpath.
+*/
+func expectTimes(t *testing.T, fpath string, atime, mtime time.Time) {
+	var fi os.FileInfo
+	var got time.Time
+	var ok bool
+	var err error
+
+	t.Helper()
+
+	fi, err = os.Stat(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("%s has modification time %v, want %v", fpath, fi.ModTime(), mtime)
+	}
+
+	got, ok = accessTime(fi)
+	if ok && !got.Equal(atime) {
+		t.Errorf("%s has access time %v, want %v", fpath, got, atime)
+	}
+}
+
+func TestCopySparse(t *testing.T) {
+	var dir = testDir(t)
+	var src = filepath.Join(dir, "sparse")
+	var dst = filepath.Join(dir, "copy")
+	var size int64 = 16 * 1024 * 1024
+	var f *os.File
+	var fi os.FileInfo
+	var want, got []byte
+	var err error
+
+	f, err = os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt([]byte("head"), 0)
+	if err == nil {
+		_, err = f.WriteAt([]byte("middle"), size/2)
+	}
+	if err == nil {
+		err = f.Truncate(size)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err = os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocatedSize(fi) >= size {
+		t.Skip("the file system doesn't support sparse files")
+	}
+
+	err = DefaultAdapter().CopyWithOptions(context.Background(), fileURL(src), fileURL(dst),
+		&CopyOptions{Sparse: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err = os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != size {
+		t.Errorf("copy is %d bytes large, want %d", fi.Size(), size)
+	}
+	if allocatedSize(fi) >= size {
+		t.Errorf("copy takes up %d bytes on disk, it isn't sparse", allocatedSize(fi))
+	}
+
+	want, err = os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("the copy differs from the original")
+	}
+}
+
+func TestCopyN(t *testing.T) {
+	var dir = testDir(t)
+	var src = filepath.Join(dir, "src")
+	var data = string(bytes.Repeat([]byte("0123456789"), defaultChunkSize/5))
+	var n int64
+	var err error
+
+	writeTestFile(t, src, data)
+
+	for _, test := range []struct {
+		name   string
+		n      int64
+		copied int64
+		err    error
+	}{
+		{"exact", int64(len(data)), int64(len(data)), nil},
+		{"long", int64(len(data)) - 3, int64(len(data)) - 3, nil},
+		{"short", int64(len(data)) + 3, int64(len(data)), io.ErrUnexpectedEOF},
+		{"zero", 0, 0, nil},
+	} {
+		var dst = filepath.Join(dir, test.name)
+
+		n, err = DefaultAdapter().CopyN(context.Background(), fileURL(src), fileURL(dst), test.n)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: copying %d bytes returned %v, want %v", test.name, test.n, err, test.err)
+		}
+		if n != test.copied {
+			t.Errorf("%s: copied %d bytes, want %d", test.name, n, test.copied)
+		}
+		expectContents(t, dst, data[:test.copied])
+	}
+
+	// A negative count must not truncate the destination.
+	writeTestFile(t, filepath.Join(dir, "existing"), "keep")
+	n, err = DefaultAdapter().CopyN(context.Background(), fileURL(src),
+		fileURL(filepath.Join(dir, "existing")), -1)
+	if !errors.Is(err, os.ErrInvalid) || n != 0 {
+		t.Errorf("copying -1 bytes returned %d, %v, want 0, %v", n, err, os.ErrInvalid)
+	}
+	expectContents(t, filepath.Join(dir, "existing"), "keep")
+}
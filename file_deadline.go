@@ -0,0 +1,96 @@
+package file
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"os"
+	"time"
+)
+
+/*
+deadlineInPast is used to make pending I/O on pollable files fail right away.
+*/
+var deadlineInPast = time.Unix(1, 0)
+
+/*
+supportsDeadlines determines whether the file supports I/O deadlines. This is
+the case for pipes, FIFOs, sockets and the like, but not for regular files.
+*/
+func supportsDeadlines(file *os.File) bool {
+	return file.SetDeadline(time.Time{}) == nil
+}
+
+/*
+interruptOnDone moves the deadline set through setDeadline into the past as
+soon as ctx is done, interrupting pending I/O, unless stop is closed first.
+stopped is closed once it will no longer touch the deadline.
+*/
+func interruptOnDone(ctx context.Context, setDeadline func(time.Time) error,
+	stop, stopped chan struct{}) {
+	defer close(stopped)
+
+	select {
+	case <-ctx.Done():
+		setDeadline(deadlineInPast)
+	case <-stop:
+	}
+}
+
+/*
+withDeadline runs the I/O operation op on the file with the deadline set
+through setDeadline following opctx: the deadline of opctx is applied to the
+file, and cancelling opctx interrupts the operation. Unlike the goroutine
+based approach used for regular files, this leaves no operation running
+behind once it returns.
+*/
+func (f *ContextRespectingIoFile) withDeadline(opctx context.Context,
+	setDeadline func(time.Time) error, op func() (int, error)) (int, error) {
+	var stop = make(chan struct{})
+	var stopped = make(chan struct{})
+	var deadline time.Time
+	var n int
+	var err error
+
+	deadline, _ = opctx.Deadline()
+	err = setDeadline(deadline)
+	if err != nil {
+		return 0, err
+	}
+
+	go interruptOnDone(opctx, setDeadline, stop, stopped)
+	n, err = op()
+	close(stop)
+	<-stopped
+
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		if opctx.Err() != nil {
+			err = opctx.Err()
+		} else if !deadline.IsZero() && !time.Now().Before(deadline) {
+			// The file's deadline can pass before the context's timer
+			// has fired.
+			err = context.DeadlineExceeded
+		}
+	}
+	return n, err
+}
+
+/*
+readWithDeadline reads directly into p, relying on I/O deadlines rather than
+a separate goroutine to honor the context.
+*/
+func (f *ContextRespectingIoFile) readWithDeadline(opctx context.Context, p []byte) (int, error) {
+	return f.withDeadline(opctx, f.actualFile.SetReadDeadline, func() (int, error) {
+		return f.actualFile.Read(p)
+	})
+}
+
+/*
+writeWithDeadline writes b directly, relying on I/O deadlines rather than a
+separate goroutine to honor the context. On cancellation, the number of bytes
+which did get written is reported accurately.
+*/
+func (f *ContextRespectingIoFile) writeWithDeadline(opctx context.Context, b []byte) (int, error) {
+	return f.withDeadline(opctx, f.actualFile.SetWriteDeadline, func() (int, error) {
+		return f.actualFile.Write(b)
+	})
+}
@@ -0,0 +1,154 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+/*
+deadlinePipe creates a pipe whose ends are wrapped in context respecting
+files, skipping the test if the pipe doesn't support I/O deadlines.
+*/
+func deadlinePipe(t *testing.T) (*ContextRespectingIoFile, *ContextRespectingIoFile) {
+	var r, w *os.File
+	var err error
+
+	r, w, err = os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		r.Close()
+		w.Close()
+	})
+
+	if !supportsDeadlines(r) || !supportsDeadlines(w) {
+		t.Skip("pipes don't support I/O deadlines here")
+	}
+	return NewContextRespectingIoFile(r), NewContextRespectingIoFile(w)
+}
+
+func TestReadDeadlinePipe(t *testing.T) {
+	var r, w = deadlinePipe(t)
+	var ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	var buf = make([]byte, 10)
+	var n int
+	var err error
+
+	defer cancel()
+
+	n, err = r.Read(ctx, buf)
+	if n != 0 || err != context.DeadlineExceeded {
+		t.Errorf("read past the deadline returned %d, %v, want 0, %v",
+			n, err, context.DeadlineExceeded)
+	}
+	if !IsTimeout(err) {
+		t.Errorf("%v not recognized as a timeout", err)
+	}
+
+	// No read is left running in the background to swallow the data.
+	_, err = w.Write(context.Background(), []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = r.Read(context.Background(), buf)
+	if err != nil || string(buf[:n]) != "data" {
+		t.Errorf("read %q, %v after the deadline had passed, want %q", buf[:n], err, "data")
+	}
+}
+
+func TestWriteDeadlinePipe(t *testing.T) {
+	var r, w = deadlinePipe(t)
+	var data = bytes.Repeat([]byte("x"), 16*1024*1024)
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var received []byte
+	var n int
+	var err error
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Nobody reads from the pipe, so it fills up long before all data is
+	// written.
+	n, err = w.Write(ctx, data)
+	if err != context.DeadlineExceeded {
+		t.Errorf("write past the deadline returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n <= 0 || n >= len(data) {
+		t.Fatalf("write past the deadline wrote %d bytes", n)
+	}
+
+	// Exactly what was reported as written ended up in the pipe.
+	err = w.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err = io.ReadAll(ioReader{ctx: context.Background(), r: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != n {
+		t.Errorf("received %d bytes, but the write reported %d", len(received), n)
+	}
+}
+
+func TestCancellationKinds(t *testing.T) {
+	for _, test := range []struct {
+		err                error
+		timeout, cancelled bool
+	}{
+		{context.Canceled, false, true},
+		{context.DeadlineExceeded, true, false},
+		{os.ErrDeadlineExceeded, true, false},
+		{fmt.Errorf("read %s: %w", "file:///data", context.Canceled), false, true},
+		{&os.PathError{Op: "read", Path: "data", Err: os.ErrDeadlineExceeded}, true, false},
+		{io.EOF, false, false},
+		{nil, false, false},
+	} {
+		if IsTimeout(test.err) != test.timeout {
+			t.Errorf("IsTimeout(%v) = %v", test.err, !test.timeout)
+		}
+		if IsCanceled(test.err) != test.cancelled {
+			t.Errorf("IsCanceled(%v) = %v", test.err, !test.cancelled)
+		}
+	}
+}
+
+func TestWriteCancelledPipe(t *testing.T) {
+	var r, w = deadlinePipe(t)
+	var data = bytes.Repeat([]byte("x"), 16*1024*1024)
+	var ctx, cancel = context.WithCancel(context.Background())
+	var received []byte
+	var n int
+	var err error
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	n, err = w.Write(ctx, data)
+	if !IsCanceled(err) || IsTimeout(err) {
+		t.Errorf("cancelled write returned %v, want %v", err, context.Canceled)
+	}
+	if n <= 0 || n >= len(data) {
+		t.Fatalf("cancelled write reported %d bytes written", n)
+	}
+
+	err = w.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err = io.ReadAll(ioReader{ctx: context.Background(), r: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != n {
+		t.Errorf("received %d bytes, but the write reported %d", len(received), n)
+	}
+}
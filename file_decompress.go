@@ -0,0 +1,106 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"golang.org/x/net/context"
+	"io"
+	"net/url"
+	"os"
+)
+
+/*
+Magic numbers at the start of files compressed in the formats recognized by
+OpenReaderAutoDecompress.
+*/
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+/*
+decompressingReader decompresses the contents of a file using a decompressor
+other than gzip. If the decompressor holds resources of its own, it is closed
+along with the file.
+*/
+type decompressingReader struct {
+	file filesystem.ReadCloser
+	cr   *contextReader
+	r    io.Reader
+}
+
+func (d *decompressingReader) Read(ctx context.Context, p []byte) (int, error) {
+	d.cr.ctx = ctx
+	return d.r.Read(p)
+}
+
+func (d *decompressingReader) Close(ctx context.Context) error {
+	var closer io.Closer
+	var ok bool
+	var err error
+
+	closer, ok = d.r.(io.Closer)
+	if ok {
+		err = closer.Close()
+	}
+	return combineErrors(err, d.file.Close(ctx))
+}
+
+/*
+OpenReaderAutoDecompress works like OpenReader, but looks at the first bytes
+of the file to determine whether it is compressed and, if so, decompresses
+the data read from it. gzip and bzip2 are recognized and decompressed, as is
+zstd when building with the zstd tag (see zstdSupported). Files in any other
+format, including ones too short to tell, are read as they are.
+*/
+func (file *FileAdapter) OpenReaderAutoDecompress(
+	ctx context.Context, fileurl *url.URL) (filesystem.ReadCloser, error) {
+	var f *ContextRespectingIoFile
+	var cr *contextReader
+	var header []byte
+	var err error
+
+	f, err = file.openForReading(ctx, fileurl, os.Open)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err = f.Peek(ctx, len(zstdMagic))
+	if err != nil && err != io.EOF {
+		f.Close(ctx)
+		return nil, urlError("read", fileurl, err)
+	}
+
+	cr = &contextReader{ctx: ctx, r: f}
+
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		var gz *gzip.Reader
+
+		gz, err = gzip.NewReader(cr)
+		if err != nil {
+			f.Close(ctx)
+			return nil, urlError("read", fileurl, err)
+		}
+		return &GzipReader{file: f, cr: cr, gz: gz}, nil
+	case bytes.HasPrefix(header, bzip2Magic) && len(header) > len(bzip2Magic) &&
+		header[len(bzip2Magic)] >= '1' && header[len(bzip2Magic)] <= '9':
+		// The magic is followed by the block size, a digit.
+		return &decompressingReader{file: f, cr: cr, r: bzip2.NewReader(cr)}, nil
+	case zstdSupported && bytes.HasPrefix(header, zstdMagic):
+		var zr io.ReadCloser
+
+		zr, err = newZstdReader(cr)
+		if err != nil {
+			f.Close(ctx)
+			return nil, urlError("read", fileurl, err)
+		}
+		return &decompressingReader{file: f, cr: cr, r: zr}, nil
+	default:
+		return f, nil
+	}
+}
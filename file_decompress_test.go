@@ -0,0 +1,116 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"bytes"
+	"compress/gzip"
+	"golang.org/x/net/context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const compressedText = "hello, compressed world\n"
+
+/*
+bzip2Text and zstdText are compressedText as compressed by the bzip2 and zstd
+command line tools, since the standard library can't compress either format.
+*/
+var (
+	bzip2Text = []byte{
+		0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x8b, 0x72,
+		0x22, 0x3f, 0x00, 0x00, 0x05, 0x51, 0x80, 0x00, 0x10, 0x40, 0x04, 0x0e,
+		0x46, 0xd8, 0x80, 0x20, 0x00, 0x22, 0x9a, 0x3d, 0x27, 0xa9, 0xe9, 0x3d,
+		0x21, 0x00, 0x00, 0x06, 0xe1, 0x0e, 0x96, 0x9a, 0x81, 0x26, 0x2a, 0xdd,
+		0xcd, 0x1f, 0x7c, 0x5d, 0xc9, 0x14, 0xe1, 0x42, 0x42, 0x2d, 0xc8, 0x88,
+		0xfc,
+	}
+	zstdText = []byte{
+		0x28, 0xb5, 0x2f, 0xfd, 0x24, 0x18, 0xc1, 0x00, 0x00, 0x68, 0x65, 0x6c,
+		0x6c, 0x6f, 0x2c, 0x20, 0x63, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73,
+		0x65, 0x64, 0x20, 0x77, 0x6f, 0x72, 0x6c, 0x64, 0x0a, 0xd7, 0x17, 0x87,
+		0x7b,
+	}
+)
+
+func gzipText(t *testing.T) []byte {
+	var buf bytes.Buffer
+	var gz = gzip.NewWriter(&buf)
+	var err error
+
+	_, err = gz.Write([]byte(compressedText))
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+/*
+zstdExpected is what reading zstdText through OpenReaderAutoDecompress yields:
+the decompressed text when building with the zstd tag, the data as it is
+otherwise.
+*/
+func zstdExpected() string {
+	if zstdSupported {
+		return compressedText
+	}
+	return string(zstdText)
+}
+
+func TestOpenReaderAutoDecompress(t *testing.T) {
+	var dir = testDir(t)
+	var png = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	var tests = []struct {
+		name     string
+		contents []byte
+		want     string
+	}{
+		{"gzip", gzipText(t), compressedText},
+		{"bzip2", bzip2Text, compressedText},
+		{"zstd", zstdText, zstdExpected()},
+		{"plain", []byte(compressedText), compressedText},
+		// Valid files in formats which aren't compressed are left alone.
+		{"png", png, string(png)},
+		{"bzip2 magic without block size", []byte("BZhx"), "BZhx"},
+		{"shorter than any magic", []byte("B"), "B"},
+		{"empty", nil, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var fpath = filepath.Join(dir, test.name)
+			var r filesystem.ReadCloser
+			var err error
+
+			err = os.WriteFile(fpath, test.contents, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err = DefaultAdapter().OpenReaderAutoDecompress(context.Background(), fileURL(fpath))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readAndClose(t, r); got != test.want {
+				t.Errorf("read %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOpenReaderAutoDecompressCorrupt(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "corrupt.gz")
+	var err error
+
+	// A gzip header with an unknown compression method.
+	writeTestFile(t, fpath, "\x1f\x8b\x07\x00")
+
+	_, err = DefaultAdapter().OpenReaderAutoDecompress(context.Background(), fileURL(fpath))
+	if err == nil {
+		t.Error("opening a corrupt gzip file succeeded")
+	}
+}
@@ -0,0 +1,94 @@
+package file
+
+import (
+	"golang.org/x/net/context"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+)
+
+func asyncDigest(ctx context.Context, fpath string, h hash.Hash, rch chan []byte,
+	errch chan error) {
+	var f *os.File
+	var fi os.FileInfo
+	var buf = newChunkBuffer()
+	var err error
+
+	f, err = os.Open(fpath)
+	if err != nil {
+		errch <- err
+		return
+	}
+	defer f.Close()
+
+	fi, err = f.Stat()
+	if err != nil {
+		errch <- err
+		return
+	}
+	if fi.IsDir() {
+		errch <- &os.PathError{Op: "read", Path: fpath, Err: ErrIsDirectory}
+		return
+	}
+
+	for {
+		var n int
+
+		// Stop hashing if nobody is waiting for the result anymore.
+		if ctx.Err() != nil {
+			errch <- ctx.Err()
+			return
+		}
+
+		n, err = readRetrying(f, buf)
+		if n > 0 {
+			h.Write(buf[:n])
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			errch <- err
+			return
+		}
+	}
+
+	rch <- h.Sum(nil)
+}
+
+/*
+Digest computes the digest of the contents of the file pointed to using h,
+which should be freshly created or reset, and returns h.Sum(nil). The file is
+read in chunks in a subthread which checks the context in between, so the
+amount of data hashed is not limited by memory. If the context is cancelled,
+the subthread may still write one more chunk to h, so h should not be used
+anymore after an error.
+*/
+func (file *FileAdapter) Digest(ctx context.Context, fileurl *url.URL, h hash.Hash) (
+	[]byte, error) {
+	var rch = make(chan []byte, 1)
+	var errch = make(chan error, 1)
+	var cancel context.CancelFunc
+	var sum []byte
+	var fpath string
+	var err error
+
+	fpath, err = file.localPath(fileurl)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel = file.operationContext(ctx)
+	defer cancel()
+
+	go asyncDigest(ctx, fpath, h, rch, errch)
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case err = <-errch:
+		return nil, urlError("read", fileurl, err)
+	case sum = <-rch:
+		return sum, nil
+	}
+}
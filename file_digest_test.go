@@ -0,0 +1,82 @@
+package file
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"golang.org/x/net/context"
+	"hash"
+	"path/filepath"
+	"testing"
+)
+
+func TestDigest(t *testing.T) {
+	var dir = testDir(t)
+	var large = bytes.Repeat([]byte("0123456789abcdef"), 3*defaultChunkSize/16+5)
+	var want [sha256.Size]byte
+	var sum []byte
+	var err error
+
+	writeTestFile(t, filepath.Join(dir, "abc"), "abc")
+	sum, err = DefaultAdapter().Digest(context.Background(), fileURL(filepath.Join(dir, "abc")),
+		sha256.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(sum) != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("digest of %q is %x", "abc", sum)
+	}
+
+	// Spread over several chunks, with a partial one at the end.
+	writeTestFile(t, filepath.Join(dir, "large"), string(large))
+	want = sha256.Sum256(large)
+	sum, err = DefaultAdapter().Digest(context.Background(), fileURL(filepath.Join(dir, "large")),
+		sha256.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sum, want[:]) {
+		t.Errorf("digest of a large file is %x, want %x", sum, want)
+	}
+
+	_, err = DefaultAdapter().Digest(context.Background(), fileURL(dir), sha256.New())
+	if !errors.Is(err, ErrIsDirectory) {
+		t.Errorf("digest of a directory returned %v, want %v", err, ErrIsDirectory)
+	}
+}
+
+/*
+cancellingHash cancels a context as soon as the first data is written to it.
+*/
+type cancellingHash struct {
+	hash.Hash
+	cancel context.CancelFunc
+}
+
+func (h cancellingHash) Write(p []byte) (int, error) {
+	h.cancel()
+	return h.Hash.Write(p)
+}
+
+func TestDigestCancelled(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var ctx, cancel = context.WithCancel(context.Background())
+	var sum []byte
+	var err error
+
+	writeTestFile(t, fpath, string(bytes.Repeat([]byte("x"), 4*defaultChunkSize)))
+
+	sum, err = DefaultAdapter().Digest(ctx, fileURL(fpath), cancellingHash{sha256.New(), cancel})
+	if err != context.Canceled {
+		t.Errorf("digest cancelled after the first chunk returned %v, want %v", err, context.Canceled)
+	}
+	if sum != nil {
+		t.Errorf("digest cancelled after the first chunk returned %x", sum)
+	}
+
+	_, err = DefaultAdapter().Digest(ctx, fileURL(fpath), sha256.New())
+	if err != context.Canceled {
+		t.Errorf("digest with a cancelled context returned %v, want %v", err, context.Canceled)
+	}
+}
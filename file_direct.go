@@ -0,0 +1,246 @@
+package file
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"unsafe"
+)
+
+/*
+DirectIOAlignment is the alignment required for the lengths and offsets of
+reads and writes on files opened with OpenDirect. It is the logical block
+size of practically all current storage devices and a multiple of that of
+older ones.
+*/
+const DirectIOAlignment = 4096
+
+/*
+ErrMisaligned is returned for reads and writes on a DirectFile whose length
+or offset is not a multiple of DirectIOAlignment.
+*/
+var ErrMisaligned = errors.New("length or offset not aligned for direct I/O")
+
+/*
+AlignedBuffer allocates a buffer of size bytes which starts at an address
+which is a multiple of DirectIOAlignment, as required for direct I/O. The
+buffer cannot be grown with append without losing its alignment.
+*/
+func AlignedBuffer(size int) []byte {
+	var buf []byte
+	var skew int
+
+	if size <= 0 {
+		return []byte{}
+	}
+
+	buf = make([]byte, size+DirectIOAlignment)
+	skew = int(uintptr(unsafe.Pointer(&buf[0])) & (DirectIOAlignment - 1))
+	if skew != 0 {
+		skew = DirectIOAlignment - skew
+	}
+	return buf[skew : skew+size : skew+size]
+}
+
+/*
+DirectFile is a file opened with OpenDirect, whose reads and writes bypass
+the page cache of the operating system. All reads and writes are positional
+and must be aligned to DirectIOAlignment.
+
+Data is passed between the caller's buffers and the file through aligned
+buffers owned by the subthread performing the operation, so the caller's
+buffers needn't be aligned themselves and can be reused as soon as a call
+returns, even if it returned because the context was cancelled.
+*/
+type DirectFile struct {
+	file *ContextRespectingIoFile
+}
+
+/*
+checkAligned returns ErrMisaligned unless both length and off are multiples
+of DirectIOAlignment.
+*/
+func (d *DirectFile) checkAligned(op string, length int, off int64) error {
+	if length%DirectIOAlignment != 0 || off%DirectIOAlignment != 0 {
+		return &os.PathError{Op: op, Path: d.file.actualFile.Name(), Err: ErrMisaligned}
+	}
+	return nil
+}
+
+func (d *DirectFile) asyncReadAt(length int, off int64, rchan chan *asyncReadResult) {
+	var result = new(asyncReadResult)
+
+	result.Data = AlignedBuffer(length)
+	result.Length, result.Error = d.file.actualFile.ReadAt(result.Data, off)
+	rchan <- result
+}
+
+/*
+ReadAt() reads len(p) bytes starting at the offset off, like os.File.ReadAt,
+with support for cancelling the operation or providing deadlines for it.
+Both len(p) and off must be multiples of DirectIOAlignment. If the file ends
+within the range, the data up to its end is returned along with io.EOF.
+*/
+func (d *DirectFile) ReadAt(ctx context.Context, p []byte, off int64) (int, error) {
+	var rchan = make(chan *asyncReadResult, 1)
+	var result *asyncReadResult
+	var opctx context.Context
+	var cancel context.CancelFunc
+	var err error
+
+	err = d.checkAligned("read", len(p), off)
+	if err != nil {
+		return 0, err
+	}
+
+	err = d.file.checkUsable()
+	if err != nil {
+		return 0, err
+	}
+
+	opctx, cancel = d.file.operationContext(ctx)
+	defer cancel()
+
+	go d.asyncReadAt(len(p), off, rchan)
+
+	select {
+	case <-opctx.Done():
+		return 0, d.file.operationAborted(ctx, opctx)
+	case result = <-rchan:
+		copy(p, result.Data[:result.Length])
+		d.file.bytesRead.Add(int64(result.Length))
+		return result.Length, result.Error
+	}
+}
+
+/*
+WriteAt() writes b to the file starting at the offset off, like
+os.File.WriteAt, with support for cancelling the operation or providing
+deadlines for it. Both len(b) and off must be multiples of
+DirectIOAlignment, so files whose length isn't have to be truncated to
+their final length with Truncate after writing.
+*/
+func (d *DirectFile) WriteAt(ctx context.Context, b []byte, off int64) (int, error) {
+	var lench = make(chan int, 1)
+	var errch = make(chan error, 1)
+	var nb []byte
+	var opctx context.Context
+	var cancel context.CancelFunc
+	var err error
+	var length int
+
+	err = d.checkAligned("write", len(b), off)
+	if err != nil {
+		return 0, err
+	}
+
+	err = d.file.checkUsable()
+	if err != nil {
+		return 0, err
+	}
+
+	opctx, cancel = d.file.operationContext(ctx)
+	defer cancel()
+
+	nb = AlignedBuffer(len(b))
+	copy(nb, b)
+
+	go d.file.asyncWriteAt(nb, off, lench, errch)
+
+	select {
+	case <-opctx.Done():
+		return 0, d.file.operationAborted(ctx, opctx)
+	case err = <-errch:
+		length = <-lench
+		d.file.bytesWritten.Add(int64(length))
+		return length, err
+	}
+}
+
+func (d *DirectFile) asyncTruncate(size int64, errch chan error) {
+	errch <- d.file.actualFile.Truncate(size)
+}
+
+/*
+Truncate() changes the size of the file, e.g. to cut off the padding after
+the last aligned write, with support for cancelling the operation or
+providing deadlines for it.
+*/
+func (d *DirectFile) Truncate(ctx context.Context, size int64) error {
+	var errch = make(chan error, 1)
+	var opctx context.Context
+	var cancel context.CancelFunc
+	var err error
+
+	err = d.file.checkUsable()
+	if err != nil {
+		return err
+	}
+
+	opctx, cancel = d.file.operationContext(ctx)
+	defer cancel()
+
+	go d.asyncTruncate(size, errch)
+
+	select {
+	case <-opctx.Done():
+		return d.file.operationAborted(ctx, opctx)
+	case err = <-errch:
+		return err
+	}
+}
+
+/*
+Sync() commits the file to stable storage. Direct I/O bypasses the page
+cache, but not necessarily the cache of the storage device or any metadata
+updates, so this is still required for durability.
+*/
+func (d *DirectFile) Sync(ctx context.Context) error {
+	return d.file.Sync(ctx)
+}
+
+/*
+Close() closes the file.
+*/
+func (d *DirectFile) Close(ctx context.Context) error {
+	return d.file.Close(ctx)
+}
+
+/*
+OpenDirect opens the file pointed to for direct I/O, bypassing the page cache
+of the operating system, with flag as for os.OpenFile (O_RDONLY, O_WRONLY or
+O_RDWR, optionally combined with O_CREATE, O_EXCL and O_TRUNC). O_APPEND is
+not supported since all I/O is positional. If O_CREATE is given, the parent
+directories are created as needed.
+
+On platforms without O_DIRECT, an error wrapping errors.ErrUnsupported is
+returned. Some file systems, e.g. tmpfs, refuse to open files for direct I/O
+even where the platform supports it, in which case the error of the operating
+system is returned.
+*/
+func (file *FileAdapter) OpenDirect(ctx context.Context, fileurl *url.URL, flag int) (
+	*DirectFile, error) {
+	var f *ContextRespectingIoFile
+	var dflag int
+	var err error
+
+	if flag&os.O_APPEND != 0 {
+		return nil, urlError("open", fileurl, os.ErrInvalid)
+	}
+
+	dflag, err = directOpenFlag()
+	if err != nil {
+		return nil, urlError("open", fileurl, err)
+	}
+
+	f, err = file.openForWritingIn(ctx, fileurl, flag|dflag, flag&os.O_CREATE != 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reopened files would be appending, which positional writes don't do.
+	f.reopen = nil
+
+	return &DirectFile{file: f}, nil
+}
@@ -0,0 +1,14 @@
+//go:build linux || freebsd || netbsd || dragonfly
+
+package file
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+/*
+directOpenFlag returns the flag to pass to open for direct I/O.
+*/
+func directOpenFlag() (int, error) {
+	return unix.O_DIRECT, nil
+}
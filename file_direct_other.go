@@ -0,0 +1,15 @@
+//go:build !(linux || freebsd || netbsd || dragonfly)
+
+package file
+
+import (
+	"errors"
+)
+
+/*
+directOpenFlag reports that direct I/O is not supported, since there is no
+O_DIRECT on this platform.
+*/
+func directOpenFlag() (int, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,87 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"golang.org/x/net/context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+)
+
+func TestAlignedBuffer(t *testing.T) {
+	for _, size := range []int{1, 512, DirectIOAlignment, 3*DirectIOAlignment + 1} {
+		var buf = AlignedBuffer(size)
+
+		if len(buf) != size || cap(buf) != size {
+			t.Errorf("buffer for %d bytes has length %d and capacity %d", size, len(buf), cap(buf))
+		}
+		if uintptr(unsafe.Pointer(&buf[0]))%DirectIOAlignment != 0 {
+			t.Errorf("buffer for %d bytes starts at %p, which isn't aligned", size, &buf[0])
+		}
+	}
+	if len(AlignedBuffer(0)) != 0 {
+		t.Error("empty buffer has contents")
+	}
+}
+
+func TestOpenDirect(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "direct")
+	var data = bytes.Repeat([]byte("0123456789abcdef"), 2*DirectIOAlignment/16)
+	var buf = make([]byte, 2*DirectIOAlignment)
+	var d *DirectFile
+	var n int
+	var err error
+
+	d, err = DefaultAdapter().OpenDirect(context.Background(), fileURL(fpath),
+		os.O_RDWR|os.O_CREATE)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		// tmpfs and some other file systems don't do direct I/O.
+		t.Skipf("cannot open %s for direct I/O: %v", fpath, err)
+	}
+	defer d.Close(context.Background())
+
+	// The caller's buffers needn't be aligned, only lengths and offsets.
+	n, err = d.WriteAt(context.Background(), data, 0)
+	if err != nil || n != len(data) {
+		t.Fatalf("wrote %d bytes, %v, want %d", n, err, len(data))
+	}
+	n, err = d.ReadAt(context.Background(), buf[:DirectIOAlignment], DirectIOAlignment)
+	if err != nil || !bytes.Equal(buf[:n], data[DirectIOAlignment:]) {
+		t.Errorf("read %d bytes, %v, want the second block", n, err)
+	}
+
+	_, err = d.ReadAt(context.Background(), buf[:100], 0)
+	if !errors.Is(err, ErrMisaligned) {
+		t.Errorf("reading a partial block returned %v, want %v", err, ErrMisaligned)
+	}
+	_, err = d.WriteAt(context.Background(), data[:DirectIOAlignment], 100)
+	if !errors.Is(err, ErrMisaligned) {
+		t.Errorf("writing at a misaligned offset returned %v, want %v", err, ErrMisaligned)
+	}
+
+	// Files of any length can be read up to their end.
+	err = d.Truncate(context.Background(), 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = d.ReadAt(context.Background(), buf, 0)
+	if err != io.EOF || n != 5000 || !bytes.Equal(buf[:n], data[:5000]) {
+		t.Errorf("read %d bytes, %v from a truncated file, want 5000 and io.EOF", n, err)
+	}
+}
+
+func TestOpenDirectAppend(t *testing.T) {
+	var err error
+
+	_, err = DefaultAdapter().OpenDirect(context.Background(),
+		fileURL(filepath.Join(testDir(t), "direct")), os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+	if !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("opening for appending returned %v, want %v", err, os.ErrInvalid)
+	}
+}
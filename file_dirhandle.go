@@ -0,0 +1,184 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"strings"
+)
+
+/*
+DirHandle is an open directory relative to which files can be opened by name,
+like the *at family of system calls does. Since the directory itself stays
+open, the path leading to it is not looked up again for every file, so
+renaming or replacing any of its components, e.g. with a symbolic link, does
+not affect which files are opened through the handle. Opening files relative
+to directory handles is only supported on POSIX systems.
+*/
+type DirHandle struct {
+	adapter *FileAdapter
+	dir     *os.File
+}
+
+/*
+checkEntryName makes sure that name refers to an entry directly inside of a
+directory, rather than to the directory itself, its parent or something
+further down.
+*/
+func checkEntryName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, '/') ||
+		strings.ContainsRune(name, os.PathSeparator) {
+		return &os.PathError{Op: "openat", Path: name, Err: os.ErrInvalid}
+	}
+	return nil
+}
+
+func asyncOpenAt(dir *os.File, name string, flag int, rchan chan *os.File, errchan chan error) {
+	var f *os.File
+	var err error
+
+	f, err = openAt(dir, name, flag)
+	if err != nil {
+		errchan <- err
+	} else {
+		rchan <- f
+	}
+}
+
+/*
+openAt opens the entry name of the directory in a subthread and waits for the
+result as long as the context permits.
+*/
+func (d *DirHandle) openAt(ctx context.Context, name string, flag int) (*os.File, error) {
+	var rchan = make(chan *os.File, 1)
+	var errchan = make(chan error, 1)
+	var cancel context.CancelFunc
+	var f *os.File
+	var err error
+
+	err = checkEntryName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel = d.adapter.operationContext(ctx)
+	defer cancel()
+
+	go asyncOpenAt(d.dir, name, flag, rchan, errchan)
+
+	select {
+	case <-ctx.Done():
+		go discardOpenedDir(rchan, errchan)
+		return nil, ctx.Err()
+	case err = <-errchan:
+		return nil, err
+	case f = <-rchan:
+		return f, nil
+	}
+}
+
+/*
+OpenReader opens the entry name of the directory for reading. The name must
+not contain any path separators. Symbolic links are not followed; opening one
+fails, like for OpenReaderNoFollow, and so does opening a subdirectory, with
+an error wrapping ErrIsDirectory.
+*/
+func (d *DirHandle) OpenReader(ctx context.Context, name string) (filesystem.ReadCloser, error) {
+	var f *os.File
+	var fi os.FileInfo
+	var reader *ContextRespectingIoFile
+	var err error
+
+	f, err = d.openAt(ctx, name, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err = f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, &os.PathError{Op: "open", Path: f.Name(), Err: ErrIsDirectory}
+	}
+
+	reader = NewContextRespectingIoFile(f)
+	reader.timeout = d.adapter.OperationTimeout
+	return reader, nil
+}
+
+/*
+OpenDir opens the subdirectory name of the directory as another handle, so
+that a tree can be descended one component at a time. Symbolic links are not
+followed.
+*/
+func (d *DirHandle) OpenDir(ctx context.Context, name string) (*DirHandle, error) {
+	var f *os.File
+	var err error
+
+	f, err = d.openAt(ctx, name, os.O_RDONLY|openDirectoryFlag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DirHandle{adapter: d.adapter, dir: f}, nil
+}
+
+/*
+Close releases the directory handle. Files opened through it are not
+affected.
+*/
+func (d *DirHandle) Close(ctx context.Context) error {
+	var errch = make(chan error, 1)
+	var err error
+
+	go asyncCloseFile(d.dir, errch)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-errch:
+		return err
+	}
+}
+
+/*
+OpenDirHandle opens the directory pointed to as a DirHandle, relative to
+which files can then be opened without looking up the path to the directory
+again. If the URL points to something other than a directory, the error
+wraps ErrNotDirectory. On platforms other than POSIX systems, the handle can
+be opened, but opening files relative to it fails with an error wrapping
+errors.ErrUnsupported.
+*/
+func (file *FileAdapter) OpenDirHandle(ctx context.Context, dirurl *url.URL) (*DirHandle, error) {
+	var rchan = make(chan *os.File, 1)
+	var errchan = make(chan error, 1)
+	var cancel context.CancelFunc
+	var dir *os.File
+	var dirpath string
+	var err error
+
+	dirpath, err = file.localPath(dirurl)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel = file.operationContext(ctx)
+	defer cancel()
+
+	go asyncOpenDir(dirpath, rchan, errchan)
+
+	select {
+	case <-ctx.Done():
+		go discardOpenedDir(rchan, errchan)
+		return nil, ctx.Err()
+	case err = <-errchan:
+		return nil, urlError("open", dirurl, err)
+	case dir = <-rchan:
+		return &DirHandle{adapter: file, dir: dir}, nil
+	}
+}
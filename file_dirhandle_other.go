@@ -0,0 +1,21 @@
+//go:build !unix
+
+package file
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+/*
+openDirectoryFlag has no equivalent on this platform.
+*/
+const openDirectoryFlag = 0
+
+/*
+openAt is not supported on this platform.
+*/
+func openAt(dir *os.File, name string, flag int) (*os.File, error) {
+	return nil, &os.PathError{Op: "openat", Path: filepath.Join(dir.Name(), name), Err: errors.ErrUnsupported}
+}
@@ -0,0 +1,119 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirHandle(t *testing.T) {
+	var dir = testDir(t)
+	var d, sub *DirHandle
+	var r filesystem.ReadCloser
+	var err error
+
+	err = os.MkdirAll(filepath.Join(dir, "a", "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "a", "file"), "original")
+	writeTestFile(t, filepath.Join(dir, "a", "sub", "nested"), "nested")
+
+	d, err = DefaultAdapter().OpenDirHandle(context.Background(), fileURL(filepath.Join(dir, "a")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close(context.Background())
+
+	r, err = d.OpenReader(context.Background(), "file")
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAndClose(t, r); got != "original" {
+		t.Errorf("read %q, want %q", got, "original")
+	}
+
+	// Swapping out the directory doesn't affect the handle.
+	err = os.Rename(filepath.Join(dir, "a"), filepath.Join(dir, "moved"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(filepath.Join(dir, "a"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "a", "file"), "impostor")
+
+	r, err = d.OpenReader(context.Background(), "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAndClose(t, r); got != "original" {
+		t.Errorf("read %q after replacing the directory, want %q", got, "original")
+	}
+
+	sub, err = d.OpenDir(context.Background(), "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close(context.Background())
+	r, err = sub.OpenReader(context.Background(), "nested")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAndClose(t, r); got != "nested" {
+		t.Errorf("read %q from the subdirectory, want %q", got, "nested")
+	}
+
+	_, err = d.OpenReader(context.Background(), "sub")
+	if !errors.Is(err, ErrIsDirectory) {
+		t.Errorf("opening a subdirectory for reading returned %v, want %v", err, ErrIsDirectory)
+	}
+	_, err = d.OpenDir(context.Background(), "file")
+	if err == nil {
+		t.Error("opening a file as a directory succeeded")
+	}
+
+	for _, name := range []string{"", ".", "..", "sub/nested"} {
+		_, err = d.OpenReader(context.Background(), name)
+		if !errors.Is(err, os.ErrInvalid) {
+			t.Errorf("opening %q returned %v, want %v", name, err, os.ErrInvalid)
+		}
+	}
+}
+
+func TestDirHandleNoFollow(t *testing.T) {
+	var dir = testDir(t)
+	var d *DirHandle
+	var err error
+
+	writeTestFile(t, filepath.Join(dir, "target"), "target")
+	symlinkOrSkip(t, filepath.Join(dir, "target"), filepath.Join(dir, "link"))
+
+	d, err = DefaultAdapter().OpenDirHandle(context.Background(), fileURL(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close(context.Background())
+
+	_, err = d.OpenReader(context.Background(), "link")
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err == nil {
+		t.Error("opening a symbolic link through the handle succeeded")
+	}
+
+	_, err = DefaultAdapter().OpenDirHandle(context.Background(),
+		fileURL(filepath.Join(dir, "target")))
+	if !errors.Is(err, ErrNotDirectory) {
+		t.Errorf("opening a file as a directory handle returned %v, want %v", err, ErrNotDirectory)
+	}
+}
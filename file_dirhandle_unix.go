@@ -0,0 +1,48 @@
+//go:build unix
+
+package file
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+/*
+openDirectoryFlag makes openAt fail unless the entry is a directory.
+*/
+const openDirectoryFlag = unix.O_DIRECTORY
+
+/*
+openAt opens the entry name of the directory dir using openat(2), without
+following symbolic links.
+*/
+func openAt(dir *os.File, name string, flag int) (*os.File, error) {
+	var conn syscall.RawConn
+	var fd int
+	var oerr error
+	var err error
+
+	conn, err = dir.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.Control(func(dirfd uintptr) {
+		for {
+			fd, oerr = unix.Openat(int(dirfd), name, flag|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+			if oerr != unix.EINTR {
+				break
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if oerr != nil {
+		return nil, &os.PathError{Op: "openat", Path: filepath.Join(dir.Name(), name), Err: oerr}
+	}
+
+	return os.NewFile(uintptr(fd), filepath.Join(dir.Name(), name)), nil
+}
@@ -0,0 +1,162 @@
+package file
+
+import (
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+)
+
+/*
+DirIterator yields the names of the entries of a directory one at a time,
+without ever holding the entire listing in memory.
+*/
+type DirIterator interface {
+	// Next returns the name of the next entry, or io.EOF once all entries
+	// have been returned.
+	Next(ctx context.Context) (string, error)
+
+	// Close releases the directory handle.
+	Close(ctx context.Context) error
+}
+
+type asyncNamesResult struct {
+	Names []string
+	Error error
+}
+
+/*
+dirIterator implements DirIterator by reading directory entries in batches of
+listBatchSize. If a read is cancelled, it keeps running in the background and
+its result is picked up by the next call, so no entries are lost.
+*/
+type dirIterator struct {
+	dir     *os.File
+	batch   []string
+	pending chan *asyncNamesResult
+	err     error
+}
+
+func (d *dirIterator) asyncReadBatch(rchan chan *asyncNamesResult) {
+	var result = new(asyncNamesResult)
+
+	result.Names, result.Error = d.dir.Readdirnames(listBatchSize)
+	rchan <- result
+}
+
+/*
+Next() returns the next entry name, reading another batch from the directory
+if required.
+*/
+func (d *dirIterator) Next(ctx context.Context) (string, error) {
+	var result *asyncNamesResult
+	var name string
+
+	for len(d.batch) == 0 {
+		if d.err != nil {
+			return "", d.err
+		}
+
+		if d.pending == nil {
+			d.pending = make(chan *asyncNamesResult, 1)
+			go d.asyncReadBatch(d.pending)
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case result = <-d.pending:
+			d.pending = nil
+			d.batch = result.Names
+			d.err = result.Error
+		}
+	}
+
+	name = d.batch[0]
+	d.batch = d.batch[1:]
+	return name, nil
+}
+
+func (d *dirIterator) asyncClose(pending chan *asyncNamesResult, errch chan error) {
+	if pending != nil {
+		<-pending
+	}
+	errch <- d.dir.Close()
+}
+
+/*
+Close() closes the directory handle, once any read still running in the
+background has finished.
+*/
+func (d *dirIterator) Close(ctx context.Context) error {
+	var errch = make(chan error, 1)
+	var err error
+
+	go d.asyncClose(d.pending, errch)
+
+	d.pending = nil
+	d.batch = nil
+	d.err = os.ErrClosed
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-errch:
+		return err
+	}
+}
+
+func asyncOpenDir(dirpath string, rchan chan *os.File, errchan chan error) {
+	var dir *os.File
+	var err error
+
+	dir, err = openDir(dirpath)
+	if err != nil {
+		errchan <- err
+	} else {
+		rchan <- dir
+	}
+}
+
+/*
+discardOpenedDir is the equivalent of discardOpenedFile for directories.
+*/
+func discardOpenedDir(rchan chan *os.File, errchan chan error) {
+	var dir *os.File
+
+	select {
+	case <-errchan:
+	case dir = <-rchan:
+		dir.Close()
+	}
+}
+
+/*
+OpenDirIterator opens the directory pointed to and returns an iterator over
+the names of its entries, for directories too large to list at once with
+ListEntries. The iterator returns io.EOF after the last entry and must be
+closed to release the directory handle.
+*/
+func (file *FileAdapter) OpenDirIterator(ctx context.Context, dirurl *url.URL) (DirIterator, error) {
+	var rchan = make(chan *os.File, 1)
+	var errchan = make(chan error, 1)
+	var dir *os.File
+	var dirpath string
+	var err error
+
+	dirpath, err = file.localPath(dirurl)
+	if err != nil {
+		return nil, err
+	}
+
+	go asyncOpenDir(dirpath, rchan, errchan)
+
+	select {
+	case <-ctx.Done():
+		go discardOpenedDir(rchan, errchan)
+		return nil, ctx.Err()
+	case err = <-errchan:
+		return nil, urlError("open", dirurl, err)
+	case dir = <-rchan:
+		return &dirIterator{dir: dir}, nil
+	}
+}
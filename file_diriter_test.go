@@ -0,0 +1,79 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirIterator(t *testing.T) {
+	var dir = testDir(t)
+	var count = 2*listBatchSize + 7
+	var seen = make(map[string]bool)
+	var iter DirIterator
+	var ctx, cancel = context.WithCancel(context.Background())
+	var before, after int
+	var counted bool
+	var name string
+	var i int
+	var err error
+
+	for i = 0; i < count; i++ {
+		writeTestFile(t, filepath.Join(dir, fmt.Sprintf("file%04d", i)), "")
+	}
+	before, counted = openDescriptors()
+
+	iter, err = DefaultAdapter().OpenDirIterator(context.Background(), fileURL(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Giving up on a read doesn't lose the entries it returns.
+	cancel()
+	name, err = iter.Next(ctx)
+	if err == nil {
+		seen[name] = true
+	} else if err != context.Canceled {
+		t.Fatalf("cancelled Next returned %v, want %v", err, context.Canceled)
+	}
+
+	for {
+		name, err = iter.Next(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[name] {
+			t.Errorf("%s returned twice", name)
+		}
+		seen[name] = true
+	}
+	if len(seen) != count {
+		t.Errorf("iterated over %d entries, want %d", len(seen), count)
+	}
+
+	_, err = iter.Next(context.Background())
+	if err != io.EOF {
+		t.Errorf("Next after the end returned %v, want io.EOF", err)
+	}
+
+	err = iter.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = iter.Next(context.Background())
+	if !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Next after Close returned %v, want %v", err, os.ErrClosed)
+	}
+
+	after, _ = openDescriptors()
+	if counted && after != before {
+		t.Errorf("%d descriptors open after closing the iterator, %d before", after, before)
+	}
+}
@@ -0,0 +1,165 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"golang.org/x/net/context"
+	"gopkg.in/fsnotify.v1"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*
+DirectoryWatcher keeps track of entries appearing in and disappearing from a
+directory, without looking at the contents of any files.
+*/
+type DirectoryWatcher struct {
+	notify       func(name string, op string)
+	watcher      watchBackend
+	local        string
+	errch        chan error
+	done         chan struct{}
+	finished     chan struct{}
+	shutdownOnce sync.Once
+}
+
+/*
+directoryOps maps the fsnotify operations reported by directory watchers to
+the names they are reported under.
+*/
+var directoryOps = []struct {
+	op   fsnotify.Op
+	name string
+}{
+	{fsnotify.Create, "create"},
+	{fsnotify.Remove, "remove"},
+	{fsnotify.Rename, "rename"},
+}
+
+/*
+WatchDirectory watches the directory pointed to for entries being created,
+removed or renamed, and calls notify with the name of the entry inside the
+directory and one of "create", "remove" or "rename" for each. Renames are
+reported under the old name; the new name, if it is in the same directory,
+is reported as created. Changes to the contents of files are not reported,
+and no readers are opened. notify is called from a single goroutine, one
+event at a time, and must not cancel the watch itself since cancelling waits
+for it to return. The context only governs setting up the watch.
+*/
+func (file *FileAdapter) WatchDirectory(ctx context.Context, dirurl *url.URL,
+	notify func(name string, op string)) (filesystem.CancelWatchFunc, chan error, error) {
+	var w *DirectoryWatcher
+	var fi os.FileInfo
+	var local string
+	var err error
+
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+
+	local, err = file.localPath(dirurl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	local, err = resolveSymlinks(local)
+	if err != nil {
+		return nil, nil, urlError("watch", dirurl, err)
+	}
+
+	fi, err = os.Stat(local)
+	if err != nil {
+		return nil, nil, urlError("watch", dirurl, err)
+	}
+	if !fi.IsDir() {
+		return nil, nil, urlError("watch", dirurl, ErrNotDirectory)
+	}
+
+	w = &DirectoryWatcher{
+		notify:   notify,
+		local:    local,
+		errch:    make(chan error),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+
+	w.watcher, err = file.openWatchBackend()
+	if err != nil {
+		return nil, nil, urlError("watch", dirurl, err)
+	}
+
+	err = w.watcher.Add(local)
+	if err != nil {
+		w.watcher.Close()
+		return nil, nil, urlError("watch", dirurl, err)
+	}
+
+	go w.watchForChanges()
+
+	return w.Shutdown, w.errch, nil
+}
+
+/*
+watchForChanges is invoked asynchronously and passes the relevant events on
+to the callback. It is the only sender on the error channel, which is closed
+as soon as it returns.
+*/
+func (w *DirectoryWatcher) watchForChanges() {
+	defer close(w.finished)
+	defer close(w.errch)
+
+	for {
+		var event fsnotify.Event
+		var err error
+		var ok bool
+
+		select {
+		case <-w.done:
+			return
+		case err, ok = <-w.watcher.Errors():
+			if !ok {
+				return
+			}
+			select {
+			case w.errch <- err:
+			case <-w.done:
+			}
+		case event, ok = <-w.watcher.Events():
+			var i int
+
+			if !ok {
+				return
+			}
+
+			// Events about the directory itself have no name inside it.
+			if filepath.Clean(event.Name) == w.local {
+				continue
+			}
+
+			for i = range directoryOps {
+				if event.Op&directoryOps[i].op != 0 {
+					w.notify(filepath.Base(event.Name), directoryOps[i].name)
+				}
+			}
+		}
+	}
+}
+
+/*
+Shutdown stops watching the directory. Once it returns, no more events or
+errors will be reported and the error channel has been closed. Calling
+Shutdown more than once is harmless.
+*/
+func (w *DirectoryWatcher) Shutdown() error {
+	var err error
+
+	w.shutdownOnce.Do(func() {
+		close(w.done)
+		err = w.watcher.Close()
+		<-w.finished
+	})
+
+	return err
+}
@@ -0,0 +1,70 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"golang.org/x/net/context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchDirectory(t *testing.T) {
+	var dir = testDir(t)
+	var events = make(chan [2]string, 100)
+	var cancel filesystem.CancelWatchFunc
+	var errch chan error
+	var err error
+
+	cancel, errch, err = DefaultAdapter().WatchDirectory(context.Background(), fileURL(dir),
+		func(name, op string) {
+			events <- [2]string{name, op}
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expect = func(name, op string) {
+		t.Helper()
+
+		select {
+		case event := <-events:
+			if event[0] != name || event[1] != op {
+				t.Errorf("reported %s %s, want %s %s", event[1], event[0], op, name)
+			}
+		case err = <-errch:
+			t.Fatalf("watch failed with %v while waiting for %s %s", err, op, name)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s %s not reported", op, name)
+		}
+	}
+
+	// Writing to the file doesn't count as a change of the directory.
+	writeTestFile(t, filepath.Join(dir, "a"), "a")
+	expect("a", "create")
+	writeTestFile(t, filepath.Join(dir, "a"), "changed")
+
+	err = os.Rename(filepath.Join(dir, "a"), filepath.Join(dir, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("a", "rename")
+	expect("b", "create")
+
+	err = os.Remove(filepath.Join(dir, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("b", "remove")
+
+	err = cancel()
+	if err != nil {
+		t.Error(err)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("unexpected %s %s reported", event[1], event[0])
+	default:
+	}
+}
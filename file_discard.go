@@ -0,0 +1,39 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"golang.org/x/net/context"
+)
+
+/*
+discardWriteCloser accepts all writes and throws the data away, like
+/dev/null, without ever touching the disk or spawning goroutines.
+*/
+type discardWriteCloser struct{}
+
+/*
+Write() pretends to have written all of b, unless the context is already
+done.
+*/
+func (discardWriteCloser) Write(ctx context.Context, b []byte) (int, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	return len(b), nil
+}
+
+/*
+Close() does nothing.
+*/
+func (discardWriteCloser) Close(ctx context.Context) error {
+	return nil
+}
+
+/*
+NewDiscardWriteCloser returns a writer which discards all data written to it.
+This is useful as a destination for tests and benchmarks.
+*/
+func NewDiscardWriteCloser() filesystem.WriteCloser {
+	return discardWriteCloser{}
+}
@@ -0,0 +1,47 @@
+package file
+
+import (
+	"golang.org/x/net/context"
+	"testing"
+)
+
+func TestDiscardWriteCloser(t *testing.T) {
+	var w = NewDiscardWriteCloser()
+	var ctx, cancel = context.WithCancel(context.Background())
+	var data = make([]byte, 1000)
+	var allocs float64
+	var n int
+	var err error
+
+	allocs = testing.AllocsPerRun(100, func() {
+		n, err = w.Write(ctx, data)
+	})
+	if n != len(data) || err != nil {
+		t.Errorf("write returned %d, %v, want %d, nil", n, err, len(data))
+	}
+	if allocs != 0 {
+		t.Errorf("writes allocate %v times", allocs)
+	}
+
+	cancel()
+	_, err = w.Write(ctx, data)
+	if err != context.Canceled {
+		t.Errorf("write with a cancelled context returned %v, want %v", err, context.Canceled)
+	}
+	if err = w.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func BenchmarkDiscardWrite(b *testing.B) {
+	var w = NewDiscardWriteCloser()
+	var ctx = context.Background()
+	var data = make([]byte, 4096)
+	var i int
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i = 0; i < b.N; i++ {
+		w.Write(ctx, data)
+	}
+}
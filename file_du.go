@@ -0,0 +1,135 @@
+package file
+
+import (
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+/*
+apparentSize counts the size of regular files only.
+*/
+func apparentSize(fi os.FileInfo) int64 {
+	if fi.Mode().IsRegular() {
+		return fi.Size()
+	}
+	return 0
+}
+
+/*
+diskUsage sums up the sizes of fpath, described by fi, and everything below
+it as determined by size. Symbolic links are not followed. The context is
+checked between entries.
+*/
+func diskUsage(ctx context.Context, fpath string, fi os.FileInfo,
+	size func(os.FileInfo) int64) (int64, error) {
+	var total = size(fi)
+	var dir *os.File
+	var infos []os.FileInfo
+	var info os.FileInfo
+	var err error
+
+	if !fi.IsDir() {
+		return total, nil
+	}
+
+	dir, err = openDir(fpath)
+	if err != nil {
+		return 0, err
+	}
+	infos, err = readDirInfos(ctx, dir)
+	dir.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, info = range infos {
+		var sub int64
+
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+
+		sub, err = diskUsage(ctx, filepath.Join(fpath, info.Name()), info, size)
+		if err != nil {
+			return 0, err
+		}
+		total += sub
+	}
+
+	return total, nil
+}
+
+func asyncDiskUsage(ctx context.Context, fpath string, size func(os.FileInfo) int64,
+	rch chan int64, errch chan error) {
+	var fi os.FileInfo
+	var total int64
+	var err error
+
+	fi, err = os.Lstat(fpath)
+	if err != nil {
+		errch <- err
+		return
+	}
+
+	total, err = diskUsage(ctx, fpath, fi, size)
+	if err != nil {
+		errch <- err
+		return
+	}
+	rch <- total
+}
+
+/*
+diskUsageURL runs diskUsage on the path pointed to in a subthread.
+*/
+func (file *FileAdapter) diskUsageURL(ctx context.Context, rooturl *url.URL,
+	size func(os.FileInfo) int64) (int64, error) {
+	var rch = make(chan int64, 1)
+	var errch = make(chan error, 1)
+	var total int64
+	var fpath string
+	var err error
+
+	fpath, err = file.localPath(rooturl)
+	if err != nil {
+		return 0, err
+	}
+
+	go asyncDiskUsage(ctx, fpath, size, rch, errch)
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case err = <-errch:
+		return 0, urlError("walk", rooturl, err)
+	case total = <-rch:
+		return total, nil
+	}
+}
+
+/*
+DiskUsage determines the total size of all regular files in the tree rooted
+at the path pointed to, which may also be a single file. This is the
+apparent size, i.e. the number of bytes which can be read from the files;
+see DiskUsageAllocated for the space actually taken up on disk. Symbolic
+links are not followed, and files with several hard links in the tree are
+counted every time. The tree is walked in a subthread which checks the
+context between entries, so cancelling the context stops the walk shortly
+after.
+*/
+func (file *FileAdapter) DiskUsage(ctx context.Context, rooturl *url.URL) (int64, error) {
+	return file.diskUsageURL(ctx, rooturl, apparentSize)
+}
+
+/*
+DiskUsageAllocated works like DiskUsage, but determines the space allocated
+on disk for all entries in the tree, including directories and symbolic
+links, like du does. For sparse files this may be considerably less than
+their apparent size. On platforms which don't report allocated blocks, the
+apparent sizes of all entries are used instead.
+*/
+func (file *FileAdapter) DiskUsageAllocated(ctx context.Context, rooturl *url.URL) (int64, error) {
+	return file.diskUsageURL(ctx, rooturl, allocatedSize)
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package file
+
+import (
+	"os"
+)
+
+/*
+allocatedSize falls back to the apparent size, as allocated blocks are not
+reported on this platform.
+*/
+func allocatedSize(fi os.FileInfo) int64 {
+	return fi.Size()
+}
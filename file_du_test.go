@@ -0,0 +1,101 @@
+package file
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiskUsage(t *testing.T) {
+	var dir = testDir(t)
+	var root = filepath.Join(dir, "tree")
+	var total, allocated int64
+	var err error
+
+	// 22 bytes in files at several levels of nesting.
+	makeTestTree(t, root)
+	writeTestFile(t, filepath.Join(dir, "outside"), strings.Repeat("x", 1000))
+	if os.Symlink(filepath.Join(dir, "outside"), filepath.Join(root, "a", "link")) != nil {
+		t.Log("cannot create symbolic links, not checking they aren't followed")
+	}
+
+	total, err = DefaultAdapter().DiskUsage(context.Background(), fileURL(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 22 {
+		t.Errorf("tree takes up %d bytes, want 22", total)
+	}
+
+	total, err = DefaultAdapter().DiskUsage(context.Background(),
+		fileURL(filepath.Join(root, "a", "b", "c", "deepest.txt")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 7 {
+		t.Errorf("single file takes up %d bytes, want 7", total)
+	}
+
+	allocated, err = DefaultAdapter().DiskUsageAllocated(context.Background(), fileURL(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated <= 0 {
+		t.Errorf("tree has %d bytes allocated", allocated)
+	}
+}
+
+func TestDiskUsageSparse(t *testing.T) {
+	var dir = testDir(t)
+	var total, allocated int64
+	var f *os.File
+	var err error
+
+	f, err = os.Create(filepath.Join(dir, "sparse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Truncate(16 * 1024 * 1024)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total, err = DefaultAdapter().DiskUsage(context.Background(), fileURL(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 16*1024*1024 {
+		t.Errorf("apparent size is %d bytes, want %d", total, 16*1024*1024)
+	}
+
+	allocated, err = DefaultAdapter().DiskUsageAllocated(context.Background(), fileURL(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated == total {
+		t.Skip("the file system or platform doesn't report sparse allocations")
+	}
+	if allocated > total {
+		t.Errorf("%d bytes allocated for a hole of %d bytes", allocated, total)
+	}
+}
+
+func TestDiskUsageCancelled(t *testing.T) {
+	var dir = testDir(t)
+	var ctx, cancel = context.WithCancel(context.Background())
+	var err error
+
+	makeTestTree(t, dir)
+
+	cancel()
+	_, err = DefaultAdapter().DiskUsage(ctx, fileURL(dir))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("walking with a cancelled context returned %v, want %v", err, context.Canceled)
+	}
+}
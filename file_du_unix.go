@@ -0,0 +1,22 @@
+//go:build unix
+
+package file
+
+import (
+	"os"
+)
+
+/*
+allocatedSize determines the space allocated on disk for the entry described
+by fi, which POSIX reports in units of 512 bytes.
+*/
+func allocatedSize(fi os.FileInfo) int64 {
+	var st *RawStat
+	var ok bool
+
+	st, ok = rawStat(fi)
+	if !ok {
+		return fi.Size()
+	}
+	return int64(st.Blocks) * 512
+}
@@ -0,0 +1,110 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+func (f *ContextRespectingIoFile) asyncSync(errch chan error) {
+	errch <- f.actualFile.Sync()
+}
+
+/*
+Sync() commits the contents of the file to stable storage, like os.File.Sync,
+but with support for cancelling waiting for it to finish or providing
+deadlines for it.
+*/
+func (f *ContextRespectingIoFile) Sync(ctx context.Context) error {
+	var errch = make(chan error, 1)
+	var opctx context.Context
+	var cancel context.CancelFunc
+	var err error
+
+	err = f.checkUsable()
+	if err != nil {
+		return err
+	}
+
+	opctx, cancel = f.operationContext(ctx)
+	defer cancel()
+
+	go f.asyncSync(errch)
+
+	select {
+	case <-opctx.Done():
+		return f.operationAborted(ctx, opctx)
+	case err = <-errch:
+		return err
+	}
+}
+
+func asyncSyncDir(dir string, errch chan error) {
+	errch <- syncDir(dir)
+}
+
+/*
+DurableWriter is a writer which makes sure the data written to it has reached
+stable storage by the time Close returns successfully.
+*/
+type DurableWriter struct {
+	file *ContextRespectingIoFile
+	dir  string
+}
+
+/*
+Write() writes the data to the underlying file.
+*/
+func (w *DurableWriter) Write(ctx context.Context, p []byte) (int, error) {
+	return w.file.Write(ctx, p)
+}
+
+/*
+Close() syncs the file to stable storage, closes it and then syncs the
+directory containing it, so that the directory entry of a newly created file
+is durable as well. The file is closed even if syncing it fails; if both
+fail, both errors are reported, combined using errors.Join. The directory is
+only synced if the file could be synced and closed.
+*/
+func (w *DurableWriter) Close(ctx context.Context) error {
+	var errch = make(chan error, 1)
+	var err error
+
+	err = combineErrors(w.file.Sync(ctx), w.file.Close(ctx))
+	if err != nil {
+		return err
+	}
+
+	go asyncSyncDir(w.dir, errch)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-errch:
+		return err
+	}
+}
+
+/*
+OpenWriterDurable works like OpenWriter, but the resulting writer syncs the
+file and its parent directory when it is closed, so that everything written
+survives a crash once Close has returned without an error.
+*/
+func (file *FileAdapter) OpenWriterDurable(
+	ctx context.Context, fileurl *url.URL) (filesystem.WriteCloser, error) {
+	var f *ContextRespectingIoFile
+	var err error
+
+	f, err = file.openForWriting(ctx, fileurl, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DurableWriter{
+		file: f,
+		dir:  filepath.Dir(f.actualFile.Name()),
+	}, nil
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+
+package file
+
+import (
+	"os"
+)
+
+/*
+syncDir commits the directory entries of dir to stable storage.
+*/
+func syncDir(dir string) error {
+	var d *os.File
+	var err error
+	var cerr error
+
+	d, err = os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	err = d.Sync()
+	cerr = d.Close()
+	if err == nil {
+		err = cerr
+	}
+	return err
+}
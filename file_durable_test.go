@@ -0,0 +1,65 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestOpenWriterDurable(t *testing.T) {
+	var dir = testDir(t)
+	var fpath = filepath.Join(dir, "sub", "data")
+	var w filesystem.WriteCloser
+	var err error
+
+	w, err = DefaultAdapter().OpenWriterDurable(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(context.Background(), []byte("durable"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectContents(t, fpath, "durable")
+}
+
+func TestOpenWriterDurableSyncsDirectory(t *testing.T) {
+	var dir = testDir(t)
+	var w filesystem.WriteCloser
+	var err error
+
+	if runtime.GOOS == "windows" {
+		t.Skip("directories aren't synced on Windows")
+	}
+
+	w, err = DefaultAdapter().OpenWriterDurable(context.Background(),
+		fileURL(filepath.Join(dir, "sub", "data")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(context.Background(), []byte("durable"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The open file doesn't mind its directory moving, but syncing the
+	// directory it was created in fails once that is gone.
+	err = os.Rename(filepath.Join(dir, "sub"), filepath.Join(dir, "moved"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close(context.Background())
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("closing after the directory moved returned %v, want %v", err, os.ErrNotExist)
+	}
+	expectContents(t, filepath.Join(dir, "moved", "data"), "durable")
+}
@@ -0,0 +1,8 @@
+package file
+
+/*
+syncDir does nothing on Windows, where directory handles cannot be flushed.
+*/
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,112 @@
+package file
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"io"
+	"syscall"
+	"time"
+)
+
+/*
+Bounds of the backoff between attempts when an operation on a non-blocking
+file which the runtime cannot poll reports that it would block.
+*/
+const (
+	minWouldBlockBackoff = time.Millisecond
+	maxWouldBlockBackoff = 100 * time.Millisecond
+)
+
+/*
+isInterrupted determines whether err indicates that a system call was
+interrupted by a signal before it could do anything, so that it should just
+be retried. The Go runtime normally retries these itself, but not every
+configuration guarantees it.
+*/
+func isInterrupted(err error) bool {
+	return errors.Is(err, syscall.EINTR)
+}
+
+/*
+readRetrying reads from r into p like r.Read, but retries reads which were
+interrupted by a signal rather than reporting EINTR to the caller.
+*/
+func readRetrying(r io.Reader, p []byte) (int, error) {
+	for {
+		var n int
+		var err error
+
+		n, err = r.Read(p)
+		if isInterrupted(err) {
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+/*
+isWouldBlock determines whether err indicates that an operation on a file in
+non-blocking mode could not proceed right away. For files the runtime can
+poll, it waits for them to become ready by itself, but for others, like some
+devices, EAGAIN is passed through.
+*/
+func isWouldBlock(err error) bool {
+	return errors.Is(err, syscall.EAGAIN)
+}
+
+/*
+wouldBlockBackoff waits before retrying an operation which would have blocked,
+for longer the more often it happened in a row, as given by attempt. It
+returns false without waiting any further once done is closed.
+*/
+func wouldBlockBackoff(attempt int, done <-chan struct{}) bool {
+	var delay = minWouldBlockBackoff
+	var timer *time.Timer
+
+	for ; attempt > 0 && delay < maxWouldBlockBackoff; attempt-- {
+		delay *= 2
+	}
+	if delay > maxWouldBlockBackoff {
+		delay = maxWouldBlockBackoff
+	}
+
+	timer = time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+/*
+readWaiting works like readRetrying, but if r is a non-blocking file which
+has no data yet, it keeps trying with a backoff until data is available or
+done is closed, in which case the error is context.Canceled.
+*/
+func readWaiting(r io.Reader, p []byte, done <-chan struct{}) (int, error) {
+	var attempt int
+
+	for {
+		var n int
+		var err error
+
+		n, err = readRetrying(r, p)
+		if n > 0 || !isWouldBlock(err) {
+			if isWouldBlock(err) {
+				err = nil
+			}
+			return n, err
+		}
+
+		if !wouldBlockBackoff(attempt, done) {
+			return 0, context.Canceled
+		}
+		attempt++
+	}
+}
@@ -0,0 +1,74 @@
+package file
+
+import (
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+/*
+failingReader reads from r, but first fails with each of errs in turn, after
+returning the corresponding number of bytes from partial.
+*/
+type failingReader struct {
+	r       io.Reader
+	errs    []error
+	partial []int
+	calls   int
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	var n int
+	var err error
+
+	f.calls++
+	if len(f.errs) == 0 {
+		return f.r.Read(p)
+	}
+
+	if len(f.partial) > 0 {
+		n, _ = f.r.Read(p[:f.partial[0]])
+		f.partial = f.partial[1:]
+	}
+	err, f.errs = f.errs[0], f.errs[1:]
+	return n, err
+}
+
+func TestReadRetryingInterrupted(t *testing.T) {
+	var interrupted = &os.PathError{Op: "read", Path: "data", Err: syscall.EINTR}
+	var r = &failingReader{
+		r:    strings.NewReader("data"),
+		errs: []error{interrupted, interrupted},
+	}
+	var buf = make([]byte, 10)
+	var n int
+	var err error
+
+	n, err = readRetrying(r, buf)
+	if err != nil || string(buf[:n]) != "data" {
+		t.Errorf("read %q, %v, want %q", buf[:n], err, "data")
+	}
+	if r.calls != 3 {
+		t.Errorf("read %d times, want 3", r.calls)
+	}
+
+	// Data read before the interruption is returned right away.
+	r = &failingReader{
+		r:       strings.NewReader("data"),
+		errs:    []error{interrupted},
+		partial: []int{2},
+	}
+	n, err = readRetrying(r, buf)
+	if err != nil || string(buf[:n]) != "da" {
+		t.Errorf("read %q, %v, want %q", buf[:n], err, "da")
+	}
+
+	// Other errors are passed through.
+	r = &failingReader{r: strings.NewReader("data"), errs: []error{io.ErrUnexpectedEOF}}
+	_, err = readRetrying(r, buf)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("read returned %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package file
+
+import (
+	"os"
+)
+
+/*
+openNonBlocking opens the file at fpath for reading. There are no FIFOs
+which could block opening them on this platform.
+*/
+func openNonBlocking(fpath string) (*os.File, error) {
+	return os.Open(fpath)
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package file
+
+import (
+	"os"
+	"syscall"
+)
+
+/*
+openNonBlocking opens the file at fpath for reading with O_NONBLOCK, so that
+opening a FIFO doesn't wait for a writer.
+*/
+func openNonBlocking(fpath string) (*os.File, error) {
+	return os.OpenFile(fpath, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+}
@@ -3,340 +3,2040 @@ package file
 import (
 	"github.com/childoftheuniverse/filesystem"
 
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"golang.org/x/net/context"
 	"io"
 	"net/url"
 	"os"
-	"path"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 var globalFileAdapter *FileAdapter
 
+/*
+ErrIsDirectory is returned by operations which require a regular file when
+they are pointed at a directory.
+*/
+var ErrIsDirectory = errors.New("is a directory")
+
+/*
+ErrNotDirectory is returned by operations which require a directory when they
+are pointed at something else, e.g. a regular file.
+*/
+var ErrNotDirectory = errors.New("not a directory")
+
+/*
+ErrNoParentDirectory is returned by OpenWriterNoMkdir when the directory the
+file would be created in doesn't exist.
+*/
+var ErrNoParentDirectory = errors.New("parent directory does not exist")
+
+/*
+IsCancelled determines whether err indicates that an operation was aborted
+because its context was cancelled or its deadline expired, as opposed to e.g.
+the end of a file having been reached.
+*/
+func IsCancelled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+/*
+IsTimeout determines whether err indicates that an operation was aborted
+because a deadline expired, be it the deadline of the context, the adapter's
+OperationTimeout or an I/O deadline of the file.
+*/
+func IsTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+/*
+IsCanceled determines whether err indicates that an operation was aborted
+because its context was cancelled explicitly, rather than timing out.
+*/
+func IsCanceled(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
+
+/*
+urlError adds the operation and the URL it was performed on to err, so that
+errors are meaningful on their own, e.g. in logs, even if the local path
+named by an underlying os.PathError differs from the URL because of a
+BaseDir. The original error is wrapped, so errors.Is and errors.As still
+work; checks like os.IsNotExist, which don't unwrap errors, don't. Errors
+from the context are returned as they are.
+*/
+func urlError(op string, u *url.URL, err error) error {
+	if err == nil || IsCancelled(err) {
+		return err
+	}
+	return fmt.Errorf("%s %s: %w", op, u.Redacted(), err)
+}
+
+/*
+combineErrors reports all non-nil errors from a series of cleanup steps. A
+single error is returned as is, so that callers comparing it directly still
+work; several are combined using errors.Join, with the first one first.
+*/
+func combineErrors(errs ...error) error {
+	var failed []error
+	var err error
+
+	for _, err = range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		return failed[0]
+	default:
+		return errors.Join(failed...)
+	}
+}
+
 /*
 Since local files do not need any configuration to set up, this adapter is
 registered as soon as its relevant code is linked in.
 */
 func init() {
-	globalFileAdapter = &FileAdapter{}
-	filesystem.AddImplementation("file", globalFileAdapter)
+	Register("file", &FileAdapter{})
+}
+
+/*
+Register makes the specified adapter available to the filesystem API under
+the given scheme. This can be used to register additional, differently
+configured adapters (e.g. rooted ones) under schemes of their own, or to
+replace the default adapter registered under "file". The adapter registered
+under "file" is also the one used by NewFileWatcher.
+
+Since the default adapter is registered from this package's init function,
+replacing it only works from code which runs after that, i.e. from main or
+from init functions of packages importing this one.
+*/
+func Register(scheme string, adapter *FileAdapter) {
+	if scheme == "file" {
+		globalFileAdapter = adapter
+	}
+	filesystem.AddImplementation(scheme, adapter)
+}
+
+/*
+DefaultAdapter returns the adapter currently registered under the "file"
+scheme.
+*/
+func DefaultAdapter() *FileAdapter {
+	return globalFileAdapter
 }
 
 /*
 File system adapter for local files. See
 http://github.com/childoftheuniverse/filesystem/ for details of the API and
 how to use it.
+
+Errors from the methods implementing the filesystem API and their direct
+variants name the operation and the URL it was performed on, followed by the
+underlying error, which can still be examined using errors.Is and errors.As.
+Errors from the context are returned unwrapped.
 */
 type FileAdapter struct {
+	// BaseDir, if set, turns the adapter into a lightweight sandbox: all
+	// URL paths are interpreted relative to this directory, and paths which
+	// would lead outside of it (e.g. through "..") are rejected. Symbolic
+	// links inside the base directory are still followed, even if they point
+	// elsewhere.
+	BaseDir string
+
+	// OperationTimeout, if positive, limits the time a single operation may
+	// take on top of whatever limits the caller's context imposes. It
+	// applies to opening files as well as to every individual operation on
+	// the files opened through the adapter, so that e.g. a read from a
+	// stalled network mount fails even if the caller's context is long
+	// lived.
+	OperationTimeout time.Duration
+
+	// FileMode holds the permission bits for files created through the
+	// adapter. If zero, files are created with mode 0644. Like for
+	// os.OpenFile, the process umask is applied to them, so with the
+	// common umask 022, a FileMode of 0666 results in 0644.
+	FileMode os.FileMode
+
+	// ExactFileMode makes newly created files get exactly the permission
+	// bits from FileMode, regardless of the umask, by changing the mode
+	// explicitly after creating them. Existing files are left alone.
+	ExactFileMode bool
+
+	// newWatchBackend, if set, creates the backends of the watchers set up
+	// through the adapter instead of actual fsnotify watchers, so that
+	// tests can feed them synthetic events.
+	newWatchBackend func() (watchBackend, error)
+}
+
+/*
+fileMode returns the permission bits new files should be created with.
+*/
+func (file *FileAdapter) fileMode() os.FileMode {
+	if file.FileMode == 0 {
+		return 0644
+	}
+	return file.FileMode.Perm()
+}
+
+/*
+NewRootedFileAdapter creates a file adapter which interprets all paths
+relative to the directory base. See FileAdapter.BaseDir for details.
+*/
+func NewRootedFileAdapter(base string) *FileAdapter {
+	return &FileAdapter{BaseDir: base}
+}
+
+/*
+ContextRespectingIoFile represents a regular file object from the OS, but with
+implementations of respecting deadlines and cancellations from contexts.
+*/
+type ContextRespectingIoFile struct {
+	actualFile *os.File
+	pollable   bool
+	timeout    time.Duration
+	unusable   atomic.Bool
+
+	// reopen opens the file again the way it was opened originally, for
+	// Reopen. It is nil for files not opened by a FileAdapter.
+	reopen func() (*os.File, error)
+
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+}
+
+/*
+ErrFileUnusable is returned by all operations on a file after an operation on
+it has exceeded the per-operation timeout. Since the operation might still be
+stuck in the operating system, the state of the file is unknown and it should
+be closed.
+*/
+var ErrFileUnusable = errors.New("file unusable after operation timeout")
+
+/*
+ErrNegativeOffset is returned by Seek and Skip when the resulting offset would
+be before the start of the file.
+*/
+var ErrNegativeOffset = errors.New("negative file offset")
+
+/*
+SetOperationTimeout sets a limit for the time each individual operation on
+the file may take, on top of whatever limits the caller's context imposes.
+Once an operation exceeds this limit, the file is marked unusable and all
+further operations except Close fail with ErrFileUnusable. A timeout of zero
+disables the limit.
+*/
+func (f *ContextRespectingIoFile) SetOperationTimeout(timeout time.Duration) {
+	f.timeout = timeout
+}
+
+/*
+BytesRead returns the total number of bytes read from the file so far,
+including positional reads.
+*/
+func (f *ContextRespectingIoFile) BytesRead() int64 {
+	return f.bytesRead.Load()
+}
+
+/*
+BytesWritten returns the total number of bytes written to the file so far,
+including positional writes.
+*/
+func (f *ContextRespectingIoFile) BytesWritten() int64 {
+	return f.bytesWritten.Load()
+}
+
+/*
+operationContext derives the context for a single operation on the file from
+the caller's context, applying the per-operation timeout if one is set. The
+cancel function must always be called.
+*/
+func (f *ContextRespectingIoFile) operationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if f.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, f.timeout)
+}
+
+/*
+operationAborted determines the error to return for an operation which was
+aborted because opctx, derived from the caller's ctx, was done. If only the
+per-operation timeout fired, the file is marked unusable.
+*/
+func (f *ContextRespectingIoFile) operationAborted(ctx, opctx context.Context) error {
+	if ctx.Err() == nil {
+		f.unusable.Store(true)
+	}
+	return opctx.Err()
+}
+
+/*
+checkUsable returns ErrFileUnusable if a previous operation timed out.
+*/
+func (f *ContextRespectingIoFile) checkUsable() error {
+	if f.unusable.Load() {
+		return ErrFileUnusable
+	}
+	return nil
+}
+
+/*
+asyncReadResult holds the outcome of a read running in a subthread. The data
+is read into a pooled buffer, which the receiver returns to the pool once it
+has copied the data out. Results which nobody receives (because the read was
+cancelled) are left to the garbage collector, so a buffer can never be reused
+while a read might still be writing into it.
+*/
+type asyncReadResult struct {
+	Data   []byte
+	Length int
+	Error  error
+	buffer *[]byte
+}
+
+/*
+release returns the buffer of the result to the pool.
+*/
+func (r *asyncReadResult) release() {
+	putReadBuffer(r.buffer)
+	r.Data = nil
+}
+
+/*
+maxReadChunk is the maximum number of bytes read by a subthread at once, so
+that the buffer it reads into can be pooled and huge reads don't require
+allocating an equally huge buffer.
+*/
+const maxReadChunk = 1 << maxPooledBufferShift
+
+func (f *ContextRespectingIoFile) asyncRead(length int, done <-chan struct{},
+	rchan chan *asyncReadResult) {
+	var result = new(asyncReadResult)
+
+	result.buffer = getReadBuffer(length)
+	result.Data = (*result.buffer)[:length]
+	result.Length, result.Error = readWaiting(f.actualFile, result.Data, done)
+	rchan <- result
+}
+
+/*
+maxWriteChunk is the maximum number of bytes handed to the operating system
+in one go by Write, so that cancellation is noticed between chunks and the
+number of bytes written so far is known.
+*/
+const maxWriteChunk = 1 << 20
+
+/*
+asyncWrite writes all of b, retrying after short writes until either all data
+has been written, an error occurs or done is closed because the caller gave
+up. A write which makes no progress at all is reported as io.ErrShortWrite.
+Non-blocking files which cannot take any data right now are retried with a
+backoff.
+*/
+func (f *ContextRespectingIoFile) asyncWrite(b []byte, done <-chan struct{}, written *atomic.Int64,
+	errch chan error) {
+	var length int
+	var attempt int
+	var err error
+
+	for length < len(b) {
+		var chunk = b[length:]
+		var n int
+
+		if len(chunk) > maxWriteChunk {
+			chunk = chunk[:maxWriteChunk]
+		}
+
+		n, err = f.actualFile.Write(chunk)
+		length += n
+		written.Add(int64(n))
+		if isInterrupted(err) {
+			// Carry on with whatever wasn't written.
+			err = nil
+			continue
+		}
+		if isWouldBlock(err) {
+			err = nil
+			if n > 0 {
+				attempt = 0
+				continue
+			}
+			if !wouldBlockBackoff(attempt, done) {
+				errch <- context.Canceled
+				return
+			}
+			attempt++
+			continue
+		}
+		if err != nil {
+			break
+		}
+		if n == 0 {
+			err = io.ErrShortWrite
+			break
+		}
+
+		select {
+		case <-done:
+			errch <- context.Canceled
+			return
+		default:
+		}
+	}
+
+	errch <- err
+}
+
+func (f *ContextRespectingIoFile) asyncReadAt(length int, off int64, rchan chan *asyncReadResult) {
+	var result = new(asyncReadResult)
+
+	result.buffer = getReadBuffer(length)
+	result.Data = (*result.buffer)[:length]
+	result.Length, result.Error = f.actualFile.ReadAt(result.Data, off)
+	rchan <- result
+}
+
+func (f *ContextRespectingIoFile) asyncWriteAt(b []byte, off int64, lench chan int, errch chan error) {
+	var length int
+	var err error
+
+	length, err = f.actualFile.WriteAt(b, off)
+	lench <- length
+	errch <- err
+}
+
+func (f *ContextRespectingIoFile) asyncSeek(offset int64, whence int, rchan chan int64, errch chan error) {
+	var pos int64
+	var err error
+
+	pos, err = f.actualFile.Seek(offset, whence)
+	if err != nil {
+		errch <- err
+	} else {
+		rchan <- pos
+	}
+}
+
+func (f *ContextRespectingIoFile) asyncStat(rchan chan os.FileInfo, errch chan error) {
+	var fi os.FileInfo
+	var err error
+
+	fi, err = f.actualFile.Stat()
+	if err != nil {
+		errch <- err
+	} else {
+		rchan <- fi
+	}
+}
+
+func (f *ContextRespectingIoFile) asyncClose(errch chan error) {
+	errch <- f.actualFile.Close()
+}
+
+/*
+Read() provides regular read semantics, but with support for cancelling
+reads or providing deadlines for them. The end of the file is reported as
+io.EOF, just like for io.Reader. A read which was aborted because the context
+was done returns the context's error instead, which must not be mistaken for
+the end of the file; use IsCancelled to tell these cases apart. Reads into an
+empty buffer return immediately. At most 1MiB is read at once from regular
+files, so reads into larger buffers may return less data even if the file is
+large enough.
+*/
+func (f *ContextRespectingIoFile) Read(ctx context.Context, p []byte) (l int, err error) {
+	var result *asyncReadResult
+	var rchan = make(chan *asyncReadResult, 1)
+	var opctx context.Context
+	var cancel context.CancelFunc
+
+	err = f.checkUsable()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	opctx, cancel = f.operationContext(ctx)
+	defer cancel()
+
+	if f.pollable {
+		l, err = f.readWithDeadline(opctx, p)
+		f.bytesRead.Add(int64(l))
+		return l, err
+	}
+
+	if len(p) > maxReadChunk {
+		p = p[:maxReadChunk]
+	}
+
+	go f.asyncRead(len(p), opctx.Done(), rchan)
+
+	select {
+	case <-opctx.Done():
+		return 0, f.operationAborted(ctx, opctx)
+	case result = <-rchan:
+		copy(p, result.Data[:result.Length])
+		result.release()
+		f.bytesRead.Add(int64(result.Length))
+		return result.Length, result.Error
+	}
+}
+
+/*
+Write() provides regular write semantics, but with support for cancelling
+writes or providing deadlines for them. Short writes are retried, so unless an
+error is returned, all of b has been written. If the write is aborted, the
+number of bytes the operating system had accepted by then is returned along
+with the error; a chunk which was still being written at that point may end
+up in the file without being counted.
+*/
+func (f *ContextRespectingIoFile) Write(ctx context.Context, b []byte) (int, error) {
+	return f.write(ctx, b, false)
+}
+
+/*
+WriteString() works like Write, but writes the contents of s. Since the
+conversion of s already yields a private copy of the data, it is not copied
+again for the write.
+*/
+func (f *ContextRespectingIoFile) WriteString(ctx context.Context, s string) (int, error) {
+	return f.write(ctx, []byte(s), true)
+}
+
+/*
+write implements Write. Since a write which is aborted may still be running
+in the background, b is copied before handing it to the subthread, unless
+owned indicates that nobody else can modify it anyway.
+*/
+func (f *ContextRespectingIoFile) write(ctx context.Context, b []byte, owned bool) (int, error) {
+	var written = new(atomic.Int64)
+	var errch = make(chan error, 1)
+	var nb []byte
+	var opctx context.Context
+	var cancel context.CancelFunc
+	var err error
+	var length int
+
+	err = f.checkUsable()
+	if err != nil {
+		return 0, err
+	}
+
+	opctx, cancel = f.operationContext(ctx)
+	defer cancel()
+
+	if f.pollable {
+		length, err = f.writeWithDeadline(opctx, b)
+		f.bytesWritten.Add(int64(length))
+		return length, err
+	}
+
+	nb = b
+	if !owned {
+		nb = make([]byte, len(b))
+		copy(nb, b)
+	}
+
+	go f.asyncWrite(nb, opctx.Done(), written, errch)
+
+	select {
+	case <-opctx.Done():
+		// The write may have completed just now.
+		select {
+		case err = <-errch:
+			if err == nil {
+				f.bytesWritten.Add(written.Load())
+				return len(b), nil
+			}
+		default:
+		}
+
+		length = int(written.Load())
+		f.bytesWritten.Add(int64(length))
+		return length, f.operationAborted(ctx, opctx)
+	case err = <-errch:
+		length = int(written.Load())
+		f.bytesWritten.Add(int64(length))
+		return length, err
+	}
+}
+
+/*
+ReadAt() reads len(p) bytes starting at the offset off in the file, with
+support for cancelling reads or providing deadlines for them. It does not use
+or modify the current offset of the file, so it may be called concurrently
+with other reads and writes. Large reads are split into chunks of up to 1MiB,
+to each of which the per-operation timeout applies separately.
+*/
+func (f *ContextRespectingIoFile) ReadAt(ctx context.Context, p []byte, off int64) (int, error) {
+	var total int
+
+	for total < len(p) {
+		var chunk = p[total:]
+		var n int
+		var err error
+
+		if len(chunk) > maxReadChunk {
+			chunk = chunk[:maxReadChunk]
+		}
+
+		n, err = f.readAtChunk(ctx, chunk, off+int64(total))
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+/*
+readAtChunk reads len(p) bytes at the offset off in a subthread.
+*/
+func (f *ContextRespectingIoFile) readAtChunk(ctx context.Context, p []byte, off int64) (int, error) {
+	var result *asyncReadResult
+	var rchan = make(chan *asyncReadResult, 1)
+	var opctx context.Context
+	var cancel context.CancelFunc
+	var err error
+
+	err = f.checkUsable()
+	if err != nil {
+		return 0, err
+	}
+
+	opctx, cancel = f.operationContext(ctx)
+	defer cancel()
+
+	go f.asyncReadAt(len(p), off, rchan)
+
+	select {
+	case <-opctx.Done():
+		return 0, f.operationAborted(ctx, opctx)
+	case result = <-rchan:
+		copy(p, result.Data[:result.Length])
+		result.release()
+		f.bytesRead.Add(int64(result.Length))
+		return result.Length, result.Error
+	}
+}
+
+/*
+WriteAt() writes b to the file starting at the offset off, with support for
+cancelling writes or providing deadlines for them. It does not use or modify
+the current offset of the file, so it may be called concurrently with other
+reads and writes. It fails for files opened for appending.
+*/
+func (f *ContextRespectingIoFile) WriteAt(ctx context.Context, b []byte, off int64) (int, error) {
+	var lench = make(chan int, 1)
+	var errch = make(chan error, 1)
+	var nb []byte
+	var opctx context.Context
+	var cancel context.CancelFunc
+	var err error
+	var length int
+
+	err = f.checkUsable()
+	if err != nil {
+		return 0, err
+	}
+
+	opctx, cancel = f.operationContext(ctx)
+	defer cancel()
+
+	nb = make([]byte, len(b))
+	copy(nb, b)
+
+	go f.asyncWriteAt(nb, off, lench, errch)
+
+	select {
+	case <-opctx.Done():
+		return 0, f.operationAborted(ctx, opctx)
+	case err = <-errch:
+		length = <-lench
+		f.bytesWritten.Add(int64(length))
+		return length, err
+	}
+}
+
+/*
+seek changes the offset of the file in a subthread, with support for
+cancelling the operation or providing deadlines for it. Invalid values of
+whence and seeks to negative offsets are reported as errors wrapping
+os.ErrInvalid and ErrNegativeOffset, respectively.
+*/
+func (f *ContextRespectingIoFile) seek(ctx context.Context, offset int64, whence int) (int64, error) {
+	var rchan = make(chan int64, 1)
+	var errch = make(chan error, 1)
+	var opctx context.Context
+	var cancel context.CancelFunc
+	var pos int64
+	var err error
+
+	err = f.checkUsable()
+	if err != nil {
+		return 0, err
+	}
+
+	// Seeking is usually too quick to lose the race against a context which
+	// is already done, but the offset must not move if the caller gave up.
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	switch whence {
+	case io.SeekStart:
+		if offset < 0 {
+			return 0, &os.PathError{Op: "seek", Path: f.actualFile.Name(), Err: ErrNegativeOffset}
+		}
+	case io.SeekCurrent, io.SeekEnd:
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.actualFile.Name(), Err: os.ErrInvalid}
+	}
+
+	opctx, cancel = f.operationContext(ctx)
+	defer cancel()
+
+	go f.asyncSeek(offset, whence, rchan, errch)
+
+	select {
+	case <-opctx.Done():
+		return 0, f.operationAborted(ctx, opctx)
+	case err = <-errch:
+		// With a valid whence, this is what the operating system reports
+		// if the offset would be negative.
+		if errors.Is(err, syscall.EINVAL) {
+			err = &os.PathError{Op: "seek", Path: f.actualFile.Name(), Err: ErrNegativeOffset}
+		}
+		return 0, err
+	case pos = <-rchan:
+		return pos, nil
+	}
+}
+
+/*
+stat determines the file information of the open file in a subthread, with
+support for cancelling the operation or providing deadlines for it.
+*/
+func (f *ContextRespectingIoFile) stat(ctx context.Context) (os.FileInfo, error) {
+	var rchan = make(chan os.FileInfo, 1)
+	var errch = make(chan error, 1)
+	var opctx context.Context
+	var cancel context.CancelFunc
+	var fi os.FileInfo
+	var err error
+
+	err = f.checkUsable()
+	if err != nil {
+		return nil, err
+	}
+
+	opctx, cancel = f.operationContext(ctx)
+	defer cancel()
+
+	go f.asyncStat(rchan, errch)
+
+	select {
+	case <-opctx.Done():
+		return nil, f.operationAborted(ctx, opctx)
+	case err = <-errch:
+		return nil, err
+	case fi = <-rchan:
+		return fi, nil
+	}
+}
+
+/*
+SizeFromFd() determines the current size of the open file in a subthread.
+Since the file descriptor itself is examined rather than the path it was
+opened from, the result refers to this file even if it has been renamed or
+replaced in the meantime.
+*/
+func (f *ContextRespectingIoFile) SizeFromFd(ctx context.Context) (int64, error) {
+	var fi os.FileInfo
+	var err error
+
+	fi, err = f.stat(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+/*
+Tell() determines the current offset inside the file and returns it, with
+support for cancelling the operation or providing deadlines for it.
+*/
+func (f *ContextRespectingIoFile) Tell(ctx context.Context) (int64, error) {
+	return f.seek(ctx, 0, io.SeekCurrent)
+}
+
+/*
+Seek() sets the current position in the file to offset, interpreted according
+to whence like for io.Seeker, with support for cancelling the operation or
+providing deadlines for it. The new offset is returned. Seeking to a negative
+offset fails with an error wrapping ErrNegativeOffset. Seeking past the end of
+the file is allowed; reads from there report io.EOF, and writing there
+extends the file, leaving a hole which reads back as zeroes and usually
+doesn't take up disk space.
+*/
+func (f *ContextRespectingIoFile) Seek(
+	ctx context.Context, offset int64, whence int) (int64, error) {
+	return f.seek(ctx, offset, whence)
+}
+
+/*
+Skip() skips forward by the specified number of bytes without actually reading
+the data, with support for cancelling the operation or providing deadlines for
+it.
+*/
+func (f *ContextRespectingIoFile) Skip(ctx context.Context, n int64) error {
+	var err error
+	_, err = f.seek(ctx, n, io.SeekCurrent)
+	return err
+}
+
+/*
+Peek() returns the next n bytes of the file without consuming them, so that
+the next Read returns them again, e.g. for detecting the type of a file from
+its first bytes. The data is read from the current offset using ReadAt, so
+this only works for files which support seeking. If the file ends before n
+bytes, the remaining bytes are returned along with io.EOF. Like other
+positional reads, peeking counts towards BytesRead. Peeking at zero bytes
+returns an empty slice without touching the file; negative counts are
+rejected with an error wrapping os.ErrInvalid.
+*/
+func (f *ContextRespectingIoFile) Peek(ctx context.Context, n int) ([]byte, error) {
+	var p []byte
+	var pos int64
+	var length int
+	var err error
+
+	if n < 0 {
+		return nil, &os.PathError{Op: "peek", Path: f.actualFile.Name(), Err: os.ErrInvalid}
+	} else if n == 0 {
+		return []byte{}, nil
+	}
+
+	pos, err = f.Tell(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p = make([]byte, n)
+	length, err = f.ReadAt(ctx, p, pos)
+	if err == io.EOF && length == n {
+		err = nil
+	}
+	return p[:length], err
+}
+
+/*
+Close() provides regular close semantics, but with support for cancelling
+waiting for closes to finish (which may be important due to caches) or
+providing deadlines for them.
+*/
+func (f *ContextRespectingIoFile) Close(ctx context.Context) error {
+	var errch = make(chan error, 1)
+	var opctx context.Context
+	var cancel context.CancelFunc
+	var err error
+
+	opctx, cancel = f.operationContext(ctx)
+	defer cancel()
+
+	go f.asyncClose(errch)
+
+	select {
+	case <-opctx.Done():
+		return f.operationAborted(ctx, opctx)
+	case err = <-errch:
+		return err
+	}
+}
+
+/*
+NewContextRespectingIoFile wraps a regular os.File so we get a context
+respecting API on it. If the file supports I/O deadlines (pipes, FIFOs,
+sockets etc.), reads and writes use them to honor the context instead of
+leaving the operation running in a separate goroutine.
+*/
+func NewContextRespectingIoFile(actualFile *os.File) *ContextRespectingIoFile {
+	return &ContextRespectingIoFile{
+		actualFile: actualFile,
+		pollable:   supportsDeadlines(actualFile),
+	}
+}
+
+func asyncOpenRead(path string, open func(string) (*os.File, error),
+	rchan chan *ContextRespectingIoFile, errchan chan error) {
+	var file *os.File
+	var fi os.FileInfo
+	var err error
+
+	file, err = open(path)
+	if err != nil {
+		errchan <- err
+		return
+	}
+
+	// Opening a directory for reading succeeds, but reading from it
+	// doesn't, so reject it right away.
+	fi, err = file.Stat()
+	if err != nil {
+		file.Close()
+		errchan <- err
+		return
+	}
+	if fi.IsDir() {
+		file.Close()
+		errchan <- &os.PathError{Op: "open", Path: path, Err: ErrIsDirectory}
+		return
+	}
+
+	rchan <- NewContextRespectingIoFile(file)
+}
+
+/*
+makeDirs creates the directory dir along with any missing parents, like
+os.MkdirAll with mode 0755. If this fails because one of the components of
+the path exists but isn't a directory, the error names that component and
+wraps ErrNotDirectory, rather than being whatever the operating system
+reports for the first directory which couldn't be created.
+*/
+func makeDirs(dir string) error {
+	var ancestor string
+	var err error
+
+	err = os.MkdirAll(dir, 0755)
+	if err == nil {
+		return nil
+	}
+
+	for ancestor = filepath.Clean(dir); ; ancestor = filepath.Dir(ancestor) {
+		var fi os.FileInfo
+		var serr error
+
+		fi, serr = os.Stat(ancestor)
+		if serr == nil {
+			if !fi.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: ancestor, Err: ErrNotDirectory}
+			}
+			break
+		}
+		if filepath.Dir(ancestor) == ancestor {
+			break
+		}
+	}
+
+	return err
+}
+
+/*
+openFile works like os.OpenFile, but if exact is set and the file is newly
+created, its mode is set to perm explicitly afterwards so that the umask
+doesn't apply.
+*/
+func openFile(fpath string, flag int, perm os.FileMode, exact bool) (*os.File, error) {
+	var file *os.File
+	var created bool
+	var err error
+
+	if exact && flag&os.O_CREATE != 0 {
+		_, err = os.Lstat(fpath)
+		created = os.IsNotExist(err)
+	}
+
+	file, err = os.OpenFile(fpath, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	if created {
+		err = file.Chmod(perm)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
+
+func asyncOpenWrite(fpath string, flag int, perm os.FileMode, exact, mkdir bool,
+	rchan chan *ContextRespectingIoFile, errchan chan error) {
+	var file *os.File
+	var err error
+
+	if mkdir {
+		err = makeDirs(filepath.Dir(fpath))
+		if err != nil {
+			errchan <- err
+			return
+		}
+	}
+
+	file, err = openFile(fpath, flag, perm, exact)
+	if err != nil {
+		var fi os.FileInfo
+		var serr error
+
+		// The error returned for directories differs between platforms.
+		fi, serr = os.Stat(fpath)
+		if serr == nil && fi.IsDir() {
+			err = &os.PathError{Op: "open", Path: fpath, Err: ErrIsDirectory}
+		} else if !mkdir && os.IsNotExist(err) {
+			_, serr = os.Stat(filepath.Dir(fpath))
+			if os.IsNotExist(serr) {
+				err = &os.PathError{Op: "open", Path: fpath, Err: ErrNoParentDirectory}
+			}
+		}
+		errchan <- err
+	} else {
+		rchan <- NewContextRespectingIoFile(file)
+	}
+}
+
+/*
+discardOpenedFile waits for the result of an asynchronous open which the
+caller stopped waiting for and closes the file if it was opened after all, so
+the file descriptor doesn't leak.
+*/
+func discardOpenedFile(rchan chan *ContextRespectingIoFile, errchan chan error) {
+	var f *ContextRespectingIoFile
+
+	select {
+	case <-errchan:
+	case f = <-rchan:
+		f.actualFile.Close()
+	}
+}
+
+/*
+discardOpenedReader is the equivalent of discardOpenedFile for other kinds of
+readers.
+*/
+func discardOpenedReader(rchan chan filesystem.ReadCloser, errchan chan error) {
+	var rc filesystem.ReadCloser
+
+	select {
+	case <-errchan:
+	case rc = <-rchan:
+		rc.Close(context.Background())
+	}
+}
+
+/*
+listBatchSize is the number of directory entries read at once when listing
+directories. The context is checked between batches, so huge directories
+don't keep a listing running long after the caller has given up.
+*/
+const listBatchSize = 1000
+
+/*
+readDirNames reads all names from the directory f in batches, stopping with
+the context's error if it is done before the end has been reached.
+*/
+func readDirNames(ctx context.Context, f *os.File) ([]string, error) {
+	var res []string
+	var batch []string
+	var err error
+
+	for {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		batch, err = f.Readdirnames(listBatchSize)
+		res = append(res, batch...)
+		if err == io.EOF {
+			return res, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+/*
+readDirInfos works like readDirNames, but returns the file information of
+the directory entries.
+*/
+func readDirInfos(ctx context.Context, f *os.File) ([]os.FileInfo, error) {
+	var res []os.FileInfo
+	var batch []os.FileInfo
+	var err error
+
+	for {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		batch, err = f.Readdir(listBatchSize)
+		res = append(res, batch...)
+		if err == io.EOF {
+			return res, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+/*
+openDir opens the directory at dirpath for reading its entries. If dirpath
+exists but isn't a directory, an error wrapping ErrNotDirectory is returned.
+*/
+func openDir(dirpath string) (*os.File, error) {
+	var f *os.File
+	var fi os.FileInfo
+	var err error
+
+	f, err = os.Open(dirpath)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err = f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		f.Close()
+		return nil, &os.PathError{Op: "readdir", Path: dirpath, Err: ErrNotDirectory}
+	}
+
+	return f, nil
+}
+
+func asnycListEntries(ctx context.Context, dirpath string, rch chan []string, errch chan error) {
+	var f *os.File
+	var res []string
+	var err error
+
+	f, err = openDir(dirpath)
+	if err != nil {
+		errch <- err
+		return
+	}
+	defer f.Close()
+
+	res, err = readDirNames(ctx, f)
+	if err != nil {
+		errch <- err
+		return
+	}
+	rch <- res
+}
+
+func asyncListEntriesDetailed(ctx context.Context, dirpath string, rch chan []os.FileInfo, errch chan error) {
+	var f *os.File
+	var res []os.FileInfo
+	var err error
+
+	f, err = openDir(dirpath)
+	if err != nil {
+		errch <- err
+		return
+	}
+	defer f.Close()
+
+	res, err = readDirInfos(ctx, f)
+	if err != nil {
+		errch <- err
+		return
+	}
+	rch <- res
+}
+
+/*
+countDirEntries counts the entries of the directory f in batches, stopping
+with the context's error if it is done before the end has been reached.
+*/
+func countDirEntries(ctx context.Context, f *os.File) (int, error) {
+	var count int
+	var batch []string
+	var err error
+
+	for {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+
+		batch, err = f.Readdirnames(listBatchSize)
+		count += len(batch)
+		if err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func asyncCountEntries(ctx context.Context, dirpath string, rch chan int, errch chan error) {
+	var f *os.File
+	var count int
+	var err error
+
+	f, err = openDir(dirpath)
+	if err != nil {
+		errch <- err
+		return
+	}
+	defer f.Close()
+
+	count, err = countDirEntries(ctx, f)
+	if err != nil {
+		errch <- err
+		return
+	}
+	rch <- count
+}
+
+func asyncStat(fpath string, rch chan os.FileInfo, errch chan error) {
+	var fi os.FileInfo
+	var err error
+
+	fi, err = os.Stat(fpath)
+	if err != nil {
+		errch <- err
+		return
+	}
+	rch <- fi
+}
+
+func asyncRemove(objpath string, errch chan error) {
+	errch <- os.Remove(objpath)
+}
+
+func asyncChmod(fpath string, mode os.FileMode, errch chan error) {
+	errch <- os.Chmod(fpath, mode)
+}
+
+func asyncChown(fpath string, uid, gid int, errch chan error) {
+	errch <- os.Chown(fpath, uid, gid)
+}
+
+/*
+wipeTarget is the part of *os.File used by SecureRemove for overwriting a
+file, so that the overwriting can be observed in tests.
+*/
+type wipeTarget interface {
+	Stat() (os.FileInfo, error)
+	WriteAt(p []byte, off int64) (int, error)
+	Sync() error
+	Close() error
+}
+
+/*
+openForWipe opens the file at fpath for being overwritten by SecureRemove.
+Only regular files are accepted: symbolic links would have their target
+overwritten while only the link is removed, and opening FIFOs could block
+forever. The opened file is compared against the Lstat result to catch the
+path being swapped in between.
+*/
+func openForWipe(fpath string) (wipeTarget, error) {
+	var fi, ofi os.FileInfo
+	var f *os.File
+	var err error
+
+	fi, err = os.Lstat(fpath)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: fpath, Err: ErrIsDirectory}
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, &os.PathError{Op: "open", Path: fpath, Err: ErrUnsupportedFileType}
+	}
+
+	f, err = os.OpenFile(fpath, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	ofi, err = f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !os.SameFile(fi, ofi) {
+		f.Close()
+		return nil, &os.PathError{Op: "open", Path: fpath, Err: ErrFileReplaced}
+	}
+	return f, nil
+}
+
+func asyncSecureRemove(ctx context.Context, fpath string, passes int,
+	open func(string) (wipeTarget, error), errch chan error) {
+	var f wipeTarget
+	var fi os.FileInfo
+	var buf = newChunkBuffer()
+	var pass int
+	var err error
+
+	f, err = open(fpath)
+	if err != nil {
+		errch <- err
+		return
+	}
+
+	fi, err = f.Stat()
+	if err != nil {
+		f.Close()
+		errch <- err
+		return
+	}
+
+	for pass = 0; pass < passes; pass++ {
+		var offset int64
+
+		// Stop overwriting if nobody is waiting for the result anymore.
+		if ctx.Err() != nil {
+			f.Close()
+			errch <- ctx.Err()
+			return
+		}
+
+		for offset = 0; offset < fi.Size(); {
+			var chunk = buf
+			var n int
+
+			if fi.Size()-offset < int64(len(chunk)) {
+				chunk = chunk[:fi.Size()-offset]
+			}
+
+			_, err = rand.Read(chunk)
+			if err != nil {
+				f.Close()
+				errch <- err
+				return
+			}
+
+			n, err = f.WriteAt(chunk, offset)
+			if err != nil {
+				f.Close()
+				errch <- err
+				return
+			}
+			offset += int64(n)
+		}
+
+		// Make sure this pass actually hit the disk before the next one.
+		err = f.Sync()
+		if err != nil {
+			f.Close()
+			errch <- err
+			return
+		}
+	}
+
+	err = f.Close()
+	if err != nil {
+		errch <- err
+		return
+	}
+
+	errch <- os.Remove(fpath)
+}
+
+/*
+operationContext derives the context for a single operation from the caller's
+context, applying the configured OperationTimeout if there is one. The cancel
+function must always be called.
+*/
+func (file *FileAdapter) operationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if file.OperationTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, file.OperationTimeout)
+}
+
+/*
+openForReading opens the file at the specified URL for reading using the
+function open in a subthread and waits for the result as long as the context
+permits.
+*/
+func (file *FileAdapter) openForReading(ctx context.Context, fileurl *url.URL,
+	open func(string) (*os.File, error)) (*ContextRespectingIoFile, error) {
+	var rchan = make(chan *ContextRespectingIoFile, 1)
+	var errchan = make(chan error, 1)
+	var cancel context.CancelFunc
+	var f *ContextRespectingIoFile
+	var fpath string
+	var err error
+
+	fpath, err = file.localPath(fileurl)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel = file.operationContext(ctx)
+	defer cancel()
+
+	go asyncOpenRead(fpath, open, rchan, errchan)
+	select {
+	case <-ctx.Done():
+		go discardOpenedFile(rchan, errchan)
+		return nil, ctx.Err()
+	case err = <-errchan:
+		return nil, urlError("open", fileurl, err)
+	case f = <-rchan:
+		f.timeout = file.OperationTimeout
+		f.reopen = func() (*os.File, error) {
+			return open(fpath)
+		}
+		return f, nil
+	}
+}
+
+/*
+openForWriting opens the file at the specified URL with the specified flags,
+creating its parent directories as required, in a subthread and waits for the
+result as long as the context permits.
+*/
+func (file *FileAdapter) openForWriting(
+	ctx context.Context, fileurl *url.URL, flag int) (*ContextRespectingIoFile, error) {
+	return file.openForWritingIn(ctx, fileurl, flag, true)
+}
+
+/*
+openForWritingIn works like openForWriting, but only creates the parent
+directories if mkdir is set.
+*/
+func (file *FileAdapter) openForWritingIn(ctx context.Context, fileurl *url.URL,
+	flag int, mkdir bool) (*ContextRespectingIoFile, error) {
+	var rchan = make(chan *ContextRespectingIoFile, 1)
+	var errchan = make(chan error, 1)
+	var cancel context.CancelFunc
+	var f *ContextRespectingIoFile
+	var fpath string
+	var err error
+
+	fpath, err = file.localPath(fileurl)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel = file.operationContext(ctx)
+	defer cancel()
+
+	go asyncOpenWrite(fpath, flag, file.fileMode(), file.ExactFileMode, mkdir, rchan, errchan)
+	select {
+	case <-ctx.Done():
+		go discardOpenedFile(rchan, errchan)
+		return nil, ctx.Err()
+	case err = <-errchan:
+		return nil, urlError("open", fileurl, err)
+	case f = <-rchan:
+		f.timeout = file.OperationTimeout
+		f.reopen = func() (*os.File, error) {
+			// Whatever was there before must neither be truncated nor
+			// prevent reopening, and new data goes to the end.
+			return openFile(fpath, flag&^(os.O_TRUNC|os.O_EXCL)|os.O_CREATE|os.O_APPEND,
+				file.fileMode(), file.ExactFileMode)
+		}
+		return f, nil
+	}
+}
+
+/*
+Asynchronously create a reader reading from the specified file. The actual
+opening will happen in a subthread so that we have a guaranteed response time
+from this function in case the operation exceeds the alotted time limits.
+Directories cannot be read from; for them, an error wrapping ErrIsDirectory
+is returned.
+
+The reader reads whatever the file contains at the time of each read. If the
+file is truncated while it is being read, reads simply hit the end of the
+file early and report io.EOF, indistinguishable from a shorter file; use
+OpenReaderDetectTruncation to find out about this.
+*/
+func (file *FileAdapter) OpenReader(
+	ctx context.Context, fileurl *url.URL) (filesystem.ReadCloser, error) {
+	var f *ContextRespectingIoFile
+	var err error
+
+	f, err = file.openForReading(ctx, fileurl, os.Open)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+/*
+OpenReaderNoFollow works like OpenReader, but refuses to open the file if the
+last component of its path is a symbolic link, rather than following it. This
+is useful when opening files in directories others can write to. Intermediate
+directories may still be symbolic links.
+*/
+func (file *FileAdapter) OpenReaderNoFollow(
+	ctx context.Context, fileurl *url.URL) (filesystem.ReadCloser, error) {
+	var f *ContextRespectingIoFile
+	var err error
+
+	f, err = file.openForReading(ctx, fileurl, openNoFollow)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+/*
+OpenReaderNonBlocking works like OpenReader, but opens the file in
+non-blocking mode. This is meant for named pipes (FIFOs): opening one
+normally blocks until a writer opens the other end, and since that can take
+forever, a cancelled open would leave a goroutine stuck in the operating
+system. In non-blocking mode, the open returns right away, and reads honor
+the context through I/O deadlines. Note that reads report io.EOF as long as
+no writer has the FIFO open, including before the first one connects. For
+regular files, the mode makes no difference. Files which cannot be polled for
+I/O deadlines, like some devices, are read with retries and a short backoff
+while no data is available, until the context is done.
+*/
+func (file *FileAdapter) OpenReaderNonBlocking(
+	ctx context.Context, fileurl *url.URL) (filesystem.ReadCloser, error) {
+	var f *ContextRespectingIoFile
+	var err error
+
+	f, err = file.openForReading(ctx, fileurl, openNonBlocking)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+/*
+Asynchronously create a writer writing to the specified file, overwriting all
+existent contents. The actual opening will happen in a subthread so that we
+have a guaranteed response time from this function in case the operation
+exceeds the alotted time limits. If the path refers to a directory, an error
+wrapping ErrIsDirectory is returned.
+*/
+func (file *FileAdapter) OpenWriter(
+	ctx context.Context, fileurl *url.URL) (filesystem.WriteCloser, error) {
+	var f *ContextRespectingIoFile
+	var err error
+
+	f, err = file.openForWriting(ctx, fileurl, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+/*
+OpenWriterNoMkdir works like OpenWriter, but doesn't create any missing
+parent directories, so that a mistyped path doesn't silently end up creating
+new directories. If the directory to create the file in doesn't exist, the
+error wraps ErrNoParentDirectory.
+*/
+func (file *FileAdapter) OpenWriterNoMkdir(
+	ctx context.Context, fileurl *url.URL) (filesystem.WriteCloser, error) {
+	var f *ContextRespectingIoFile
+	var err error
+
+	f, err = file.openForWritingIn(ctx, fileurl, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, false)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+/*
+Asynchronously create a writer writing to the specified file, appending to the
+end of existent contents. The actual opening will happen in a subthread so
+that we have a guaranteed response time from this function in case the
+operation exceeds the alotted time limits.
+*/
+func (file *FileAdapter) OpenAppender(
+	ctx context.Context, fileurl *url.URL) (filesystem.WriteCloser, error) {
+	var f *ContextRespectingIoFile
+	var err error
+
+	f, err = file.openForWriting(ctx, fileurl, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+/*
+OpenAppenderWithSize works like OpenAppender, but also returns the size of
+the file at the time it was opened, i.e. the offset at which the first write
+will end up. The size is determined from the opened file rather than its path,
+so it cannot be confused by the file being replaced concurrently.
+*/
+func (file *FileAdapter) OpenAppenderWithSize(
+	ctx context.Context, fileurl *url.URL) (filesystem.WriteCloser, int64, error) {
+	var f *ContextRespectingIoFile
+	var fi os.FileInfo
+	var err error
+
+	f, err = file.openForWriting(ctx, fileurl, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	fi, err = f.stat(ctx)
+	if err != nil {
+		f.Close(context.Background())
+		return nil, 0, urlError("stat", fileurl, err)
+	}
+
+	return f, fi.Size(), nil
 }
 
 /*
-ContextRespectingIoFile represents a regular file object from the OS, but with
-implementations of respecting deadlines and cancellations from contexts.
+OpenWriterExclusive works like OpenWriter, but fails if the file already
+exists, which makes it suitable for lock files and one-time initialization.
+The check and the creation happen atomically. If the file exists, the error
+satisfies errors.Is(err, os.ErrExist).
 */
-type ContextRespectingIoFile struct {
-	actualFile *os.File
-}
+func (file *FileAdapter) OpenWriterExclusive(
+	ctx context.Context, fileurl *url.URL) (filesystem.WriteCloser, error) {
+	var f *ContextRespectingIoFile
+	var err error
 
-type asyncReadResult struct {
-	Data   []byte
-	Length int
-	Error  error
+	f, err = file.openForWriting(ctx, fileurl, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
-func (f *ContextRespectingIoFile) asyncRead(length int, rchan chan *asyncReadResult) {
-	var result = new(asyncReadResult)
-
-	result.Data = make([]byte, length)
-	result.Length, result.Error = f.actualFile.Read(result.Data)
-	rchan <- result
-}
+/*
+OpenWriterWithFlags works like OpenWriter, but opens the file with the
+specified os.OpenFile flags instead of truncating it. The file is always
+created if it doesn't exist, and opened write-only unless the flags ask for
+os.O_RDWR, so e.g. passing os.O_APPEND|os.O_SYNC yields a synchronous
+appender.
 
-func (f *ContextRespectingIoFile) asyncWrite(b []byte, lench chan int, errch chan error) {
-	var length int
+Flags other than the ones defined in the os package, such as syscall.O_DIRECT
+or syscall.O_NOFOLLOW, are platform specific: they don't exist everywhere,
+may be silently ignored on some systems and may impose additional
+requirements (O_DIRECT e.g. requires aligned buffers).
+*/
+func (file *FileAdapter) OpenWriterWithFlags(
+	ctx context.Context, fileurl *url.URL, flag int) (filesystem.WriteCloser, error) {
+	var f *ContextRespectingIoFile
 	var err error
 
-	length, err = f.actualFile.Write(b)
-	lench <- length
-	errch <- err
-}
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		flag |= os.O_WRONLY
+	}
 
-func (f *ContextRespectingIoFile) asyncClose(errch chan error) {
-	errch <- f.actualFile.Close()
+	f, err = file.openForWriting(ctx, fileurl, flag|os.O_CREATE)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
 /*
-Read() provides regular read semantics, but with support for cancelling
-reads or providing deadlines for them.
+ListEntries asynchronously reads the contents of a directory and returns the
+relative names of files and subdirectories in it. The actual enumeration will
+happen in a subthread so that we have a guaranteed response time from this
+function in case the operation exceeds the alotted time limits. Entries are
+read in batches, and the enumeration stops early once the context is done.
+If the URL points to something other than a directory, the error satisfies
+errors.Is(err, ErrNotDirectory).
 */
-func (f *ContextRespectingIoFile) Read(ctx context.Context, p []byte) (l int, err error) {
-	var result *asyncReadResult
-	var rchan = make(chan *asyncReadResult, 1)
-	go f.asyncRead(len(p), rchan)
+func (file *FileAdapter) ListEntries(ctx context.Context, dirurl *url.URL) ([]string, error) {
+	var rch = make(chan []string, 1)
+	var errch = make(chan error, 1)
+	var results []string
+	var dirpath string
+	var err error
+
+	dirpath, err = file.localPath(dirurl)
+	if err != nil {
+		return results, err
+	}
+
+	go asnycListEntries(ctx, dirpath, rch, errch)
 
 	select {
 	case <-ctx.Done():
-		return 0, ctx.Err()
-	case result = <-rchan:
-		if result.Error == nil {
-			copy(p, result.Data)
-		}
-		return result.Length, result.Error
+		return results, ctx.Err()
+	case err = <-errch:
+		return results, urlError("list", dirurl, err)
+	case results = <-rch:
+		return results, nil
 	}
 }
 
 /*
-Write() provides regular write semantics, but with support for cancelling
-writes or providing deadlines for them.
+ListEntriesDetailed works like ListEntries, but returns the file information
+(size, mode, modification time etc.) of every entry rather than just its name,
+saving callers from having to stat every entry separately.
 */
-func (f *ContextRespectingIoFile) Write(ctx context.Context, b []byte) (int, error) {
-	var lench = make(chan int, 1)
+func (file *FileAdapter) ListEntriesDetailed(ctx context.Context, dirurl *url.URL) ([]os.FileInfo, error) {
+	var rch = make(chan []os.FileInfo, 1)
 	var errch = make(chan error, 1)
-	var nb = make([]byte, len(b))
+	var results []os.FileInfo
+	var dirpath string
 	var err error
-	var length int
-	copy(nb, b)
 
-	go f.asyncWrite(nb, lench, errch)
+	dirpath, err = file.localPath(dirurl)
+	if err != nil {
+		return results, err
+	}
+
+	go asyncListEntriesDetailed(ctx, dirpath, rch, errch)
 
 	select {
 	case <-ctx.Done():
-		return 0, ctx.Err()
+		return results, ctx.Err()
 	case err = <-errch:
-		length = <-lench
-		return length, err
+		return results, urlError("list", dirurl, err)
+	case results = <-rch:
+		return results, nil
 	}
 }
 
 /*
-Tell() determines the current offset inside the file and returns it.
+CountEntries determines the number of files and subdirectories in a
+directory. Names are read in batches like for ListEntries, but only counted,
+so that huge directories don't have to be held in memory. The directory is
+read in a subthread which stops early once the context is done. If the URL
+points to something other than a directory, the error satisfies
+errors.Is(err, ErrNotDirectory).
 */
-func (f *ContextRespectingIoFile) Tell(ctx context.Context) (int64, error) {
-	return f.actualFile.Seek(0, io.SeekCurrent)
-}
+func (file *FileAdapter) CountEntries(ctx context.Context, dirurl *url.URL) (int, error) {
+	var rch = make(chan int, 1)
+	var errch = make(chan error, 1)
+	var count int
+	var dirpath string
+	var err error
 
-/*
-Seek() sets the current position in the file to the absolute offset specified.
-*/
-func (f *ContextRespectingIoFile) Seek(
-	ctx context.Context, offset int64, whence int) (int64, error) {
-	return f.actualFile.Seek(offset, io.SeekStart)
+	dirpath, err = file.localPath(dirurl)
+	if err != nil {
+		return 0, err
+	}
+
+	go asyncCountEntries(ctx, dirpath, rch, errch)
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case err = <-errch:
+		return 0, urlError("list", dirurl, err)
+	case count = <-rch:
+		return count, nil
+	}
 }
 
 /*
-Skip() skips forward by the specified number of bytes without actually reading
-the data.
+ListEntriesSorted works like ListEntries, but returns the names sorted
+lexicographically by byte value, so that the order doesn't depend on the
+file system.
 */
-func (f *ContextRespectingIoFile) Skip(ctx context.Context, n int64) error {
+func (file *FileAdapter) ListEntriesSorted(ctx context.Context, dirurl *url.URL) ([]string, error) {
+	var names []string
 	var err error
-	_, err = f.actualFile.Seek(n, io.SeekCurrent)
-	return err
+
+	names, err = file.ListEntries(ctx, dirurl)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
 }
 
 /*
-Close() provides regular close semantics, but with support for cancelling
-waiting for closes to finish (which may be important due to caches) or
-providing deadlines for them.
+ListEntriesByModTime works like ListEntries, but returns the names sorted by
+the modification time of the entries, oldest first. Entries modified at the
+same time are sorted by name.
 */
-func (f *ContextRespectingIoFile) Close(ctx context.Context) error {
-	var errch = make(chan error, 1)
+func (file *FileAdapter) ListEntriesByModTime(ctx context.Context, dirurl *url.URL) (
+	[]string, error) {
+	var infos []os.FileInfo
+	var names []string
+	var fi os.FileInfo
 	var err error
 
-	go f.asyncClose(errch)
+	infos, err = file.ListEntriesDetailed(ctx, dirurl)
+	if err != nil {
+		return nil, err
+	}
 
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case err = <-errch:
-		return err
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].ModTime().Equal(infos[j].ModTime()) {
+			return infos[i].Name() < infos[j].Name()
+		}
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+
+	names = make([]string, 0, len(infos))
+	for _, fi = range infos {
+		names = append(names, fi.Name())
 	}
+	return names, nil
 }
 
 /*
-NewContextRespectingIoFile wraps a regular os.File so we get a context
-respecting API on it.
+Watch for changes affecting the file pointed to. The current state of the file
+will be notified at first as the initial change. The context governs opening
+the file for that initial report, and for directories the scan reporting the
+initial state of every file in them: cancelling it aborts the watch before it
+is established. Once WatchFile has returned, the context no longer matters;
+use the returned function to cancel the watch.
 */
-func NewContextRespectingIoFile(actualFile *os.File) *ContextRespectingIoFile {
-	return &ContextRespectingIoFile{actualFile: actualFile}
-}
-
-func asyncOpenRead(path string, rchan chan filesystem.ReadCloser, errchan chan error) {
-	var file *os.File
+func (file *FileAdapter) WatchFile(ctx context.Context, fileurl *url.URL, notify filesystem.FileWatchFunc) (filesystem.CancelWatchFunc, chan error, error) {
+	var watcher *FileWatcher
 	var err error
 
-	file, err = os.Open(path)
+	watcher, err = newFileWatcher(ctx, file, fileurl, withoutContext(notify), nil)
 	if err != nil {
-		errchan <- err
-	} else {
-		rchan <- NewContextRespectingIoFile(file)
+		return nil, nil, urlError("watch", fileurl, err)
 	}
+
+	return watcher.Shutdown, watcher.ErrChan(), nil
 }
 
-func asyncOpenWrite(fpath string, flag int, rchan chan filesystem.WriteCloser, errchan chan error) {
-	var file *os.File
+/*
+WatchFileContext works like WatchFile, but the callback is passed a context
+which is cancelled when the watch is cancelled, so that work done in the
+callback can be stopped along with the watch.
+*/
+func (file *FileAdapter) WatchFileContext(ctx context.Context, fileurl *url.URL,
+	notify ContextFileWatchFunc) (filesystem.CancelWatchFunc, chan error, error) {
+	return file.WatchFileWithOptions(ctx, fileurl, notify, nil)
+}
+
+/*
+WatchFileWithOptions works like WatchFileContext, but takes additional
+settings such as a filter from opts, which may be nil.
+*/
+func (file *FileAdapter) WatchFileWithOptions(ctx context.Context, fileurl *url.URL,
+	notify ContextFileWatchFunc, opts *WatchOptions) (filesystem.CancelWatchFunc, chan error, error) {
+	var watcher *FileWatcher
 	var err error
 
-	err = os.MkdirAll(path.Dir(fpath), 0755)
+	watcher, err = newFileWatcher(ctx, file, fileurl, notify, opts)
 	if err != nil {
-		errchan <- err
-		return
+		return nil, nil, urlError("watch", fileurl, err)
 	}
 
-	file, err = os.OpenFile(fpath, flag, 0644)
-	if err != nil {
-		errchan <- err
-	} else {
-		rchan <- NewContextRespectingIoFile(file)
-	}
+	return watcher.Shutdown, watcher.ErrChan(), nil
 }
 
-func asnycListEntries(dirurl *url.URL, rch chan []string, errch chan error) {
-	var f *os.File
-	var res []string
+/*
+Remove asynchronously deletes the object pointed to from the file system,
+without touching any entries below it (files, subdirectories). The actual
+deletion will happen in a subthread so that we have a guaranteed response
+time from this function in case the operation exceeds the alotted time limits.
+
+If there is nothing to remove, the error satisfies
+errors.Is(err, os.ErrNotExist); if the permissions don't allow removing it,
+errors.Is(err, os.ErrPermission) holds instead. RemoveIfExists can be used
+if a missing object is fine.
+*/
+func (file *FileAdapter) Remove(ctx context.Context, objurl *url.URL) error {
+	var errch = make(chan error, 1)
+	var objpath string
 	var err error
 
-	f, err = os.Open(dirurl.Path)
+	objpath, err = file.localPath(objurl)
 	if err != nil {
-		errch <- err
-		return
+		return err
 	}
-	defer f.Close()
 
-	res, err = f.Readdirnames(-1)
-	if err != nil {
-		errch <- err
-		return
-	}
-	rch <- res
-}
+	go asyncRemove(objpath, errch)
 
-func asyncRemove(objurl *url.URL, errch chan error) {
-	errch <- os.Remove(objurl.Path)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-errch:
+		return urlError("remove", objurl, err)
+	}
 }
 
 /*
-Asynchronously create a reader reading from the specified file. The actual
-opening will happen in a subthread so that we have a guaranteed response time
-from this function in case the operation exceeds the alotted time limits.
+RemoveIfExists works like Remove, but treats the object not existing in the
+first place as success, e.g. for cleaning up after something which may or
+may not have created it. All other errors, such as missing permissions, are
+still reported.
 */
-func (file *FileAdapter) OpenReader(
-	ctx context.Context, fileurl *url.URL) (rc filesystem.ReadCloser, err error) {
-	var rchan = make(chan filesystem.ReadCloser, 1)
-	var errchan = make(chan error, 1)
-	go asyncOpenRead(fileurl.Path, rchan, errchan)
-	select {
-	case <-ctx.Done():
-		err = ctx.Err()
-		return
-	case err = <-errchan:
-		return
-	case rc = <-rchan:
-		return
+func (file *FileAdapter) RemoveIfExists(ctx context.Context, objurl *url.URL) error {
+	var err error
+
+	err = file.Remove(ctx, objurl)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
 	}
+	return err
 }
 
 /*
-Asynchronously create a writer writing to the specified file, overwriting all
-existent contents. The actual opening will happen in a subthread so that we
-have a guaranteed response time from this function in case the operation
-exceeds the alotted time limits.
+SecureRemove overwrites the contents of the file pointed to with random data
+the specified number of times, syncing to disk after every pass, and then
+deletes it. At least one pass is always made. The actual work happens in a
+subthread so that we have a guaranteed response time from this function in
+case the operation exceeds the alotted time limits; the overwriting stops at
+the next pass if the context is cancelled. Only regular files can be removed
+this way; symbolic links and other special files are refused with an error
+wrapping ErrUnsupportedFileType, leaving them and whatever they point to alone.
+
+This is a best-effort measure only. Journaling and copy-on-write file systems
+(e.g. btrfs, ZFS, APFS), file system snapshots and the wear leveling of SSDs
+and flash storage may all keep copies of the old contents around which are
+not reached by overwriting the file in place.
 */
-func (file *FileAdapter) OpenWriter(
-	ctx context.Context, fileurl *url.URL) (rc filesystem.WriteCloser, err error) {
-	var rchan = make(chan filesystem.WriteCloser, 1)
-	var errchan = make(chan error, 1)
-	go asyncOpenWrite(fileurl.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
-		rchan, errchan)
+func (file *FileAdapter) SecureRemove(ctx context.Context, fileurl *url.URL, passes int) error {
+	var errch = make(chan error, 1)
+	var fpath string
+	var err error
+
+	fpath, err = file.localPath(fileurl)
+	if err != nil {
+		return err
+	}
+
+	if passes < 1 {
+		passes = 1
+	}
+
+	go asyncSecureRemove(ctx, fpath, passes, openForWipe, errch)
+
 	select {
 	case <-ctx.Done():
-		err = ctx.Err()
-		return
-	case err = <-errchan:
-		return
-	case rc = <-rchan:
-		return
+		return ctx.Err()
+	case err = <-errch:
+		return urlError("remove", fileurl, err)
 	}
 }
 
 /*
-Asynchronously create a writer writing to the specified file, appending to the
-end of existent contents. The actual opening will happen in a subthread so
-that we have a guaranteed response time from this function in case the
-operation exceeds the alotted time limits.
+Chmod asynchronously changes the permission bits of the object pointed to.
+The actual change will happen in a subthread so that we have a guaranteed
+response time from this function in case the operation exceeds the alotted
+time limits.
 */
-func (file *FileAdapter) OpenAppender(
-	ctx context.Context, fileurl *url.URL) (rc filesystem.WriteCloser, err error) {
-	var rchan = make(chan filesystem.WriteCloser, 1)
-	var errchan = make(chan error, 1)
-	go asyncOpenWrite(fileurl.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
-		rchan, errchan)
+func (file *FileAdapter) Chmod(ctx context.Context, fileurl *url.URL, mode os.FileMode) error {
+	var errch = make(chan error, 1)
+	var fpath string
+	var err error
+
+	fpath, err = file.localPath(fileurl)
+	if err != nil {
+		return err
+	}
+
+	go asyncChmod(fpath, mode, errch)
+
 	select {
 	case <-ctx.Done():
-		err = ctx.Err()
-		return
-	case err = <-errchan:
-		return
-	case rc = <-rchan:
-		return
+		return ctx.Err()
+	case err = <-errch:
+		return urlError("chmod", fileurl, err)
 	}
 }
 
 /*
-ListEntries asynchronously reads the contents of a directory and returns the
-relative names of files and subdirectories in it. The actual enumeration will
+Chown asynchronously changes the owning user and group of the object pointed
+to. Passing -1 as either uid or gid leaves that value unchanged. Changing the
+owner usually requires elevated privileges. On platforms without POSIX
+ownership (e.g. Windows) this always returns an error. The actual change will
 happen in a subthread so that we have a guaranteed response time from this
 function in case the operation exceeds the alotted time limits.
 */
-func (file *FileAdapter) ListEntries(ctx context.Context, dirurl *url.URL) ([]string, error) {
-	var rch = make(chan []string, 1)
+func (file *FileAdapter) Chown(ctx context.Context, fileurl *url.URL, uid, gid int) error {
 	var errch = make(chan error, 1)
-	var results []string
+	var fpath string
 	var err error
 
-	go asnycListEntries(dirurl, rch, errch)
+	fpath, err = file.localPath(fileurl)
+	if err != nil {
+		return err
+	}
+
+	go asyncChown(fpath, uid, gid, errch)
 
 	select {
 	case <-ctx.Done():
-		return results, ctx.Err()
+		return ctx.Err()
 	case err = <-errch:
-		return results, err
-	case results = <-rch:
-		return results, nil
+		return urlError("chown", fileurl, err)
 	}
 }
 
 /*
-Watch for changes affecting the file pointed to. Context is ignored since it
-probably wouldn't be meaningful in this context. The current state of the file
-will be notified at first as the initial change.
+Exists asynchronously determines whether the object pointed to exists, without
+opening it. A missing object is reported as false without an error; any other
+problem determining the answer (e.g. missing permissions on a parent
+directory) is returned as is.
 */
-func (file *FileAdapter) WatchFile(ctx context.Context, fileurl *url.URL, notify filesystem.FileWatchFunc) (filesystem.CancelWatchFunc, chan error, error) {
-	var watcher *FileWatcher
+func (file *FileAdapter) Exists(ctx context.Context, fileurl *url.URL) (bool, error) {
+	var rch = make(chan os.FileInfo, 1)
+	var errch = make(chan error, 1)
+	var fpath string
 	var err error
 
-	watcher, err = NewFileWatcher(ctx, fileurl, notify)
+	fpath, err = file.localPath(fileurl)
 	if err != nil {
-		return nil, nil, err
+		return false, err
 	}
 
-	return watcher.Shutdown, watcher.ErrChan(), nil
+	go asyncStat(fpath, rch, errch)
+
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case err = <-errch:
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, urlError("stat", fileurl, err)
+	case <-rch:
+		return true, nil
+	}
 }
 
 /*
-Remove asynchronously deletes the object pointed to from the file system,
-without touching any entries below it (files, subdirectories). The actual
-deletion will happen in a subthread so that we have a guaranteed response
-time from this function in case the operation exceeds the alotted time limits.
+Size asynchronously determines the size of the file pointed to in bytes.
+Directories don't have a meaningful size, so ErrIsDirectory is returned for
+them.
 */
-func (file *FileAdapter) Remove(ctx context.Context, objurl *url.URL) error {
+func (file *FileAdapter) Size(ctx context.Context, fileurl *url.URL) (int64, error) {
+	var rch = make(chan os.FileInfo, 1)
 	var errch = make(chan error, 1)
+	var fi os.FileInfo
+	var fpath string
 	var err error
 
-	go asyncRemove(objurl, errch)
+	fpath, err = file.localPath(fileurl)
+	if err != nil {
+		return 0, err
+	}
+
+	go asyncStat(fpath, rch, errch)
 
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
+		return 0, ctx.Err()
 	case err = <-errch:
-		return err
+		return 0, urlError("stat", fileurl, err)
+	case fi = <-rch:
+		if fi.IsDir() {
+			return 0, urlError("size", fileurl, ErrIsDirectory)
+		}
+		return fi.Size(), nil
 	}
 }
@@ -0,0 +1,1637 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+testDir creates a temporary directory for a test, with symbolic links in its
+path resolved, so that it can be compared against paths reported by the
+adapter.
+*/
+func testDir(t *testing.T) string {
+	var dir string
+	var err error
+
+	dir, err = filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+/*
+fileURL creates a file URL for the local path fpath.
+*/
+func fileURL(fpath string) *url.URL {
+	fpath = filepath.ToSlash(fpath)
+	if filepath.VolumeName(fpath) != "" {
+		fpath = "/" + fpath
+	}
+	return &url.URL{Scheme: "file", Path: fpath}
+}
+
+/*
+writeTestFile creates the file fpath with the specified contents, failing the
+test if that doesn't work.
+*/
+func writeTestFile(t testing.TB, fpath, contents string) {
+	var err error
+
+	err = os.WriteFile(fpath, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+/*
+ioReader adapts a filesystem.ReadCloser to io.Reader, using the specified
+context for every read.
+*/
+type ioReader struct {
+	ctx context.Context
+	r   filesystem.ReadCloser
+}
+
+func (r ioReader) Read(p []byte) (int, error) {
+	return r.r.Read(r.ctx, p)
+}
+
+/*
+readAndClose reads everything from r and closes it, returning the contents.
+*/
+func readAndClose(t *testing.T, r filesystem.ReadCloser) string {
+	var data []byte
+	var err error
+
+	data, err = io.ReadAll(ioReader{ctx: context.Background(), r: r})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = r.Close(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	return string(data)
+}
+
+/*
+openTestFile opens the file fpath for reading through the default adapter.
+*/
+func openTestFile(t testing.TB, fpath string) *ContextRespectingIoFile {
+	var r filesystem.ReadCloser
+	var err error
+
+	t.Helper()
+
+	r, err = DefaultAdapter().OpenReader(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		r.Close(context.Background())
+	})
+	return r.(*ContextRespectingIoFile)
+}
+
+func TestPeekThenRead(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var f *ContextRespectingIoFile
+	var peeked []byte
+	var data []byte
+	var err error
+
+	writeTestFile(t, fpath, "GIF89a and the rest")
+	f = openTestFile(t, fpath)
+
+	peeked, err = f.Peek(context.Background(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(peeked) != "GIF8" {
+		t.Errorf("peeked %q, want %q", peeked, "GIF8")
+	}
+
+	data, err = io.ReadAll(ioReader{ctx: context.Background(), r: f})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "GIF89a and the rest" {
+		t.Errorf("read %q after peeking, want the full contents", data)
+	}
+
+	// At the end, there is nothing left to peek at.
+	peeked, err = f.Peek(context.Background(), 4)
+	if err != io.EOF || len(peeked) != 0 {
+		t.Errorf("peeking at the end returned %q, %v, want io.EOF", peeked, err)
+	}
+}
+
+func TestPeekCounts(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var f *ContextRespectingIoFile
+	var peeked []byte
+	var err error
+
+	writeTestFile(t, fpath, "abc")
+	f = openTestFile(t, fpath)
+
+	peeked, err = f.Peek(context.Background(), 0)
+	if err != nil || peeked == nil || len(peeked) != 0 {
+		t.Errorf("peeking at nothing returned %q, %v", peeked, err)
+	}
+	if f.BytesRead() != 0 {
+		t.Errorf("peeking at nothing read %d bytes", f.BytesRead())
+	}
+
+	_, err = f.Peek(context.Background(), -1)
+	if !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("peeking at a negative count returned %v, want %v", err, os.ErrInvalid)
+	}
+
+	peeked, err = f.Peek(context.Background(), 10)
+	if err != io.EOF || string(peeked) != "abc" {
+		t.Errorf("peeking beyond the end returned %q, %v, want %q, io.EOF", peeked, err, "abc")
+	}
+}
+
+/*
+recordingWipe records how SecureRemove overwrites a file: the number of bytes
+written in every pass, as delimited by calls to Sync, and whether any write
+left the original contents in place.
+*/
+type recordingWipe struct {
+	*os.File
+	original  []byte
+	passes    []int64
+	current   int64
+	unchanged bool
+	closed    bool
+}
+
+func (w *recordingWipe) WriteAt(p []byte, off int64) (int, error) {
+	if bytes.Equal(p, w.original[off:off+int64(len(p))]) {
+		w.unchanged = true
+	}
+	w.current += int64(len(p))
+	return w.File.WriteAt(p, off)
+}
+
+func (w *recordingWipe) Sync() error {
+	w.passes = append(w.passes, w.current)
+	w.current = 0
+	return w.File.Sync()
+}
+
+func (w *recordingWipe) Close() error {
+	w.closed = true
+	return w.File.Close()
+}
+
+func TestSecureRemoveOverwritesBeforeRemoving(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "secret")
+	var original = bytes.Repeat([]byte("secret "), 20000)
+	var errch = make(chan error, 1)
+	var wipe *recordingWipe
+	var pass int64
+	var err error
+
+	writeTestFile(t, fpath, string(original))
+
+	go asyncSecureRemove(context.Background(), fpath, 3, func(fpath string) (wipeTarget, error) {
+		var f *os.File
+		var err error
+
+		f, err = os.OpenFile(fpath, os.O_WRONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+		wipe = &recordingWipe{File: f, original: original}
+		return wipe, nil
+	}, errch)
+
+	err = <-errch
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wipe.passes) != 3 {
+		t.Errorf("file overwritten in %d synced passes, want 3", len(wipe.passes))
+	}
+	for _, pass = range wipe.passes {
+		if pass != int64(len(original)) {
+			t.Errorf("pass overwrote %d bytes, want %d", pass, len(original))
+		}
+	}
+	if wipe.current != 0 {
+		t.Errorf("%d bytes written after the last sync", wipe.current)
+	}
+	if wipe.unchanged {
+		t.Error("parts of the file were overwritten with their original contents")
+	}
+	if !wipe.closed {
+		t.Error("file not closed before removing it")
+	}
+
+	_, err = os.Stat(fpath)
+	if !os.IsNotExist(err) {
+		t.Errorf("file still exists after overwriting it: %v", err)
+	}
+}
+
+func TestSecureRemove(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "secret")
+	var err error
+
+	writeTestFile(t, fpath, "secret")
+
+	err = DefaultAdapter().SecureRemove(context.Background(), fileURL(fpath), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(fpath)
+	if !os.IsNotExist(err) {
+		t.Errorf("file still exists after removing it: %v", err)
+	}
+
+	err = DefaultAdapter().SecureRemove(context.Background(), fileURL(fpath), 1)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("removing a missing file returned %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestSecureRemoveSymlink(t *testing.T) {
+	var dir = testDir(t)
+	var target = filepath.Join(dir, "target")
+	var link = filepath.Join(dir, "link")
+	var contents []byte
+	var err error
+
+	writeTestFile(t, target, "precious")
+	symlinkOrSkip(t, target, link)
+
+	err = DefaultAdapter().SecureRemove(context.Background(), fileURL(link), 1)
+	if !errors.Is(err, ErrUnsupportedFileType) {
+		t.Errorf("removing a link returned %v, want %v", err, ErrUnsupportedFileType)
+	}
+
+	// The target the link points to must not have been overwritten.
+	contents, err = os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "precious" {
+		t.Errorf("link target now holds %q, want %q", contents, "precious")
+	}
+	_, err = os.Lstat(link)
+	if err != nil {
+		t.Errorf("refused link was removed anyway: %v", err)
+	}
+}
+
+func TestErrorsNameURL(t *testing.T) {
+	var dir = testDir(t)
+	var adapter = &FileAdapter{BaseDir: dir}
+	var ctx = context.Background()
+	var missing = &url.URL{Scheme: "file", Path: "/sub/missing"}
+	var plain = &url.URL{Scheme: "file", Path: "/plain"}
+	var below = &url.URL{Scheme: "file", Path: "/plain/below"}
+	var sub = &url.URL{Scheme: "file", Path: "/sub"}
+	var tests = []struct {
+		name string
+		url  *url.URL
+		call func() error
+		want error
+	}{
+		{"OpenReader", missing, func() error {
+			_, err := adapter.OpenReader(ctx, missing)
+			return err
+		}, os.ErrNotExist},
+		{"OpenReaderAutoDecompress", missing, func() error {
+			_, err := adapter.OpenReaderAutoDecompress(ctx, missing)
+			return err
+		}, os.ErrNotExist},
+		{"OpenMappedReader", missing, func() error {
+			_, err := adapter.OpenMappedReader(ctx, missing)
+			return err
+		}, os.ErrNotExist},
+		{"ReadFileLimited", missing, func() error {
+			_, err := adapter.ReadFileLimited(ctx, missing, 100)
+			return err
+		}, os.ErrNotExist},
+		{"Digest", missing, func() error {
+			_, err := adapter.Digest(ctx, missing, sha256.New())
+			return err
+		}, os.ErrNotExist},
+		{"ListEntries", missing, func() error {
+			_, err := adapter.ListEntries(ctx, missing)
+			return err
+		}, os.ErrNotExist},
+		{"ListEntriesPage", missing, func() error {
+			_, _, err := adapter.ListEntriesPage(ctx, missing, "", 10)
+			return err
+		}, os.ErrNotExist},
+		{"OpenDirIterator", missing, func() error {
+			_, err := adapter.OpenDirIterator(ctx, missing)
+			return err
+		}, os.ErrNotExist},
+		{"OpenDirHandle", missing, func() error {
+			_, err := adapter.OpenDirHandle(ctx, missing)
+			return err
+		}, os.ErrNotExist},
+		{"WatchDirectory", missing, func() error {
+			_, _, err := adapter.WatchDirectory(ctx, missing, func(string, string) {})
+			return err
+		}, os.ErrNotExist},
+		{"Remove", missing, func() error {
+			return adapter.Remove(ctx, missing)
+		}, os.ErrNotExist},
+		{"Chmod", missing, func() error {
+			return adapter.Chmod(ctx, missing, 0600)
+		}, os.ErrNotExist},
+		{"Size", missing, func() error {
+			_, err := adapter.Size(ctx, missing)
+			return err
+		}, os.ErrNotExist},
+		{"StatRaw", missing, func() error {
+			_, err := adapter.StatRaw(ctx, missing)
+			return err
+		}, os.ErrNotExist},
+		{"DiskUsage", missing, func() error {
+			_, err := adapter.DiskUsage(ctx, missing)
+			return err
+		}, os.ErrNotExist},
+		{"IsCaseSensitive", missing, func() error {
+			_, err := adapter.IsCaseSensitive(ctx, missing)
+			return err
+		}, os.ErrNotExist},
+		{"Readlink", missing, func() error {
+			_, err := adapter.Readlink(ctx, missing)
+			return err
+		}, os.ErrNotExist},
+		{"Link", plain, func() error {
+			return adapter.Link(ctx, missing, plain)
+		}, os.ErrNotExist},
+		{"Copy", missing, func() error {
+			return adapter.Copy(ctx, missing, plain)
+		}, os.ErrNotExist},
+		{"CopyN", missing, func() error {
+			_, err := adapter.CopyN(ctx, missing, plain, 10)
+			return err
+		}, os.ErrNotExist},
+		{"CopyTree", missing, func() error {
+			return adapter.CopyTree(ctx, missing, plain, nil)
+		}, os.ErrNotExist},
+		{"Size of a directory", sub, func() error {
+			_, err := adapter.Size(ctx, sub)
+			return err
+		}, ErrIsDirectory},
+		{"EnsureDir below a file", below, func() error {
+			_, err := adapter.EnsureDir(ctx, below)
+			return err
+		}, ErrNotDirectory},
+	}
+	var err error
+
+	err = os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "plain"), "plain")
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var err = test.call()
+
+			if !errors.Is(err, test.want) {
+				t.Fatalf("returned %v, want %v", err, test.want)
+			}
+			// The local path differs from the URL because of the BaseDir.
+			if !strings.Contains(err.Error(), test.url.String()) {
+				t.Errorf("error %q doesn't mention %s", err, test.url)
+			}
+		})
+	}
+}
+
+func TestChmod(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "script")
+	var fi os.FileInfo
+	var err error
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows only supports the read-only attribute")
+	}
+
+	writeTestFile(t, fpath, "#!/bin/sh\n")
+
+	err = DefaultAdapter().Chmod(context.Background(), fileURL(fpath), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err = os.Stat(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0700 {
+		t.Errorf("mode is %v after chmod, want %v", fi.Mode().Perm(), os.FileMode(0700))
+	}
+}
+
+func TestListEntriesDetailed(t *testing.T) {
+	var dir = testDir(t)
+	var infos []os.FileInfo
+	var fi os.FileInfo
+	var files = map[string]int64{"empty": 0, "five": 5}
+	var seen = make(map[string]bool)
+	var err error
+
+	writeTestFile(t, filepath.Join(dir, "empty"), "")
+	writeTestFile(t, filepath.Join(dir, "five"), "12345")
+	err = os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err = DefaultAdapter().ListEntriesDetailed(context.Background(), fileURL(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fi = range infos {
+		var size, isFile = files[fi.Name()]
+
+		seen[fi.Name()] = true
+		if isFile {
+			if fi.IsDir() {
+				t.Errorf("%s reported as a directory", fi.Name())
+			}
+			if fi.Size() != size {
+				t.Errorf("%s reported with %d bytes, want %d", fi.Name(), fi.Size(), size)
+			}
+		} else if fi.Name() == "sub" {
+			if !fi.IsDir() {
+				t.Error("sub not reported as a directory")
+			}
+		} else {
+			t.Errorf("unexpected entry %s", fi.Name())
+		}
+	}
+	if len(infos) != 3 || !seen["empty"] || !seen["five"] || !seen["sub"] {
+		t.Errorf("listed %d entries, want empty, five and sub", len(infos))
+	}
+}
+
+func TestExists(t *testing.T) {
+	var dir = testDir(t)
+	var exists bool
+	var err error
+
+	writeTestFile(t, filepath.Join(dir, "present"), "")
+
+	exists, err = DefaultAdapter().Exists(context.Background(), fileURL(filepath.Join(dir, "present")))
+	if err != nil || !exists {
+		t.Errorf("existing file reported as %v, %v", exists, err)
+	}
+
+	exists, err = DefaultAdapter().Exists(context.Background(), fileURL(filepath.Join(dir, "missing")))
+	if err != nil || exists {
+		t.Errorf("missing file reported as %v, %v", exists, err)
+	}
+
+	exists, err = DefaultAdapter().Exists(context.Background(), fileURL(dir))
+	if err != nil || !exists {
+		t.Errorf("directory reported as %v, %v", exists, err)
+	}
+}
+
+func TestExistsPermissionDenied(t *testing.T) {
+	var dir = filepath.Join(testDir(t), "locked")
+	var err error
+
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("directory permissions are not enforced")
+	}
+
+	err = os.Mkdir(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "hidden"), "")
+	err = os.Chmod(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0755)
+
+	_, err = DefaultAdapter().Exists(context.Background(), fileURL(filepath.Join(dir, "hidden")))
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("looking into an inaccessible directory returned %v, want %v", err, os.ErrPermission)
+	}
+}
+
+func TestReadAtWriteAtKeepOffset(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var w filesystem.WriteCloser
+	var f *ContextRespectingIoFile
+	var wg sync.WaitGroup
+	var sequential []byte
+	var buf = make([]byte, 3)
+	var pos int64
+	var i, n int
+	var err error
+
+	writeTestFile(t, fpath, "0123456789ab....")
+
+	w, err = DefaultAdapter().OpenWriterWithFlags(context.Background(), fileURL(fpath), os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = w.(*ContextRespectingIoFile)
+	defer f.Close(context.Background())
+
+	// Positional reads and writes run while the file is read sequentially.
+	for i = 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			var at = make([]byte, 4)
+			var err error
+
+			defer wg.Done()
+
+			_, err = f.WriteAt(context.Background(), []byte("cdef"), 12)
+			if err != nil {
+				t.Error(err)
+			}
+			_, err = f.ReadAt(context.Background(), at, int64(i))
+			if err != nil {
+				t.Error(err)
+			} else if string(at) != "0123456789ab"[i:i+4] {
+				t.Errorf("read %q at offset %d", at, i)
+			}
+		}(i)
+	}
+
+	for len(sequential) < 12 {
+		n, err = f.Read(context.Background(), buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sequential = append(sequential, buf[:n]...)
+	}
+	wg.Wait()
+
+	if string(sequential) != "0123456789ab" {
+		t.Errorf("read %q sequentially, want %q", sequential, "0123456789ab")
+	}
+	pos, err = f.Tell(context.Background())
+	if err != nil || pos != 12 {
+		t.Errorf("offset is %d, %v after reading 12 bytes", pos, err)
+	}
+	expectContents(t, fpath, "0123456789abcdef")
+}
+
+func TestSize(t *testing.T) {
+	var dir = testDir(t)
+	var size int64
+	var err error
+
+	writeTestFile(t, filepath.Join(dir, "eleven"), "eleven byte")
+
+	size, err = DefaultAdapter().Size(context.Background(), fileURL(filepath.Join(dir, "eleven")))
+	if err != nil || size != 11 {
+		t.Errorf("size is %d, %v, want 11", size, err)
+	}
+
+	_, err = DefaultAdapter().Size(context.Background(), fileURL(dir))
+	if !errors.Is(err, ErrIsDirectory) {
+		t.Errorf("size of a directory returned %v, want %v", err, ErrIsDirectory)
+	}
+
+	_, err = DefaultAdapter().Size(context.Background(), fileURL(filepath.Join(dir, "missing")))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("size of a missing file returned %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+/*
+openDescriptors counts the file descriptors the process has open, on systems
+which list them in /proc/self/fd or /dev/fd.
+*/
+func openDescriptors() (int, bool) {
+	var entries []os.DirEntry
+	var err error
+
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		entries, err = os.ReadDir(dir)
+		if err == nil {
+			return len(entries), true
+		}
+	}
+	return 0, false
+}
+
+func TestOpenCancelledDoesNotLeak(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var ctx, cancel = context.WithCancel(context.Background())
+	var before, after int
+	var deadline time.Time
+	var ok bool
+	var i int
+
+	writeTestFile(t, fpath, "data")
+	cancel()
+
+	// Leaked files would eventually be closed by their finalizers.
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+
+	before, ok = openDescriptors()
+	if !ok {
+		t.Skip("cannot count open file descriptors")
+	}
+
+	for i = 0; i < 100; i++ {
+		var r filesystem.ReadCloser
+		var err error
+
+		r, err = DefaultAdapter().OpenReader(ctx, fileURL(fpath))
+		if err == nil {
+			// The open may have won the race against the context.
+			r.Close(context.Background())
+		} else if err != context.Canceled {
+			t.Fatalf("opening returned %v, want %v", err, context.Canceled)
+		}
+	}
+
+	// The files which were opened anyway are closed in the background,
+	// once the opens which were given up on have finished.
+	time.Sleep(100 * time.Millisecond)
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		after, _ = openDescriptors()
+		if after <= before || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after > before {
+		t.Errorf("%d file descriptors open after cancelled opens, %d before", after, before)
+	}
+}
+
+func TestOpenWriterExclusive(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "lock")
+	var w filesystem.WriteCloser
+	var err error
+
+	w, err = DefaultAdapter().OpenWriterExclusive(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(context.Background(), []byte("first"))
+	if err != nil {
+		t.Error(err)
+	}
+	if err = w.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	_, err = DefaultAdapter().OpenWriterExclusive(context.Background(), fileURL(fpath))
+	if !errors.Is(err, os.ErrExist) {
+		t.Errorf("second exclusive open returned %v, want %v", err, os.ErrExist)
+	}
+	expectContents(t, fpath, "first")
+}
+
+/*
+expiringContext is a context whose deadline passes after Err has been
+consulted a set number of times, for stopping operations at a precise point.
+*/
+type expiringContext struct {
+	context.Context
+	checks int
+}
+
+func (c *expiringContext) Err() error {
+	if c.checks <= 0 {
+		return context.DeadlineExceeded
+	}
+	c.checks--
+	return nil
+}
+
+func TestListEntriesDeadline(t *testing.T) {
+	var dir = testDir(t)
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+	var f *os.File
+	var names []string
+	var rest []string
+	var i int
+	var err error
+
+	defer cancel()
+
+	for i = 0; i < 3*listBatchSize+10; i++ {
+		writeTestFile(t, filepath.Join(dir, fmt.Sprintf("entry%05d", i)), "")
+	}
+
+	<-ctx.Done()
+	names, err = DefaultAdapter().ListEntries(ctx, fileURL(dir))
+	if err != context.DeadlineExceeded || names != nil {
+		t.Errorf("listing after the deadline returned %d names, %v", len(names), err)
+	}
+
+	// The deadline passing in the middle stops the listing before the
+	// next batch.
+	f, err = os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	names, err = readDirNames(&expiringContext{Context: context.Background(), checks: 1}, f)
+	if err != context.DeadlineExceeded || names != nil {
+		t.Errorf("listing until the deadline returned %d names, %v", len(names), err)
+	}
+	rest, err = f.Readdirnames(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 2*listBatchSize+10 {
+		t.Errorf("%d entries left unread, want %d", len(rest), 2*listBatchSize+10)
+	}
+}
+
+func TestTellSkipCancelled(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var f *ContextRespectingIoFile
+	var ctx, cancel = context.WithCancel(context.Background())
+	var expired context.Context
+	var pos int64
+	var err error
+
+	writeTestFile(t, fpath, "0123456789")
+	f = openTestFile(t, fpath)
+
+	err = f.Skip(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	_, err = f.Tell(ctx)
+	if err != context.Canceled {
+		t.Errorf("Tell returned %v, want %v", err, context.Canceled)
+	}
+	err = f.Skip(ctx, 3)
+	if err != context.Canceled {
+		t.Errorf("Skip returned %v, want %v", err, context.Canceled)
+	}
+
+	expired, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = f.Tell(expired)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Tell returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	err = f.Skip(expired, 3)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Skip returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Neither the aborted skips nor the caller's cancellation affect the file.
+	pos, err = f.Tell(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != 3 {
+		t.Errorf("offset is %d after the aborted skips, want 3", pos)
+	}
+}
+
+func TestByteCounters(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var w filesystem.WriteCloser
+	var f *ContextRespectingIoFile
+	var buf = make([]byte, 7)
+	var err error
+
+	w, err = DefaultAdapter().OpenWriterWithFlags(context.Background(), fileURL(fpath),
+		os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = w.(*ContextRespectingIoFile)
+	defer f.Close(context.Background())
+
+	_, err = f.Write(context.Background(), []byte("0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt(context.Background(), []byte("abcde"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.BytesWritten() != 15 || f.BytesRead() != 0 {
+		t.Errorf("counted %d bytes written and %d read, want 15 and 0",
+			f.BytesWritten(), f.BytesRead())
+	}
+
+	_, err = f.Seek(context.Background(), 0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadFull(ioReader{ctx: context.Background(), r: f}, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.ReadAt(context.Background(), buf[:3], 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading past the end only counts what was actually read.
+	_, err = f.ReadAt(context.Background(), buf, 12)
+	if err != io.EOF {
+		t.Errorf("reading past the end returned %v, want io.EOF", err)
+	}
+	if f.BytesRead() != 13 || f.BytesWritten() != 15 {
+		t.Errorf("counted %d bytes read and %d written, want 13 and 15",
+			f.BytesRead(), f.BytesWritten())
+	}
+}
+
+func TestWriteRetriesShortWrites(t *testing.T) {
+	var data = bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+
+	for _, pollable := range []bool{true, false} {
+		var r, w *os.File
+		var f *ContextRespectingIoFile
+		var received = make(chan []byte, 1)
+		var n int
+		var err error
+
+		r, w, err = os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		f = NewContextRespectingIoFile(w)
+		if !pollable {
+			f.pollable = false
+		} else if !f.pollable {
+			// Pipes can't be polled on this platform.
+			r.Close()
+			w.Close()
+			continue
+		}
+
+		// The pipe only takes part of the data at a time, since it is
+		// drained in small pieces.
+		go func() {
+			var got []byte
+			var buf = make([]byte, 1000)
+			var n int
+			var err error
+
+			for err == nil {
+				n, err = r.Read(buf)
+				got = append(got, buf[:n]...)
+			}
+			r.Close()
+			received <- got
+		}()
+
+		n, err = f.Write(context.Background(), data)
+		if err != nil {
+			t.Errorf("write with pollable=%v failed: %v", pollable, err)
+		}
+		if n != len(data) {
+			t.Errorf("wrote %d bytes with pollable=%v, want %d", n, pollable, len(data))
+		}
+		err = f.Close(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		if got := <-received; !bytes.Equal(got, data) {
+			t.Errorf("received %d bytes with pollable=%v, want the %d written",
+				len(got), pollable, len(data))
+		}
+	}
+}
+
+func TestOpenAppenderWithSize(t *testing.T) {
+	var dir = testDir(t)
+	var fpath = filepath.Join(dir, "log")
+	var w filesystem.WriteCloser
+	var size int64
+	var err error
+
+	writeTestFile(t, fpath, "0123456789")
+
+	w, size, err = DefaultAdapter().OpenAppenderWithSize(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 10 {
+		t.Errorf("reported size %d, want 10", size)
+	}
+	_, err = w.Write(context.Background(), []byte("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectContents(t, fpath, "0123456789abc")
+
+	// New files start out empty.
+	w, size, err = DefaultAdapter().OpenAppenderWithSize(context.Background(),
+		fileURL(filepath.Join(dir, "new")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close(context.Background())
+	if size != 0 {
+		t.Errorf("reported size %d for a new file, want 0", size)
+	}
+}
+
+func TestListEntriesNotDirectory(t *testing.T) {
+	var dir = testDir(t)
+	var err error
+
+	writeTestFile(t, filepath.Join(dir, "plain"), "plain")
+
+	_, err = DefaultAdapter().ListEntries(context.Background(), fileURL(filepath.Join(dir, "plain")))
+	if !errors.Is(err, ErrNotDirectory) {
+		t.Errorf("listing a regular file returned %v, want %v", err, ErrNotDirectory)
+	}
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		t.Errorf("listing a regular file returned %v, which looks like a different problem", err)
+	}
+
+	_, err = DefaultAdapter().ListEntries(context.Background(), fileURL(filepath.Join(dir, "missing")))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("listing a missing directory returned %v, want %v", err, os.ErrNotExist)
+	}
+	if errors.Is(err, ErrNotDirectory) {
+		t.Errorf("listing a missing directory returned %v, which claims it's not a directory", err)
+	}
+}
+
+func TestOpenWriterWithFlags(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var w filesystem.WriteCloser
+	var err error
+
+	w, err = DefaultAdapter().OpenWriterWithFlags(context.Background(), fileURL(fpath), os.O_EXCL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(context.Background(), []byte("first"))
+	if err != nil {
+		t.Error(err)
+	}
+	if err = w.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	_, err = DefaultAdapter().OpenWriterWithFlags(context.Background(), fileURL(fpath), os.O_EXCL)
+	if !errors.Is(err, os.ErrExist) {
+		t.Errorf("exclusive open of an existing file returned %v, want %v", err, os.ErrExist)
+	}
+	expectContents(t, fpath, "first")
+
+	// Without O_TRUNC, the existing contents are overwritten in place.
+	w, err = DefaultAdapter().OpenWriterWithFlags(context.Background(), fileURL(fpath), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(context.Background(), []byte("F"))
+	if err != nil {
+		t.Error(err)
+	}
+	if err = w.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+	expectContents(t, fpath, "First")
+}
+
+func TestReadEOFCancelledAndEmpty(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var f *ContextRespectingIoFile
+	var ctx, cancel = context.WithCancel(context.Background())
+	var buf = make([]byte, 10)
+	var n int
+	var err error
+
+	writeTestFile(t, fpath, "abc")
+	f = openTestFile(t, fpath)
+
+	n, err = f.Read(context.Background(), buf)
+	if n != 3 || err != nil {
+		t.Errorf("first read returned %d, %v, want 3, nil", n, err)
+	}
+	n, err = f.Read(context.Background(), buf)
+	if n != 0 || err != io.EOF {
+		t.Errorf("read at the end returned %d, %v, want 0, io.EOF", n, err)
+	}
+	if IsCancelled(err) {
+		t.Errorf("%v taken for a cancellation", err)
+	}
+
+	// An empty pipe stalls reads until they are cancelled, whether they
+	// use I/O deadlines or not.
+	for _, pollable := range []bool{true, false} {
+		var r, w *os.File
+
+		r, w, err = os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		f = NewContextRespectingIoFile(r)
+		if !pollable {
+			f.pollable = false
+		}
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+		}()
+		n, err = f.Read(ctx, buf)
+		if n != 0 || err != context.Canceled {
+			t.Errorf("cancelled read with pollable=%v returned %d, %v, want 0, %v",
+				pollable, n, err, context.Canceled)
+		}
+		if !IsCancelled(err) || err == io.EOF {
+			t.Errorf("%v not recognized as a cancellation", err)
+		}
+
+		// Empty reads don't even get that far.
+		n, err = f.Read(ctx, nil)
+		if n != 0 || err != nil {
+			t.Errorf("empty read returned %d, %v, want 0, nil", n, err)
+		}
+
+		w.Close()
+		f.Close(context.Background())
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	cancel()
+}
+
+func TestRegisterReplacesDefault(t *testing.T) {
+	var dir = testDir(t)
+	var original = DefaultAdapter()
+	var backend = newFakeWatchBackend()
+	var custom = backend.adapter()
+	var contents = newWatchedContents(t)
+	var watcher *FileWatcher
+	var r filesystem.ReadCloser
+	var err error
+
+	custom.BaseDir = dir
+	writeTestFile(t, filepath.Join(dir, "config"), "rooted")
+
+	Register("file", custom)
+	t.Cleanup(func() {
+		Register("file", original)
+	})
+
+	if DefaultAdapter() != custom {
+		t.Fatal("the registered adapter is not the default one")
+	}
+	r, err = DefaultAdapter().OpenReader(context.Background(), &url.URL{Scheme: "file", Path: "/config"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAndClose(t, r); got != "rooted" {
+		t.Errorf("read %q through the default adapter, want %q", got, "rooted")
+	}
+
+	// Watchers not given an adapter use the registered one, too.
+	watcher, err = NewFileWatcher(context.Background(), &url.URL{Scheme: "file", Path: "/config"},
+		contents.notify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Shutdown()
+	contents.expect("rooted")
+	if !backend.isWatched(filepath.Join(dir, "config")) {
+		t.Error("the watcher doesn't use the registered adapter")
+	}
+}
+
+func TestOpenReaderNoFollow(t *testing.T) {
+	var dir = testDir(t)
+	var r filesystem.ReadCloser
+	var err error
+
+	writeTestFile(t, filepath.Join(dir, "target"), "target")
+	symlinkOrSkip(t, "target", filepath.Join(dir, "link"))
+	symlinkOrSkip(t, ".", filepath.Join(dir, "dirlink"))
+
+	r, err = DefaultAdapter().OpenReader(context.Background(), fileURL(filepath.Join(dir, "link")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAndClose(t, r); got != "target" {
+		t.Errorf("read %q through the link, want %q", got, "target")
+	}
+
+	// The error differs between systems (ELOOP on most, EMLINK on FreeBSD).
+	_, err = DefaultAdapter().OpenReaderNoFollow(context.Background(), fileURL(filepath.Join(dir, "link")))
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Errorf("opening the link without following it returned %v", err)
+	}
+
+	// Only the last component of the path is checked.
+	r, err = DefaultAdapter().OpenReaderNoFollow(context.Background(),
+		fileURL(filepath.Join(dir, "dirlink", "target")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAndClose(t, r); got != "target" {
+		t.Errorf("read %q without following links, want %q", got, "target")
+	}
+}
+
+func TestOpenDirectory(t *testing.T) {
+	var dir = testDir(t)
+	var err error
+
+	_, err = DefaultAdapter().OpenReader(context.Background(), fileURL(dir))
+	if !errors.Is(err, ErrIsDirectory) {
+		t.Errorf("opening a directory for reading returned %v, want %v", err, ErrIsDirectory)
+	}
+	_, err = DefaultAdapter().OpenWriter(context.Background(), fileURL(dir))
+	if !errors.Is(err, ErrIsDirectory) {
+		t.Errorf("opening a directory for writing returned %v, want %v", err, ErrIsDirectory)
+	}
+	_, err = DefaultAdapter().OpenAppender(context.Background(), fileURL(dir))
+	if !errors.Is(err, ErrIsDirectory) {
+		t.Errorf("opening a directory for appending returned %v, want %v", err, ErrIsDirectory)
+	}
+}
+
+func TestOpenWriterBelowFile(t *testing.T) {
+	var dir = testDir(t)
+	var perr *os.PathError
+	var err error
+
+	writeTestFile(t, filepath.Join(dir, "a"), "a")
+
+	for _, fpath := range []string{
+		filepath.Join(dir, "a", "b"),
+		filepath.Join(dir, "a", "b", "c"),
+	} {
+		_, err = DefaultAdapter().OpenWriter(context.Background(), fileURL(fpath))
+		if !errors.Is(err, ErrNotDirectory) {
+			t.Errorf("writing %s returned %v, want %v", fpath, err, ErrNotDirectory)
+			continue
+		}
+
+		// The error names the component which is in the way.
+		if !errors.As(err, &perr) || perr.Path != filepath.Join(dir, "a") {
+			t.Errorf("writing %s returned %v, which doesn't name %s",
+				fpath, err, filepath.Join(dir, "a"))
+		}
+	}
+	expectContents(t, filepath.Join(dir, "a"), "a")
+}
+
+func TestSizeFromFd(t *testing.T) {
+	var dir = testDir(t)
+	var fpath = filepath.Join(dir, "data")
+	var f *ContextRespectingIoFile
+	var size int64
+	var err error
+
+	writeTestFile(t, fpath, "0123456789")
+	f = openTestFile(t, fpath)
+
+	size, err = f.SizeFromFd(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 10 {
+		t.Errorf("open file has %d bytes, want 10", size)
+	}
+
+	// Replacing the file at the path doesn't affect the open one.
+	writeTestFile(t, filepath.Join(dir, "new"), "new")
+	err = os.Rename(filepath.Join(dir, "new"), fpath)
+	if err != nil && runtime.GOOS == "windows" {
+		t.Skipf("cannot replace open files: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err = f.SizeFromFd(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 10 {
+		t.Errorf("open file has %d bytes after being replaced, want 10", size)
+	}
+}
+
+func TestCountEntries(t *testing.T) {
+	var dir = testDir(t)
+	var count int
+	var i int
+	var err error
+
+	// Spread the entries over more than one batch.
+	for i = 0; i < listBatchSize+5; i++ {
+		writeTestFile(t, filepath.Join(dir, fmt.Sprintf("file%04d", i)), "")
+	}
+	err = os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "sub", "nested"), "")
+
+	count, err = DefaultAdapter().CountEntries(context.Background(), fileURL(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != listBatchSize+6 {
+		t.Errorf("counted %d entries, want %d", count, listBatchSize+6)
+	}
+
+	_, err = DefaultAdapter().CountEntries(context.Background(), fileURL(filepath.Join(dir, "file0000")))
+	if !errors.Is(err, ErrNotDirectory) {
+		t.Errorf("counting a regular file returned %v, want %v", err, ErrNotDirectory)
+	}
+}
+
+func TestRemoveMissing(t *testing.T) {
+	var dir = testDir(t)
+	var err error
+
+	err = DefaultAdapter().Remove(context.Background(), fileURL(filepath.Join(dir, "missing")))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("removing a missing file returned %v, want %v", err, os.ErrNotExist)
+	}
+	if errors.Is(err, os.ErrPermission) {
+		t.Errorf("removing a missing file returned %v, which claims a permission problem", err)
+	}
+
+	err = DefaultAdapter().RemoveIfExists(context.Background(), fileURL(filepath.Join(dir, "missing")))
+	if err != nil {
+		t.Errorf("removing a missing file if it exists returned %v", err)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "present"), "")
+	err = DefaultAdapter().RemoveIfExists(context.Background(), fileURL(filepath.Join(dir, "present")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(filepath.Join(dir, "present"))
+	if !os.IsNotExist(err) {
+		t.Errorf("file is still there after removing it: %v", err)
+	}
+}
+
+func TestRemovePermissionDenied(t *testing.T) {
+	var dir = filepath.Join(testDir(t), "locked")
+	var err error
+
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("directory permissions are not enforced")
+	}
+
+	err = os.Mkdir(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "kept"), "")
+	err = os.Chmod(dir, 0555)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0755)
+
+	// Missing permissions are still reported when a missing file would be fine.
+	err = DefaultAdapter().RemoveIfExists(context.Background(), fileURL(filepath.Join(dir, "kept")))
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("removing from a read-only directory returned %v, want %v", err, os.ErrPermission)
+	}
+}
+
+func TestListEntriesSorted(t *testing.T) {
+	var dir = testDir(t)
+	var base = time.Now().Add(-time.Hour)
+	var names []string
+	var err error
+
+	// Created neither in name nor in modification time order, with some
+	// entries modified at the same time.
+	for _, entry := range []struct {
+		name    string
+		minutes int
+	}{{"b", 2}, {"C", 0}, {"a", 1}, {"c", 2}, {"B", 1}} {
+		var mtime = base.Add(time.Duration(entry.minutes) * time.Minute)
+
+		writeTestFile(t, filepath.Join(dir, entry.name), entry.name)
+		err = os.Chtimes(filepath.Join(dir, entry.name), mtime, mtime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names, err = DefaultAdapter().ListEntriesSorted(context.Background(), fileURL(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, " ") != "B C a b c" {
+		t.Errorf("listed %v, want them sorted by name", names)
+	}
+
+	names, err = DefaultAdapter().ListEntriesByModTime(context.Background(), fileURL(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, " ") != "C B a b c" {
+		t.Errorf("listed %v, want them sorted by modification time", names)
+	}
+}
+
+func TestWriteString(t *testing.T) {
+	var dir = testDir(t)
+	var chunks = []string{"plain", "", "ünïcödé\n", "\xff\xfe invalid UTF-8", strings.Repeat("x", 100000)}
+	var viaWrite, viaString *ContextRespectingIoFile
+	var want, got []byte
+	var err error
+
+	viaWrite, err = DefaultAdapter().openForWriting(context.Background(),
+		fileURL(filepath.Join(dir, "write")), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	viaString, err = DefaultAdapter().openForWriting(context.Background(),
+		fileURL(filepath.Join(dir, "string")), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, chunk := range chunks {
+		var n, ns int
+		var errs error
+
+		n, err = viaWrite.Write(context.Background(), []byte(chunk))
+		ns, errs = viaString.WriteString(context.Background(), chunk)
+		if err != nil || errs != nil {
+			t.Fatalf("writing %.10q: %v, %v", chunk, err, errs)
+		}
+		if ns != n {
+			t.Errorf("WriteString of %.10q wrote %d bytes, Write %d", chunk, ns, n)
+		}
+	}
+
+	err = combineErrors(viaWrite.Close(context.Background()),
+		viaString.Close(context.Background()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err = os.ReadFile(filepath.Join(dir, "write"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = os.ReadFile(filepath.Join(dir, "string"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("WriteString wrote different data than Write")
+	}
+	if string(want) != strings.Join(chunks, "") {
+		t.Error("Write didn't write the data as given")
+	}
+}
+
+func TestOpenWriterNoMkdir(t *testing.T) {
+	var dir = testDir(t)
+	var w filesystem.WriteCloser
+	var err error
+
+	_, err = DefaultAdapter().OpenWriterNoMkdir(context.Background(),
+		fileURL(filepath.Join(dir, "typo", "file")))
+	if !errors.Is(err, ErrNoParentDirectory) {
+		t.Errorf("opening in a missing directory returned %v, want %v", err, ErrNoParentDirectory)
+	}
+	_, err = os.Stat(filepath.Join(dir, "typo"))
+	if !os.IsNotExist(err) {
+		t.Errorf("missing directory was created: %v", err)
+	}
+
+	// Existing directories are fine.
+	w, err = DefaultAdapter().OpenWriterNoMkdir(context.Background(), fileURL(filepath.Join(dir, "file")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(context.Background(), []byte("data"))
+	if err == nil {
+		err = w.Close(context.Background())
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectContents(t, filepath.Join(dir, "file"), "data")
+
+	// OpenWriter creates the directories instead.
+	w, err = DefaultAdapter().OpenWriter(context.Background(), fileURL(filepath.Join(dir, "typo", "file")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectContents(t, filepath.Join(dir, "typo", "file"), "")
+}
+
+func TestLargeBuffers(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var data = bytes.Repeat([]byte("0123456789abcdef"), (3*maxWriteChunk+7)/16+1)
+	var w *ContextRespectingIoFile
+	var r *ContextRespectingIoFile
+	var before, after runtime.MemStats
+	var buf []byte
+	var n int
+	var err error
+
+	if strconv.IntSize < 64 {
+		t.Skip("no address space for large buffers")
+	}
+
+	// Writes are split into several chunks, but written in full.
+	w, err = DefaultAdapter().openForWriting(context.Background(), fileURL(fpath),
+		os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = w.Write(context.Background(), data)
+	if err == nil {
+		err = w.Close(context.Background())
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) {
+		t.Errorf("wrote %d bytes, want %d", n, len(data))
+	}
+
+	// A buffer which is much too large only gets filled up to the chunk size,
+	// without allocating as much for the read.
+	r = openTestFile(t, fpath)
+	buf = make([]byte, 256<<20)
+	runtime.ReadMemStats(&before)
+	n, err = r.Read(context.Background(), buf)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.TotalAlloc-before.TotalAlloc > uint64(len(buf)/2) {
+		t.Errorf("allocated %d bytes for reading into a buffer of %d",
+			after.TotalAlloc-before.TotalAlloc, len(buf))
+	}
+	if n != maxReadChunk {
+		t.Errorf("read %d bytes into a huge buffer, want %d", n, maxReadChunk)
+	}
+	if !bytes.Equal(buf[:n], data[:n]) {
+		t.Error("read different data than was written")
+	}
+
+	n, err = r.ReadAt(context.Background(), buf[:len(data)], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) || !bytes.Equal(buf[:n], data) {
+		t.Errorf("read %d bytes at once, want all %d as written", n, len(data))
+	}
+}
+
+/*
+seekableReader is implemented by all readers which support seeking.
+*/
+type seekableReader interface {
+	Read(ctx context.Context, p []byte) (int, error)
+	Seek(ctx context.Context, offset int64, whence int) (int64, error)
+	Tell(ctx context.Context) (int64, error)
+}
+
+func TestSeekBounds(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var mapped filesystem.ReadCloser
+	var err error
+
+	writeTestFile(t, fpath, "0123456789")
+
+	t.Run("plain", func(t *testing.T) {
+		checkSeekBounds(t, openTestFile(t, fpath))
+	})
+
+	mapped, err = DefaultAdapter().OpenMappedReader(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapped.Close(context.Background())
+	t.Run("mapped", func(t *testing.T) {
+		checkSeekBounds(t, mapped.(seekableReader))
+	})
+}
+
+/*
+checkSeekBounds verifies that f, which must hold the 10 bytes "0123456789",
+rejects seeks to negative offsets and allows seeks past the end.
+*/
+func checkSeekBounds(t *testing.T, f seekableReader) {
+	var pos int64
+	var err error
+
+	_, err = f.Seek(context.Background(), 5, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		offset int64
+		whence int
+	}{{-1, io.SeekStart}, {-6, io.SeekCurrent}, {-11, io.SeekEnd}} {
+		_, err = f.Seek(context.Background(), test.offset, test.whence)
+		if !errors.Is(err, ErrNegativeOffset) {
+			t.Errorf("seeking to %d from %d returned %v, want %v",
+				test.offset, test.whence, err, ErrNegativeOffset)
+		}
+	}
+	_, err = f.Seek(context.Background(), 0, 3)
+	if !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("seeking with an invalid whence returned %v, want %v", err, os.ErrInvalid)
+	}
+
+	// Failed seeks leave the offset alone.
+	pos, err = f.Tell(context.Background())
+	if err != nil || pos != 5 {
+		t.Errorf("offset is %d, %v after failed seeks, want 5", pos, err)
+	}
+
+	pos, err = f.Seek(context.Background(), 10, io.SeekEnd)
+	if err != nil || pos != 20 {
+		t.Fatalf("seeking past the end returned %d, %v, want 20", pos, err)
+	}
+	_, err = f.Read(context.Background(), make([]byte, 10))
+	if err != io.EOF {
+		t.Errorf("reading past the end returned %v, want io.EOF", err)
+	}
+}
+
+func TestSeekPastEndAndWrite(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var f *ContextRespectingIoFile
+	var err error
+
+	f, err = DefaultAdapter().openForWriting(context.Background(), fileURL(fpath),
+		os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write(context.Background(), []byte("head"))
+	if err == nil {
+		_, err = f.Seek(context.Background(), 4, io.SeekCurrent)
+	}
+	if err == nil {
+		_, err = f.Write(context.Background(), []byte("tail"))
+	}
+	if err == nil {
+		err = f.Close(context.Background())
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The hole reads back as zeroes.
+	expectContents(t, fpath, "head\x00\x00\x00\x00tail")
+}
@@ -0,0 +1,395 @@
+//go:build unix
+
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"golang.org/x/sys/unix"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+/*
+makeFIFO creates a named pipe at fpath.
+*/
+func makeFIFO(t *testing.T, fpath string) {
+	var err error
+
+	err = unix.Mkfifo(fpath, 0600)
+	if err != nil {
+		t.Skipf("cannot create named pipes: %v", err)
+	}
+}
+
+func TestChown(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "owned")
+	var fi os.FileInfo
+	var st *syscall.Stat_t
+	var err error
+
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner of files requires root privileges")
+	}
+
+	writeTestFile(t, fpath, "owned")
+
+	err = DefaultAdapter().Chown(context.Background(), fileURL(fpath), 1234, 5678)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err = os.Stat(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st = fi.Sys().(*syscall.Stat_t)
+	if st.Uid != 1234 || st.Gid != 5678 {
+		t.Errorf("owned by %d:%d after chown, want 1234:5678", st.Uid, st.Gid)
+	}
+
+	// -1 leaves the respective value alone.
+	err = DefaultAdapter().Chown(context.Background(), fileURL(fpath), -1, 4321)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err = os.Stat(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st = fi.Sys().(*syscall.Stat_t)
+	if st.Uid != 1234 || st.Gid != 4321 {
+		t.Errorf("owned by %d:%d after changing the group, want 1234:4321", st.Uid, st.Gid)
+	}
+}
+
+func TestOperationTimeoutStalledOpen(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "fifo")
+	var adapter = &FileAdapter{OperationTimeout: 50 * time.Millisecond}
+	var w *os.File
+	var start time.Time
+	var err error
+
+	makeFIFO(t, fpath)
+
+	// Opening a FIFO blocks until there is a writer, which never comes.
+	for _, open := range []func(context.Context, *url.URL) (filesystem.ReadCloser, error){
+		adapter.OpenReader, adapter.OpenMappedReader,
+	} {
+		start = time.Now()
+		_, err = open(context.Background(), fileURL(fpath))
+		if !IsTimeout(err) {
+			t.Errorf("opening a FIFO without a writer returned %v, want a timeout", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("timeout took %v to fire", elapsed)
+		}
+	}
+
+	// Let the opens which were given up on finish.
+	w, err = os.OpenFile(fpath, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+}
+
+func TestOperationTimeoutStalledRead(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "fifo")
+	var writer = make(chan *os.File, 1)
+	var r filesystem.ReadCloser
+	var w *os.File
+	var err error
+
+	makeFIFO(t, fpath)
+
+	go func() {
+		var w *os.File
+
+		w, _ = os.OpenFile(fpath, os.O_WRONLY, 0)
+		writer <- w
+	}()
+
+	r, err = DefaultAdapter().OpenReader(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close(context.Background())
+
+	w = <-writer
+	if w == nil {
+		t.Fatal("cannot open the FIFO for writing")
+	}
+	defer w.Close()
+
+	r.(*ContextRespectingIoFile).SetOperationTimeout(50 * time.Millisecond)
+
+	// The writer never writes anything.
+	_, err = r.Read(context.Background(), make([]byte, 10))
+	if !IsTimeout(err) {
+		t.Errorf("reading from an idle FIFO returned %v, want a timeout", err)
+	}
+}
+
+func TestExactFileMode(t *testing.T) {
+	var dir = testDir(t)
+	var w filesystem.WriteCloser
+	var fi os.FileInfo
+	var err error
+
+	defer syscall.Umask(syscall.Umask(077))
+
+	for _, test := range []struct {
+		name    string
+		adapter *FileAdapter
+		want    os.FileMode
+	}{
+		{"masked", &FileAdapter{FileMode: 0644}, 0600},
+		{"exact", &FileAdapter{FileMode: 0644, ExactFileMode: true}, 0644},
+	} {
+		w, err = test.adapter.OpenWriter(context.Background(), fileURL(filepath.Join(dir, test.name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Close(context.Background()); err != nil {
+			t.Error(err)
+		}
+
+		fi, err = os.Stat(filepath.Join(dir, test.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != test.want {
+			t.Errorf("%s file has mode %v, want %v", test.name, fi.Mode().Perm(), test.want)
+		}
+	}
+
+	// Files which already exist keep their mode.
+	w, err = (&FileAdapter{FileMode: 0644, ExactFileMode: true}).OpenWriter(
+		context.Background(), fileURL(filepath.Join(dir, "masked")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+	fi, err = os.Stat(filepath.Join(dir, "masked"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("existing file changed to mode %v", fi.Mode().Perm())
+	}
+}
+
+func TestOpenReaderNonBlockingFIFO(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "fifo")
+	var ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	var r filesystem.ReadCloser
+	var w *os.File
+	var buf = make([]byte, 10)
+	var n int
+	var err error
+
+	defer cancel()
+	makeFIFO(t, fpath)
+
+	// Without a writer, the open doesn't wait for one to connect.
+	r, err = DefaultAdapter().OpenReaderNonBlocking(ctx, fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close(context.Background())
+
+	_, err = r.Read(ctx, buf)
+	if err != io.EOF {
+		t.Errorf("reading without a writer returned %v, want io.EOF", err)
+	}
+
+	w, err = os.OpenFile(fpath, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// An idle writer makes the read wait until the deadline.
+	_, err = r.Read(ctx, buf)
+	if err != context.DeadlineExceeded {
+		t.Errorf("reading from an idle writer returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	_, err = w.Write([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = r.Read(context.Background(), buf)
+	if err != nil || string(buf[:n]) != "data" {
+		t.Errorf("read %q, %v, want %q", buf[:n], err, "data")
+	}
+}
+
+func TestStatRaw(t *testing.T) {
+	var dir = testDir(t)
+	var fpath = filepath.Join(dir, "data")
+	var st, linked *RawStat
+	var err error
+
+	writeTestFile(t, fpath, "0123456789")
+	err = os.Link(fpath, filepath.Join(dir, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err = DefaultAdapter().StatRaw(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Ino == 0 {
+		t.Error("inode number not populated")
+	}
+	if st.Nlink != 2 {
+		t.Errorf("file has %d links, want 2", st.Nlink)
+	}
+	if st.Size != 10 {
+		t.Errorf("file has %d bytes, want 10", st.Size)
+	}
+
+	linked, err = DefaultAdapter().StatRaw(context.Background(), fileURL(filepath.Join(dir, "link")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked.Ino != st.Ino || linked.Dev != st.Dev {
+		t.Errorf("hard link has inode %d on device %d, want %d on %d",
+			linked.Ino, linked.Dev, st.Ino, st.Dev)
+	}
+}
+
+/*
+nonblockingPipe creates a pipe whose ends are switched to non-blocking mode
+behind the back of the runtime, so that reads and writes which cannot proceed
+fail with EAGAIN instead of being waited for by the poller.
+*/
+func nonblockingPipe(t *testing.T) (*ContextRespectingIoFile, *ContextRespectingIoFile) {
+	var fds [2]int
+	var r, w *ContextRespectingIoFile
+	var err error
+
+	err = unix.Pipe(fds[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = NewContextRespectingIoFile(os.NewFile(uintptr(fds[0]), "read end"))
+	w = NewContextRespectingIoFile(os.NewFile(uintptr(fds[1]), "write end"))
+	t.Cleanup(func() {
+		r.Close(context.Background())
+		w.Close(context.Background())
+	})
+
+	if r.pollable || w.pollable {
+		t.Skip("blocking pipes are handled by the poller")
+	}
+	err = unix.SetNonblock(fds[0], true)
+	if err == nil {
+		err = unix.SetNonblock(fds[1], true)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, w
+}
+
+func TestReadWouldBlock(t *testing.T) {
+	var r, w = nonblockingPipe(t)
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	var buf = make([]byte, 10)
+	var n int
+	var err error
+
+	defer cancel()
+
+	_, err = r.Read(ctx, buf)
+	if err != context.DeadlineExceeded {
+		t.Errorf("reading from an empty pipe returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		w.Write(context.Background(), []byte("data"))
+	}()
+
+	n, err = r.Read(context.Background(), buf)
+	if err != nil || string(buf[:n]) != "data" {
+		t.Errorf("read %q, %v, want %q", buf[:n], err, "data")
+	}
+}
+
+func TestWriteWouldBlock(t *testing.T) {
+	var r, w = nonblockingPipe(t)
+	var data = make([]byte, 1<<20)
+	var received = make(chan int, 1)
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	var n, pending int
+	var err error
+
+	defer cancel()
+
+	// Nobody reads, so the pipe fills up.
+	n, err = w.Write(ctx, data)
+	if err != context.DeadlineExceeded {
+		t.Errorf("writing to a full pipe returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n <= 0 || n >= len(data) {
+		t.Errorf("wrote %d bytes into the pipe before it filled up", n)
+	}
+
+	// The reader gets what was left in the pipe along with the new data.
+	pending = n + len(data)
+	go func() {
+		var buf = make([]byte, 64*1024)
+		var total int
+
+		for total < pending {
+			var m, err = r.Read(context.Background(), buf)
+			if err != nil {
+				break
+			}
+			total += m
+		}
+		received <- total
+	}()
+
+	n, err = w.Write(context.Background(), data)
+	if err != nil || n != len(data) {
+		t.Errorf("wrote %d bytes and %v while the pipe was drained, want all %d",
+			n, err, len(data))
+	}
+	select {
+	case n = <-received:
+		if n != pending {
+			t.Errorf("reader got %d bytes, want %d", n, pending)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("reader didn't get all the data")
+	}
+}
+
+func TestSecureRemoveFIFO(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "fifo")
+	var err error
+
+	makeFIFO(t, fpath)
+
+	// Opening the FIFO for writing would block until there is a reader.
+	err = DefaultAdapter().SecureRemove(context.Background(), fileURL(fpath), 1)
+	if !errors.Is(err, ErrUnsupportedFileType) {
+		t.Errorf("removing a FIFO returned %v, want %v", err, ErrUnsupportedFileType)
+	}
+}
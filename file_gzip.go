@@ -0,0 +1,123 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"compress/gzip"
+	"golang.org/x/net/context"
+	"net/url"
+)
+
+/*
+GzipWriter compresses all data written to it before passing it on to the
+underlying file.
+*/
+type GzipWriter struct {
+	file filesystem.WriteCloser
+	cw   *contextWriter
+	gz   *gzip.Writer
+}
+
+/*
+Write() compresses the data and writes the compressed bytes to the file as
+the compressor produces them.
+*/
+func (w *GzipWriter) Write(ctx context.Context, p []byte) (int, error) {
+	w.cw.ctx = ctx
+	return w.gz.Write(p)
+}
+
+/*
+Close() flushes the compressor, writes the gzip trailer and closes the
+underlying file. The file is closed even if finishing the gzip stream fails;
+if both fail, both errors are reported, combined using errors.Join.
+*/
+func (w *GzipWriter) Close(ctx context.Context) error {
+	var err error
+
+	w.cw.ctx = ctx
+	err = w.gz.Close()
+	return combineErrors(err, w.file.Close(ctx))
+}
+
+/*
+GzipReader decompresses the contents of a gzip compressed file while it is
+being read. Data is decompressed as it is read rather than all at once.
+*/
+type GzipReader struct {
+	file filesystem.ReadCloser
+	cr   *contextReader
+	gz   *gzip.Reader
+}
+
+/*
+Read() reads decompressed data.
+*/
+func (r *GzipReader) Read(ctx context.Context, p []byte) (int, error) {
+	r.cr.ctx = ctx
+	return r.gz.Read(p)
+}
+
+/*
+Close() closes both the decompressor and the underlying file.
+*/
+func (r *GzipReader) Close(ctx context.Context) error {
+	var err error
+
+	err = r.gz.Close()
+	return combineErrors(err, r.file.Close(ctx))
+}
+
+/*
+OpenWriterGzip works like OpenWriter, but all data written is compressed
+using gzip.
+*/
+func (file *FileAdapter) OpenWriterGzip(
+	ctx context.Context, fileurl *url.URL) (filesystem.WriteCloser, error) {
+	var wc filesystem.WriteCloser
+	var cw *contextWriter
+	var err error
+
+	wc, err = file.OpenWriter(ctx, fileurl)
+	if err != nil {
+		return nil, err
+	}
+
+	cw = &contextWriter{ctx: ctx, w: wc}
+	return &GzipWriter{
+		file: wc,
+		cw:   cw,
+		gz:   gzip.NewWriter(cw),
+	}, nil
+}
+
+/*
+OpenReaderGzip works like OpenReader, but the file is expected to be gzip
+compressed and the data read from it is decompressed. The gzip header is
+read right away, so opening fails if the file isn't in gzip format.
+*/
+func (file *FileAdapter) OpenReaderGzip(
+	ctx context.Context, fileurl *url.URL) (filesystem.ReadCloser, error) {
+	var rc filesystem.ReadCloser
+	var cr *contextReader
+	var gz *gzip.Reader
+	var err error
+
+	rc, err = file.OpenReader(ctx, fileurl)
+	if err != nil {
+		return nil, err
+	}
+
+	cr = &contextReader{ctx: ctx, r: rc}
+	gz, err = gzip.NewReader(cr)
+	if err != nil {
+		rc.Close(ctx)
+		return nil, urlError("read", fileurl, err)
+	}
+
+	return &GzipReader{
+		file: rc,
+		cr:   cr,
+		gz:   gz,
+	}, nil
+}
@@ -0,0 +1,77 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"golang.org/x/net/context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGzipRoundTrip(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data.gz")
+	var text = bytes.Repeat([]byte("compress me, please\n"), 1000)
+	var w filesystem.WriteCloser
+	var r filesystem.ReadCloser
+	var gz *gzip.Reader
+	var raw, plain []byte
+	var err error
+
+	w, err = DefaultAdapter().OpenWriterGzip(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(context.Background(), text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// What ends up on disk is a regular gzip stream.
+	raw, err = os.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) >= len(text) {
+		t.Errorf("%d bytes compressed to %d", len(text), len(raw))
+	}
+	gz, err = gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err = io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, text) {
+		t.Error("gzip stream on disk doesn't decompress to the data written")
+	}
+
+	r, err = DefaultAdapter().OpenReaderGzip(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAndClose(t, r); got != string(text) {
+		t.Errorf("read back %d bytes which differ from the %d written", len(got), len(text))
+	}
+}
+
+func TestGzipReaderRejectsPlainFile(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "plain")
+	var err error
+
+	writeTestFile(t, fpath, "not compressed at all")
+
+	_, err = DefaultAdapter().OpenReaderGzip(context.Background(), fileURL(fpath))
+	if !errors.Is(err, gzip.ErrHeader) {
+		t.Errorf("opening an uncompressed file returned %v, want %v", err, gzip.ErrHeader)
+	}
+}
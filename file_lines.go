@@ -0,0 +1,94 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"bufio"
+	"golang.org/x/net/context"
+	"io"
+	"net/url"
+)
+
+/*
+maxLineLength is the length of the longest line a LineReader accepts. Longer
+lines make NextLine fail with bufio.ErrTooLong.
+*/
+const maxLineLength = 64 << 20
+
+/*
+LineReader yields the lines of a text file one at a time, without ever
+holding the entire file in memory.
+*/
+type LineReader interface {
+	// NextLine returns the next line without its line ending, or io.EOF
+	// once all lines have been returned.
+	NextLine(ctx context.Context) (string, error)
+
+	// Close closes the underlying file.
+	Close(ctx context.Context) error
+}
+
+/*
+lineReader implements LineReader on top of a bufio.Scanner.
+*/
+type lineReader struct {
+	file    filesystem.ReadCloser
+	cr      *contextReader
+	scanner *bufio.Scanner
+}
+
+/*
+NextLine reads the next line. Lines may end in "\n" or "\r\n", and the last
+line doesn't need a line ending at all. The context is checked before
+reading and used for all reads from the file needed for the line. Once a
+read has failed, e.g. because the context was cancelled in the middle of it,
+the error is returned from all further calls.
+*/
+func (l *lineReader) NextLine(ctx context.Context) (string, error) {
+	var err error
+
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	l.cr.ctx = ctx
+	if l.scanner.Scan() {
+		return l.scanner.Text(), nil
+	}
+
+	err = l.scanner.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	return "", err
+}
+
+/*
+Close closes the underlying file.
+*/
+func (l *lineReader) Close(ctx context.Context) error {
+	return l.file.Close(ctx)
+}
+
+/*
+OpenLineReader opens the file pointed to for reading it line by line. Lines
+can be up to 64 MiB long.
+*/
+func (file *FileAdapter) OpenLineReader(ctx context.Context, fileurl *url.URL) (LineReader, error) {
+	var rc filesystem.ReadCloser
+	var l *lineReader
+	var err error
+
+	rc, err = file.OpenReader(ctx, fileurl)
+	if err != nil {
+		return nil, err
+	}
+
+	l = &lineReader{
+		file: rc,
+		cr:   &contextReader{ctx: ctx, r: rc},
+	}
+	l.scanner = bufio.NewScanner(l.cr)
+	l.scanner.Buffer(nil, maxLineLength)
+	return l, nil
+}
@@ -0,0 +1,93 @@
+package file
+
+import (
+	"bufio"
+	"golang.org/x/net/context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+readLines reads all lines of the file fpath through a LineReader.
+*/
+func readLines(t *testing.T, fpath string) []string {
+	var l LineReader
+	var lines []string
+	var line string
+	var err error
+
+	t.Helper()
+
+	l, err = DefaultAdapter().OpenLineReader(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close(context.Background())
+
+	for {
+		line, err = l.NextLine(context.Background())
+		if err == io.EOF {
+			return lines
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestLineReader(t *testing.T) {
+	var dir = testDir(t)
+	var long = strings.Repeat("x", 2*bufio.MaxScanTokenSize)
+
+	for _, test := range []struct {
+		name     string
+		contents string
+		want     []string
+	}{
+		{"lf", "one\ntwo\n\nfour\n", []string{"one", "two", "", "four"}},
+		{"crlf", "one\r\ntwo\r\n\r\nfour\r\n", []string{"one", "two", "", "four"}},
+		{"mixed", "one\r\ntwo\nthree\r\n", []string{"one", "two", "three"}},
+		{"unterminated", "one\ntwo", []string{"one", "two"}},
+		{"long", "short\n" + long + "\nshort", []string{"short", long, "short"}},
+		{"empty", "", nil},
+	} {
+		var fpath = filepath.Join(dir, test.name)
+		var lines []string
+
+		writeTestFile(t, fpath, test.contents)
+		lines = readLines(t, fpath)
+		if len(lines) != len(test.want) {
+			t.Errorf("%s: read %d lines, want %d", test.name, len(lines), len(test.want))
+			continue
+		}
+		for i := range lines {
+			if lines[i] != test.want[i] {
+				t.Errorf("%s: line %d is %.20q, want %.20q", test.name, i, lines[i], test.want[i])
+			}
+		}
+	}
+}
+
+func TestLineReaderCancelled(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var ctx, cancel = context.WithCancel(context.Background())
+	var l LineReader
+	var err error
+
+	writeTestFile(t, fpath, "one\ntwo\n")
+
+	l, err = DefaultAdapter().OpenLineReader(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close(context.Background())
+
+	cancel()
+	_, err = l.NextLine(ctx)
+	if err != context.Canceled {
+		t.Errorf("reading with a cancelled context returned %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,203 @@
+package file
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+/*
+ErrCrossDevice is returned when trying to create a hard link to a file on a
+different file system, which is impossible.
+*/
+var ErrCrossDevice = errors.New("cannot link across file systems")
+
+/*
+ErrNotSymlink is returned by Readlink for paths which aren't symbolic links.
+*/
+var ErrNotSymlink = errors.New("not a symbolic link")
+
+func asyncLink(oldpath, newpath string, errch chan error) {
+	var err error
+
+	err = makeDirs(filepath.Dir(newpath))
+	if err != nil {
+		errch <- err
+		return
+	}
+
+	err = os.Link(oldpath, newpath)
+	if errors.Is(err, syscall.EXDEV) {
+		err = &os.LinkError{Op: "link", Old: oldpath, New: newpath, Err: ErrCrossDevice}
+	}
+	errch <- err
+}
+
+/*
+Link asynchronously creates a hard link at newurl referring to the same file
+as oldurl, creating the parent directories of newurl as required. Both have
+to be on the same file system, otherwise the error satisfies
+errors.Is(err, ErrCrossDevice). The actual linking will happen in a subthread
+so that we have a guaranteed response time from this function in case the
+operation exceeds the alotted time limits.
+*/
+func (file *FileAdapter) Link(ctx context.Context, oldurl, newurl *url.URL) error {
+	var errch = make(chan error, 1)
+	var oldpath, newpath string
+	var err error
+
+	oldpath, err = file.localPath(oldurl)
+	if err != nil {
+		return err
+	}
+
+	newpath, err = file.localPath(newurl)
+	if err != nil {
+		return err
+	}
+
+	go asyncLink(oldpath, newpath, errch)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-errch:
+		return urlError("link", newurl, err)
+	}
+}
+
+func asyncSymlink(target, linkpath string, errch chan error) {
+	var err error
+
+	err = makeDirs(filepath.Dir(linkpath))
+	if err != nil {
+		errch <- err
+		return
+	}
+
+	errch <- os.Symlink(target, linkpath)
+}
+
+/*
+checkSymlinkTarget verifies that a link at linkpath pointing to target stays
+within the base directory base. Since all other methods follow symbolic
+links, a link leading outside of it would give access to arbitrary files.
+Absolute targets are always rejected, as they can't be expressed relative to
+the base directory.
+*/
+func checkSymlinkTarget(base, target, linkpath string) error {
+	var rel string
+	var err error
+
+	if filepath.IsAbs(target) || filepath.VolumeName(target) != "" ||
+		strings.HasPrefix(filepath.ToSlash(target), "/") {
+		return &os.PathError{Op: "symlink", Path: target, Err: ErrPathEscapesBase}
+	}
+
+	rel, err = filepath.Rel(filepath.Clean(base),
+		filepath.Join(filepath.Dir(linkpath), filepath.FromSlash(target)))
+	if err != nil {
+		return err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return &os.PathError{Op: "symlink", Path: target, Err: ErrPathEscapesBase}
+	}
+	return nil
+}
+
+/*
+Symlink asynchronously creates a symbolic link at linkurl pointing to target,
+creating the parent directories of the link as required. The target is used
+verbatim as the content of the link, so relative targets are resolved
+relative to the directory containing the link. Since all other methods of the
+adapter follow symbolic links, adapters with a BaseDir refuse absolute
+targets and relative ones leading outside of it with an error wrapping
+ErrPathEscapesBase. The actual creation will happen in a subthread so that we
+have a guaranteed response time from this function in case the operation
+exceeds the alotted time limits.
+*/
+func (file *FileAdapter) Symlink(ctx context.Context, target string, linkurl *url.URL) error {
+	var errch = make(chan error, 1)
+	var linkpath string
+	var err error
+
+	linkpath, err = file.localPath(linkurl)
+	if err != nil {
+		return err
+	}
+
+	if file.BaseDir != "" {
+		err = checkSymlinkTarget(file.BaseDir, target, linkpath)
+		if err != nil {
+			return urlError("symlink", linkurl, err)
+		}
+	}
+
+	go asyncSymlink(target, linkpath, errch)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-errch:
+		return urlError("symlink", linkurl, err)
+	}
+}
+
+func asyncReadlink(linkpath string, rch chan string, errch chan error) {
+	var fi os.FileInfo
+	var target string
+	var err error
+
+	fi, err = os.Lstat(linkpath)
+	if err != nil {
+		errch <- err
+		return
+	}
+
+	if fi.Mode()&os.ModeSymlink != os.ModeSymlink {
+		errch <- &os.PathError{Op: "readlink", Path: linkpath, Err: ErrNotSymlink}
+		return
+	}
+
+	target, err = os.Readlink(linkpath)
+	if err != nil {
+		errch <- err
+		return
+	}
+	rch <- target
+}
+
+/*
+Readlink asynchronously determines the target of the symbolic link pointed
+to, exactly as it is stored in the link. If the path exists but isn't a
+symbolic link, the error satisfies errors.Is(err, ErrNotSymlink). The actual
+lookup will happen in a subthread so that we have a guaranteed response time
+from this function in case the operation exceeds the alotted time limits.
+*/
+func (file *FileAdapter) Readlink(ctx context.Context, linkurl *url.URL) (string, error) {
+	var rch = make(chan string, 1)
+	var errch = make(chan error, 1)
+	var linkpath string
+	var target string
+	var err error
+
+	linkpath, err = file.localPath(linkurl)
+	if err != nil {
+		return "", err
+	}
+
+	go asyncReadlink(linkpath, rch, errch)
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case err = <-errch:
+		return "", urlError("readlink", linkurl, err)
+	case target = <-rch:
+		return target, nil
+	}
+}
@@ -0,0 +1,181 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+func TestLink(t *testing.T) {
+	var dir = testDir(t)
+	var oldpath = filepath.Join(dir, "original")
+	var newpath = filepath.Join(dir, "sub", "dir", "link")
+	var oldfi, newfi os.FileInfo
+	var err error
+
+	writeTestFile(t, oldpath, "shared")
+
+	// The parent directories of the link are created.
+	err = DefaultAdapter().Link(context.Background(), fileURL(oldpath), fileURL(newpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectContents(t, newpath, "shared")
+
+	oldfi, err = os.Stat(oldpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newfi, err = os.Stat(newpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(oldfi, newfi) {
+		t.Errorf("%s and %s are different files", oldpath, newpath)
+	}
+
+	// Changes through one path show up through the other.
+	writeTestFile(t, newpath, "changed")
+	expectContents(t, oldpath, "changed")
+
+	err = DefaultAdapter().Link(context.Background(), fileURL(oldpath), fileURL(newpath))
+	if !errors.Is(err, os.ErrExist) {
+		t.Errorf("linking over an existing file returned %v, want %v", err, os.ErrExist)
+	}
+}
+
+func TestLinkCrossDevice(t *testing.T) {
+	var oldpath = filepath.Join(testDir(t), "original")
+	var newpath string
+	var err error
+
+	// Usually a tmpfs, separate from the temporary directory.
+	_, err = os.Stat("/dev/shm")
+	if err != nil {
+		t.Skip("no second file system to link into")
+	}
+	newpath = filepath.Join("/dev/shm", filepath.Base(filepath.Dir(oldpath))+"-link")
+	writeTestFile(t, oldpath, "data")
+
+	err = DefaultAdapter().Link(context.Background(), fileURL(oldpath), fileURL(newpath))
+	if err == nil {
+		os.Remove(newpath)
+		t.Skip("/dev/shm is on the same file system as the test directory")
+	}
+	if errors.Is(err, syscall.EXDEV) {
+		t.Errorf("linking across file systems returned %v, want %v", err, ErrCrossDevice)
+	} else if !errors.Is(err, ErrCrossDevice) {
+		t.Skipf("cannot link into /dev/shm: %v", err)
+	}
+}
+
+func TestSymlink(t *testing.T) {
+	var dir = testDir(t)
+	var link = filepath.Join(dir, "sub", "link")
+	var r filesystem.ReadCloser
+	var fi os.FileInfo
+	var err error
+
+	writeTestFile(t, filepath.Join(dir, "target"), "through the link")
+
+	// Relative targets are relative to the link's directory.
+	err = DefaultAdapter().Symlink(context.Background(), filepath.Join("..", "target"), fileURL(link))
+	if err != nil && runtime.GOOS == "windows" {
+		t.Skipf("cannot create symbolic links: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err = os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("%s is not a symbolic link but %v", link, fi.Mode())
+	}
+
+	r, err = DefaultAdapter().OpenReader(context.Background(), fileURL(link))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAndClose(t, r); got != "through the link" {
+		t.Errorf("read %q through the link, want %q", got, "through the link")
+	}
+
+	err = DefaultAdapter().Symlink(context.Background(), "elsewhere", fileURL(link))
+	if !errors.Is(err, os.ErrExist) {
+		t.Errorf("replacing the link returned %v, want %v", err, os.ErrExist)
+	}
+}
+
+func TestSymlinkBaseDir(t *testing.T) {
+	var dir = testDir(t)
+	var adapter = &FileAdapter{BaseDir: filepath.Join(dir, "base")}
+	var err error
+
+	err = os.Mkdir(filepath.Join(dir, "base"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "outside"), "outside")
+	writeTestFile(t, filepath.Join(dir, "base", "inside"), "inside")
+
+	for _, target := range []string{
+		filepath.Join(dir, "outside"),
+		"/etc/passwd",
+		filepath.Join("..", "..", ".."),
+		filepath.Join("..", "..", "outside"),
+		filepath.Join("..", "..", "base", "..", "outside"),
+	} {
+		err = adapter.Symlink(context.Background(), target,
+			&url.URL{Scheme: "file", Path: "/sub/link"})
+		if !errors.Is(err, ErrPathEscapesBase) {
+			t.Errorf("linking to %s returned %v, want %v", target, err, ErrPathEscapesBase)
+		}
+	}
+	_, err = os.Lstat(filepath.Join(dir, "base", "sub", "link"))
+	if !os.IsNotExist(err) {
+		t.Errorf("refused link was created anyway: %v", err)
+	}
+
+	// Links staying inside the base directory are fine.
+	err = adapter.Symlink(context.Background(), filepath.Join("..", "inside"),
+		&url.URL{Scheme: "file", Path: "/sub/link"})
+	if err != nil && runtime.GOOS == "windows" {
+		t.Skipf("cannot create symbolic links: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadlink(t *testing.T) {
+	var dir = testDir(t)
+	var target string
+	var err error
+
+	writeTestFile(t, filepath.Join(dir, "plain"), "plain")
+	symlinkOrSkip(t, filepath.Join("missing", "target"), filepath.Join(dir, "link"))
+
+	// The target is returned as stored, even if it doesn't exist.
+	target, err = DefaultAdapter().Readlink(context.Background(), fileURL(filepath.Join(dir, "link")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != filepath.Join("missing", "target") {
+		t.Errorf("link points to %q, want %q", target, filepath.Join("missing", "target"))
+	}
+
+	_, err = DefaultAdapter().Readlink(context.Background(), fileURL(filepath.Join(dir, "plain")))
+	if !errors.Is(err, ErrNotSymlink) {
+		t.Errorf("reading a regular file as a link returned %v, want %v", err, ErrNotSymlink)
+	}
+}
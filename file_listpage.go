@@ -0,0 +1,224 @@
+package file
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"golang.org/x/net/context"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+ErrInvalidPageToken is returned by ListEntriesPage for tokens which it didn't
+issue, which have already been used up or whose listing has expired.
+*/
+var ErrInvalidPageToken = errors.New("invalid or expired page token")
+
+/*
+pageListingIdleTimeout is the time after which the directory handle of a
+paged listing is released if nobody asked for the next page.
+*/
+const pageListingIdleTimeout = 5 * time.Minute
+
+/*
+pageListing is a directory listing in progress, waiting to be continued by
+a call to ListEntriesPage with its token.
+*/
+type pageListing struct {
+	dirpath string
+	dir     *os.File
+	expiry  *time.Timer
+}
+
+/*
+pageListings holds the listings in progress, keyed by their token. A listing
+is taken out while a page is being read, so any token can only be used by
+one caller at a time.
+*/
+var (
+	pageListingsMu sync.Mutex
+	pageListings   = make(map[string]*pageListing)
+)
+
+/*
+newPageToken generates a random token for a paged listing.
+*/
+func newPageToken() (string, error) {
+	var raw [16]byte
+	var err error
+
+	_, err = rand.Read(raw[:])
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw[:]), nil
+}
+
+/*
+parkListing stores the listing under its token until the next page is
+requested, releasing it if that doesn't happen in time.
+*/
+func parkListing(token string, l *pageListing) {
+	pageListingsMu.Lock()
+	defer pageListingsMu.Unlock()
+
+	pageListings[token] = l
+	l.expiry = time.AfterFunc(pageListingIdleTimeout, func() {
+		pageListingsMu.Lock()
+		if pageListings[token] != l {
+			pageListingsMu.Unlock()
+			return
+		}
+		delete(pageListings, token)
+		pageListingsMu.Unlock()
+
+		l.dir.Close()
+	})
+}
+
+/*
+takeListing removes the listing for the token, so that it can be continued.
+It returns nil if there is no such listing for the directory.
+*/
+func takeListing(token, dirpath string) *pageListing {
+	var l *pageListing
+
+	pageListingsMu.Lock()
+	defer pageListingsMu.Unlock()
+
+	l = pageListings[token]
+	if l == nil || l.dirpath != dirpath || !l.expiry.Stop() {
+		return nil
+	}
+	delete(pageListings, token)
+	return l
+}
+
+/*
+asyncListPage reads up to limit names from the listing, parking it again
+under the token if there are more names to come and closing it otherwise.
+*/
+func asyncListPage(l *pageListing, token string, limit int, rch chan []string,
+	nextch chan string, errch chan error) {
+	var names []string
+	var err error
+
+	names, err = l.dir.Readdirnames(limit)
+	if err == io.EOF {
+		l.dir.Close()
+		rch <- names
+		nextch <- ""
+		return
+	} else if err != nil {
+		l.dir.Close()
+		errch <- err
+		return
+	}
+
+	parkListing(token, l)
+	rch <- names
+	nextch <- token
+}
+
+/*
+ListEntriesPage lists a directory in pages of up to limit names, for
+directories too large to be listed at once. The first page is requested with
+an empty token; every page comes with the token for requesting the next one,
+which is empty once all names have been returned; the last page may be empty
+itself. Like with ListEntries, the names are returned in no particular order.
+
+The directory is kept open between the pages. Its handle is released once the
+last page has been returned, after an error, or if the next page hasn't been
+requested for a few minutes, after which the token is rejected with an error
+wrapping ErrInvalidPageToken. Tokens are only valid for the directory they
+were issued for, and only until they have been used; if a page cannot be
+read, e.g. because the context was cancelled, the listing must be restarted.
+A limit of zero or less selects a default page size.
+*/
+func (file *FileAdapter) ListEntriesPage(ctx context.Context, dirurl *url.URL,
+	token string, limit int) (names []string, next string, err error) {
+	var rch = make(chan []string, 1)
+	var nextch = make(chan string, 1)
+	var errch = make(chan error, 1)
+	var l *pageListing
+	var dirpath string
+
+	dirpath, err = file.localPath(dirurl)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if limit <= 0 {
+		limit = listBatchSize
+	}
+
+	if token == "" {
+		var dirch = make(chan *os.File, 1)
+		var dir *os.File
+
+		token, err = newPageToken()
+		if err != nil {
+			return nil, "", urlError("list", dirurl, err)
+		}
+
+		go asyncOpenDir(dirpath, dirch, errch)
+
+		select {
+		case <-ctx.Done():
+			go discardOpenedDir(dirch, errch)
+			return nil, "", ctx.Err()
+		case err = <-errch:
+			return nil, "", urlError("list", dirurl, err)
+		case dir = <-dirch:
+		}
+
+		l = &pageListing{dirpath: dirpath, dir: dir}
+	} else {
+		l = takeListing(token, dirpath)
+		if l == nil {
+			return nil, "", urlError("list", dirurl, ErrInvalidPageToken)
+		}
+	}
+
+	go asyncListPage(l, token, limit, rch, nextch, errch)
+
+	select {
+	case <-ctx.Done():
+		// Don't let the listing be continued after a page was lost.
+		go discardListPage(token, rch, errch)
+		return nil, "", ctx.Err()
+	case err = <-errch:
+		return nil, "", urlError("list", dirurl, err)
+	case names = <-rch:
+		return names, <-nextch, nil
+	}
+}
+
+/*
+discardListPage waits for a page which the caller has given up on and closes
+the listing if it was parked for the next page.
+*/
+func discardListPage(token string, rch chan []string, errch chan error) {
+	var l *pageListing
+
+	select {
+	case <-errch:
+	case <-rch:
+		pageListingsMu.Lock()
+		l = pageListings[token]
+		if l != nil && l.expiry.Stop() {
+			delete(pageListings, token)
+		} else {
+			l = nil
+		}
+		pageListingsMu.Unlock()
+
+		if l != nil {
+			l.dir.Close()
+		}
+	}
+}
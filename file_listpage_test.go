@@ -0,0 +1,116 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListEntriesPage(t *testing.T) {
+	var dir = testDir(t)
+	var seen = make(map[string]bool)
+	var names []string
+	var token, next string
+	var before, after int
+	var counted bool
+	var pages int
+	var i int
+	var err error
+
+	for i = 0; i < 25; i++ {
+		writeTestFile(t, filepath.Join(dir, fmt.Sprintf("file%02d", i)), "")
+	}
+	before, counted = openDescriptors()
+
+	for {
+		names, next, err = DefaultAdapter().ListEntriesPage(context.Background(), fileURL(dir),
+			token, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) > 10 {
+			t.Errorf("page %d has %d names, more than the limit", pages, len(names))
+		}
+		for _, name := range names {
+			if seen[name] {
+				t.Errorf("%s listed twice", name)
+			}
+			seen[name] = true
+		}
+		pages++
+
+		if next == "" {
+			break
+		}
+		if token != "" && next != token {
+			t.Errorf("token changed from %s to %s", token, next)
+		}
+		token = next
+	}
+
+	if len(seen) != 25 {
+		t.Errorf("listed %d entries, want 25", len(seen))
+	}
+	if pages < 3 {
+		t.Errorf("listed in %d pages, want at least 3", pages)
+	}
+
+	// All pages have been returned, so the token is used up.
+	_, _, err = DefaultAdapter().ListEntriesPage(context.Background(), fileURL(dir), token, 10)
+	if !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("listing with a used up token returned %v, want %v", err, ErrInvalidPageToken)
+	}
+
+	after, _ = openDescriptors()
+	if counted && after != before {
+		t.Errorf("%d descriptors open after the last page, %d before", after, before)
+	}
+}
+
+func TestListEntriesPageWrongDirectory(t *testing.T) {
+	var dir = testDir(t)
+	var token string
+	var err error
+
+	for _, sub := range []string{"a", "b"} {
+		err = os.Mkdir(filepath.Join(dir, sub), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Join(dir, sub, "1"), "")
+		writeTestFile(t, filepath.Join(dir, sub, "2"), "")
+	}
+
+	_, token, err = DefaultAdapter().ListEntriesPage(context.Background(),
+		fileURL(filepath.Join(dir, "a")), "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == "" {
+		t.Fatal("no token returned for the rest of the listing")
+	}
+
+	_, _, err = DefaultAdapter().ListEntriesPage(context.Background(),
+		fileURL(filepath.Join(dir, "b")), token, 1)
+	if !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("continuing in another directory returned %v, want %v", err, ErrInvalidPageToken)
+	}
+
+	_, _, err = DefaultAdapter().ListEntriesPage(context.Background(),
+		fileURL(filepath.Join(dir, "a")), "made up", 1)
+	if !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("listing with a made up token returned %v, want %v", err, ErrInvalidPageToken)
+	}
+
+	// The listing of a is still there to be finished.
+	for token != "" {
+		_, token, err = DefaultAdapter().ListEntriesPage(context.Background(),
+			fileURL(filepath.Join(dir, "a")), token, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package file
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*
+ErrAlreadyLocked is returned by AcquireInstanceLock if the lock is already
+held, usually by another process.
+*/
+var ErrAlreadyLocked = errors.New("already locked")
+
+func asyncAcquireLock(fpath string, perm os.FileMode, rch chan *os.File, errch chan error) {
+	var f *os.File
+	var err error
+
+	err = makeDirs(filepath.Dir(fpath))
+	if err != nil {
+		errch <- err
+		return
+	}
+
+	f, err = os.OpenFile(fpath, os.O_RDWR|os.O_CREATE, perm)
+	if err != nil {
+		errch <- err
+		return
+	}
+
+	err = lockFile(f)
+	if err != nil {
+		f.Close()
+		errch <- err
+		return
+	}
+	rch <- f
+}
+
+/*
+discardLock releases a lock acquired by asyncAcquireLock after the caller
+stopped waiting for it.
+*/
+func discardLock(rch chan *os.File, errch chan error) {
+	var f *os.File
+
+	select {
+	case <-errch:
+	case f = <-rch:
+		f.Close()
+	}
+}
+
+/*
+AcquireInstanceLock takes an exclusive lock on the file pointed to, creating
+it if needed, to make sure only one process at a time works with whatever the
+lock file guards, e.g. a data directory. The lock is advisory and is only
+respected by processes using the same mechanism (flock on POSIX systems,
+LockFileEx on Windows). It is never waited for: if the lock is held already,
+an error wrapping ErrAlreadyLocked is returned right away. The lock is
+released when release is called, or at the latest when the process exits.
+The lock file itself is left in place.
+*/
+func (file *FileAdapter) AcquireInstanceLock(ctx context.Context, lockurl *url.URL) (
+	release func() error, err error) {
+	var rch = make(chan *os.File, 1)
+	var errch = make(chan error, 1)
+	var cancel context.CancelFunc
+	var once sync.Once
+	var f *os.File
+	var fpath string
+
+	fpath, err = file.localPath(lockurl)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel = file.operationContext(ctx)
+	defer cancel()
+
+	go asyncAcquireLock(fpath, file.fileMode(), rch, errch)
+
+	select {
+	case <-ctx.Done():
+		go discardLock(rch, errch)
+		return nil, ctx.Err()
+	case err = <-errch:
+		return nil, urlError("lock", lockurl, err)
+	case f = <-rch:
+		return func() error {
+			var cerr error = os.ErrClosed
+
+			// Closing the file drops the lock.
+			once.Do(func() {
+				cerr = f.Close()
+			})
+			return cerr
+		}, nil
+	}
+}
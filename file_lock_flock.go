@@ -0,0 +1,38 @@
+//go:build unix && !aix
+
+package file
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"os"
+	"syscall"
+)
+
+/*
+lockFile takes an exclusive flock on file without waiting for it.
+*/
+func lockFile(file *os.File) error {
+	var conn syscall.RawConn
+	var err error
+	var lerr error
+
+	conn, err = file.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	err = conn.Control(func(fd uintptr) {
+		lerr = unix.Flock(int(fd), unix.LOCK_EX|unix.LOCK_NB)
+	})
+	if err != nil {
+		return err
+	}
+
+	if errors.Is(lerr, unix.EWOULDBLOCK) {
+		return &os.PathError{Op: "lock", Path: file.Name(), Err: ErrAlreadyLocked}
+	} else if lerr != nil {
+		return &os.PathError{Op: "lock", Path: file.Name(), Err: lerr}
+	}
+	return nil
+}
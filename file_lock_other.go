@@ -0,0 +1,15 @@
+//go:build (!unix && !windows) || aix
+
+package file
+
+import (
+	"errors"
+	"os"
+)
+
+/*
+lockFile is not supported on this platform.
+*/
+func lockFile(file *os.File) error {
+	return &os.PathError{Op: "lock", Path: file.Name(), Err: errors.ErrUnsupported}
+}
@@ -0,0 +1,53 @@
+package file
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcquireInstanceLock(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "run", "lock")
+	var release, second func() error
+	var err error
+
+	release, err = DefaultAdapter().AcquireInstanceLock(context.Background(), fileURL(fpath))
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each acquisition opens the file anew, so a second one conflicts even
+	// within the same process, just like another instance would.
+	second, err = DefaultAdapter().AcquireInstanceLock(context.Background(), fileURL(fpath))
+	if !errors.Is(err, ErrAlreadyLocked) {
+		t.Errorf("second acquisition returned %v, want %v", err, ErrAlreadyLocked)
+	}
+	if second != nil {
+		second()
+	}
+
+	err = release()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = release()
+	if err != os.ErrClosed {
+		t.Errorf("releasing twice returned %v, want %v", err, os.ErrClosed)
+	}
+
+	second, err = DefaultAdapter().AcquireInstanceLock(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatalf("lock couldn't be acquired after release: %v", err)
+	}
+	defer second()
+
+	_, err = os.Stat(fpath)
+	if err != nil {
+		t.Errorf("lock file is gone: %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+package file
+
+import (
+	"errors"
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+/*
+lockFile takes an exclusive lock on all of file without waiting for it.
+*/
+func lockFile(file *os.File) error {
+	var err error
+
+	err = windows.LockFileEx(windows.Handle(file.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0, ^uint32(0), ^uint32(0), new(windows.Overlapped))
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return &os.PathError{Op: "lock", Path: file.Name(), Err: ErrAlreadyLocked}
+	} else if err != nil {
+		return &os.PathError{Op: "lock", Path: file.Name(), Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,570 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+/*
+The in-memory adapter is registered under the "memfile" scheme so that tests
+can use it through the filesystem API without any setup, just like local
+files.
+*/
+func init() {
+	filesystem.AddImplementation("memfile", NewMemoryAdapter())
+}
+
+/*
+MemoryAdapter is a file system adapter which keeps all files in memory. It is
+meant for tests of code using the filesystem API, which can then be run
+without touching the disk. It accepts URLs with the "memfile" scheme or none
+at all. Directories exist implicitly as long as there are files in them, and
+are created as needed when writing files. Operations take effect
+immediately; the context is only checked for being done beforehand, just
+like the file adapter stops waiting for operations once their context is
+done.
+*/
+type MemoryAdapter struct {
+	mu       sync.Mutex
+	files    map[string]*memoryFile
+	watchers map[*memoryWatcher]bool
+
+	// callbacks tracks the watch callbacks reporting changes which are
+	// still running.
+	callbacks sync.WaitGroup
+}
+
+/*
+memoryFile holds the contents of a file in a MemoryAdapter. Open readers and
+writers keep referring to it even if it is removed or replaced.
+*/
+type memoryFile struct {
+	data []byte
+}
+
+/*
+NewMemoryAdapter creates a new, empty MemoryAdapter.
+*/
+func NewMemoryAdapter() *MemoryAdapter {
+	return &MemoryAdapter{
+		files:    make(map[string]*memoryFile),
+		watchers: make(map[*memoryWatcher]bool),
+	}
+}
+
+/*
+memoryPath determines the key of the file pointed to by the URL.
+*/
+func memoryPath(fileurl *url.URL) (string, error) {
+	if fileurl.Scheme != "" && fileurl.Scheme != "memfile" {
+		return "", fmt.Errorf("%s: %w %q, expected \"memfile\"",
+			fileurl.Redacted(), ErrUnsupportedScheme, fileurl.Scheme)
+	}
+	if fileurl.Host != "" {
+		return "", fmt.Errorf("%s: %w (host %q)", fileurl.Redacted(),
+			ErrRemoteHost, fileurl.Host)
+	}
+	if fileurl.Opaque != "" {
+		return path.Clean("/" + fileurl.Opaque), nil
+	}
+	return path.Clean("/" + fileurl.Path), nil
+}
+
+/*
+isDirLocked determines whether there are any files below fpath. The caller
+must hold the lock.
+*/
+func (m *MemoryAdapter) isDirLocked(fpath string) bool {
+	var prefix = strings.TrimSuffix(fpath, "/") + "/"
+	var name string
+
+	for name = range m.files {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+createLocked creates or truncates the file at fpath, making sure none of its
+parents is a file. The caller must hold the lock.
+*/
+func (m *MemoryAdapter) createLocked(fpath string, truncate bool) (*memoryFile, error) {
+	var parent string
+	var f *memoryFile
+	var ok bool
+
+	if fpath == "/" || m.isDirLocked(fpath) {
+		return nil, &os.PathError{Op: "open", Path: fpath, Err: ErrIsDirectory}
+	}
+
+	for parent = path.Dir(fpath); parent != "/"; parent = path.Dir(parent) {
+		if _, ok = m.files[parent]; ok {
+			return nil, &os.PathError{Op: "mkdir", Path: parent, Err: ErrNotDirectory}
+		}
+	}
+
+	f, ok = m.files[fpath]
+	if !ok || truncate {
+		f = new(memoryFile)
+		m.files[fpath] = f
+	}
+	return f, nil
+}
+
+/*
+OpenReader opens the specified file for reading. The reader sees the
+contents of the file at the time it was opened.
+*/
+func (m *MemoryAdapter) OpenReader(ctx context.Context, fileurl *url.URL) (
+	filesystem.ReadCloser, error) {
+	var fpath string
+	var f *memoryFile
+	var ok bool
+	var err error
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	fpath, err = memoryPath(fileurl)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok = m.files[fpath]
+	if !ok {
+		if fpath == "/" || m.isDirLocked(fpath) {
+			return nil, &os.PathError{Op: "open", Path: fpath, Err: ErrIsDirectory}
+		}
+		return nil, &os.PathError{Op: "open", Path: fpath, Err: os.ErrNotExist}
+	}
+
+	return &memoryReader{data: f.data}, nil
+}
+
+/*
+OpenWriter creates the specified file, or truncates it if it exists, and
+opens it for writing. Written data is visible to readers opened afterwards
+right away.
+*/
+func (m *MemoryAdapter) OpenWriter(ctx context.Context, fileurl *url.URL) (
+	filesystem.WriteCloser, error) {
+	return m.openForWriting(ctx, fileurl, true)
+}
+
+/*
+OpenAppender opens the specified file for appending to it, creating it if it
+doesn't exist yet.
+*/
+func (m *MemoryAdapter) OpenAppender(ctx context.Context, fileurl *url.URL) (
+	filesystem.WriteCloser, error) {
+	return m.openForWriting(ctx, fileurl, false)
+}
+
+func (m *MemoryAdapter) openForWriting(ctx context.Context, fileurl *url.URL, truncate bool) (
+	filesystem.WriteCloser, error) {
+	var fpath string
+	var f *memoryFile
+	var err error
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	fpath, err = memoryPath(fileurl)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err = m.createLocked(fpath, truncate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &memoryWriter{adapter: m, file: f, path: fpath}, nil
+}
+
+/*
+ListEntries returns the sorted names of the files and directories directly
+inside of the specified directory.
+*/
+func (m *MemoryAdapter) ListEntries(ctx context.Context, dirurl *url.URL) ([]string, error) {
+	var seen = make(map[string]bool)
+	var entries = []string{}
+	var dirpath string
+	var prefix string
+	var name string
+	var ok bool
+	var err error
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	dirpath, err = memoryPath(dirurl)
+	if err != nil {
+		return nil, err
+	}
+	prefix = strings.TrimSuffix(dirpath, "/") + "/"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok = m.files[dirpath]; ok {
+		return nil, &os.PathError{Op: "readdir", Path: dirpath, Err: ErrNotDirectory}
+	}
+
+	for name = range m.files {
+		var rest string
+
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rest = strings.TrimPrefix(name, prefix)
+		rest, _, _ = strings.Cut(rest, "/")
+		if !seen[rest] {
+			seen[rest] = true
+			entries = append(entries, rest)
+		}
+	}
+
+	if len(entries) == 0 && dirpath != "/" {
+		return nil, &os.PathError{Op: "readdir", Path: dirpath, Err: os.ErrNotExist}
+	}
+
+	sort.Strings(entries)
+	return entries, nil
+}
+
+/*
+Remove removes the specified file. Directories cannot be removed since they
+only exist as long as they contain files.
+*/
+func (m *MemoryAdapter) Remove(ctx context.Context, fileurl *url.URL) error {
+	var fpath string
+	var ok bool
+	var err error
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	fpath, err = memoryPath(fileurl)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok = m.files[fpath]; !ok {
+		if fpath == "/" || m.isDirLocked(fpath) {
+			return &os.PathError{Op: "remove", Path: fpath, Err: syscall.ENOTEMPTY}
+		}
+		return &os.PathError{Op: "remove", Path: fpath, Err: os.ErrNotExist}
+	}
+
+	delete(m.files, fpath)
+	m.reportRemovalLocked(fpath)
+	return nil
+}
+
+/*
+memoryWatcher holds the state of a watch on a MemoryAdapter.
+*/
+type memoryWatcher struct {
+	path  string
+	url   *url.URL
+	cb    filesystem.FileWatchFunc
+	errch chan error
+	done  chan struct{}
+	once  sync.Once
+
+	// senders tracks the goroutines reporting errors, which must be gone
+	// before the error channel can be closed.
+	senders sync.WaitGroup
+}
+
+/*
+WatchFile watches the specified file, or the files directly inside of the
+specified directory, for changes. The current state of the files is reported
+as the initial change, and every time a writer is closed afterwards, the new
+state of the file is reported. Removing the watched file, or a file in the
+watched directory, is reported as an error wrapping os.ErrNotExist on the
+error channel, like reading the removed file fails for watches through the
+file adapter. The error channel is closed when the watch is cancelled.
+Changes are reported from separate goroutines; WaitForCallbacks waits for
+them to return.
+*/
+func (m *MemoryAdapter) WatchFile(ctx context.Context, fileurl *url.URL,
+	cb filesystem.FileWatchFunc) (filesystem.CancelWatchFunc, chan error, error) {
+	var w *memoryWatcher
+	var names []string
+	var name string
+	var fpath string
+	var f *memoryFile
+	var ok bool
+	var err error
+
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+
+	fpath, err = memoryPath(fileurl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w = &memoryWatcher{
+		path:  fpath,
+		url:   fileurl,
+		cb:    cb,
+		errch: make(chan error),
+		done:  make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	f, ok = m.files[fpath]
+	if !ok && fpath != "/" && !m.isDirLocked(fpath) {
+		m.mu.Unlock()
+		return nil, nil, &os.PathError{Op: "watch", Path: fpath, Err: os.ErrNotExist}
+	}
+	m.watchers[w] = true
+	m.mu.Unlock()
+
+	// The current state is reported as the first change.
+	if ok {
+		cb(fileurl, &memoryReader{data: f.data})
+	} else {
+		names, err = m.ListEntries(ctx, fileurl)
+		if err != nil {
+			m.unwatch(w)
+			return nil, nil, err
+		}
+		for _, name = range names {
+			var child = childURL(fileurl, name)
+			var reader filesystem.ReadCloser
+			var rerr error
+
+			reader, rerr = m.OpenReader(ctx, child)
+			if rerr == nil {
+				cb(child, reader)
+			}
+		}
+	}
+
+	return func() error {
+		m.unwatch(w)
+		return nil
+	}, w.errch, nil
+}
+
+/*
+unwatch cancels the watch w. No more errors are reported once it has been
+removed from the watchers, so the error channel can be closed as soon as the
+errors already being reported have been dropped.
+*/
+func (m *MemoryAdapter) unwatch(w *memoryWatcher) {
+	m.mu.Lock()
+	delete(m.watchers, w)
+	m.mu.Unlock()
+
+	w.once.Do(func() {
+		close(w.done)
+		w.senders.Wait()
+		close(w.errch)
+	})
+}
+
+/*
+WaitForCallbacks waits for all watch callbacks reporting changes which are
+currently running to return, as long as the context permits. This allows
+tests to make sure all changes so far have been handled. It must not be
+called from a callback, since it would wait for itself.
+*/
+func (m *MemoryAdapter) WaitForCallbacks(ctx context.Context) error {
+	return waitForCallbacks(ctx, &m.callbacks)
+}
+
+/*
+watchesLocked determines whether the watcher w is interested in the file at
+fpath, and if so, under which URL changes to it are reported. The caller must
+hold the lock.
+*/
+func (w *memoryWatcher) watchesLocked(fpath string) (*url.URL, bool) {
+	if w.path == fpath {
+		return w.url, true
+	} else if path.Dir(fpath) == w.path {
+		return childURL(w.url, path.Base(fpath)), true
+	}
+	return nil, false
+}
+
+/*
+notify reports the current state of the file at fpath to all watchers
+interested in it.
+*/
+func (m *MemoryAdapter) notify(fpath string) {
+	var w *memoryWatcher
+	var f *memoryFile
+	var data []byte
+	var ok bool
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok = m.files[fpath]
+	if !ok {
+		return
+	}
+
+	// Take the snapshot while holding the lock, so the callbacks neither
+	// race with nor see writes made after this state was reached.
+	data = f.data
+
+	for w = range m.watchers {
+		var subject *url.URL
+
+		subject, ok = w.watchesLocked(fpath)
+		if !ok {
+			continue
+		}
+
+		m.callbacks.Add(1)
+		go func(w *memoryWatcher) {
+			defer m.callbacks.Done()
+			w.cb(subject, &memoryReader{data: data})
+		}(w)
+	}
+}
+
+/*
+reportRemovalLocked reports the removal of the file at fpath to all watchers
+interested in it. The errors are handed over by separate goroutines, which
+give up once the watch is cancelled. The caller must hold the lock.
+*/
+func (m *MemoryAdapter) reportRemovalLocked(fpath string) {
+	var err = &os.PathError{Op: "watch", Path: fpath, Err: os.ErrNotExist}
+	var w *memoryWatcher
+	var ok bool
+
+	for w = range m.watchers {
+		if _, ok = w.watchesLocked(fpath); !ok {
+			continue
+		}
+
+		w.senders.Add(1)
+		go func(w *memoryWatcher) {
+			defer w.senders.Done()
+			select {
+			case w.errch <- err:
+			case <-w.done:
+			}
+		}(w)
+	}
+}
+
+/*
+memoryReader reads from a snapshot of the contents of a memoryFile.
+*/
+type memoryReader struct {
+	data   []byte
+	offset int
+	closed bool
+}
+
+/*
+Read() copies the next bytes of the snapshot into p.
+*/
+func (r *memoryReader) Read(ctx context.Context, p []byte) (int, error) {
+	var n int
+
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if r.offset >= len(r.data) {
+		return 0, io.EOF
+	}
+
+	n = copy(p, r.data[r.offset:])
+	r.offset += n
+	return n, nil
+}
+
+/*
+Close() releases the reader.
+*/
+func (r *memoryReader) Close(ctx context.Context) error {
+	if r.closed {
+		return os.ErrClosed
+	}
+	r.closed = true
+	return nil
+}
+
+/*
+memoryWriter appends to a memoryFile.
+*/
+type memoryWriter struct {
+	adapter *MemoryAdapter
+	file    *memoryFile
+	path    string
+	closed  bool
+}
+
+/*
+Write() appends p to the file.
+*/
+func (w *memoryWriter) Write(ctx context.Context, p []byte) (int, error) {
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	// Readers only ever look at the data up to the length it had when they
+	// were opened, so appending doesn't affect them.
+	w.adapter.mu.Lock()
+	w.file.data = append(w.file.data, p...)
+	w.adapter.mu.Unlock()
+
+	return len(p), nil
+}
+
+/*
+Close() releases the writer and reports the new state of the file to any
+watchers.
+*/
+func (w *memoryWriter) Close(ctx context.Context) error {
+	if w.closed {
+		return os.ErrClosed
+	}
+	w.closed = true
+	w.adapter.notify(w.path)
+	return nil
+}
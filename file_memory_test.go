@@ -0,0 +1,320 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+memURL creates a URL for the path p in a MemoryAdapter.
+*/
+func memURL(p string) *url.URL {
+	return &url.URL{Scheme: "memfile", Path: p}
+}
+
+/*
+writeMemoryFile writes contents to the file at p through m.
+*/
+func writeMemoryFile(t *testing.T, m *MemoryAdapter, p, contents string) {
+	var w filesystem.WriteCloser
+	var err error
+
+	t.Helper()
+
+	w, err = m.OpenWriter(context.Background(), memURL(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(context.Background(), []byte(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+/*
+readMemoryFile reads the file at p through m.
+*/
+func readMemoryFile(t *testing.T, m *MemoryAdapter, p string) string {
+	var r filesystem.ReadCloser
+	var err error
+
+	t.Helper()
+
+	r, err = m.OpenReader(context.Background(), memURL(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return readAndClose(t, r)
+}
+
+func TestMemoryAdapterReadWrite(t *testing.T) {
+	var m = NewMemoryAdapter()
+	var w filesystem.WriteCloser
+	var err error
+
+	writeMemoryFile(t, m, "/dir/file", "hello")
+	if got := readMemoryFile(t, m, "/dir/file"); got != "hello" {
+		t.Errorf("read %q, want %q", got, "hello")
+	}
+
+	w, err = m.OpenAppender(context.Background(), memURL("/dir/file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(context.Background(), []byte(", world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readMemoryFile(t, m, "/dir/file"); got != "hello, world" {
+		t.Errorf("read %q after appending, want %q", got, "hello, world")
+	}
+
+	writeMemoryFile(t, m, "/dir/file", "new")
+	if got := readMemoryFile(t, m, "/dir/file"); got != "new" {
+		t.Errorf("read %q after rewriting, want %q", got, "new")
+	}
+
+	_, err = m.OpenReader(context.Background(), memURL("/dir/missing"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("opening a missing file returned %v, want %v", err, os.ErrNotExist)
+	}
+
+	_, err = m.OpenReader(context.Background(), memURL("/dir"))
+	if !errors.Is(err, ErrIsDirectory) {
+		t.Errorf("opening a directory returned %v, want %v", err, ErrIsDirectory)
+	}
+
+	_, err = m.OpenWriter(context.Background(), memURL("/dir/file/below"))
+	if !errors.Is(err, ErrNotDirectory) {
+		t.Errorf("writing below a file returned %v, want %v", err, ErrNotDirectory)
+	}
+
+	_, err = m.OpenReader(context.Background(), &url.URL{Scheme: "file", Path: "/dir/file"})
+	if !errors.Is(err, ErrUnsupportedScheme) {
+		t.Errorf("opening a file URL returned %v, want %v", err, ErrUnsupportedScheme)
+	}
+
+	for _, u := range []*url.URL{
+		{Scheme: "file", User: url.UserPassword("user", "hunter2"), Path: "/dir/file"},
+		{Scheme: "memfile", User: url.UserPassword("user", "hunter2"), Host: "remote", Path: "/dir/file"},
+	} {
+		_, err = memoryPath(u)
+		if err == nil {
+			t.Errorf("mapping %s succeeded", u.Redacted())
+		} else if strings.Contains(err.Error(), "hunter2") {
+			t.Errorf("error %q reveals the password", err)
+		}
+	}
+}
+
+func TestMemoryAdapterListAndRemove(t *testing.T) {
+	var m = NewMemoryAdapter()
+	var names []string
+	var err error
+
+	writeMemoryFile(t, m, "/dir/b", "b")
+	writeMemoryFile(t, m, "/dir/a", "a")
+	writeMemoryFile(t, m, "/dir/sub/c", "c")
+
+	names, err = m.ListEntries(context.Background(), memURL("/dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b", "sub"}) {
+		t.Errorf("listed %v, want [a b sub]", names)
+	}
+
+	_, err = m.ListEntries(context.Background(), memURL("/dir/a"))
+	if !errors.Is(err, ErrNotDirectory) {
+		t.Errorf("listing a file returned %v, want %v", err, ErrNotDirectory)
+	}
+
+	err = m.Remove(context.Background(), memURL("/dir"))
+	if err == nil {
+		t.Error("removing a non-empty directory succeeded")
+	}
+
+	err = m.Remove(context.Background(), memURL("/dir/sub/c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Remove(context.Background(), memURL("/dir/sub/c"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("removing twice returned %v, want %v", err, os.ErrNotExist)
+	}
+
+	names, err = m.ListEntries(context.Background(), memURL("/dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("listed %v after removing the subdirectory's file, want [a b]", names)
+	}
+}
+
+func TestMemoryAdapterCancelledContext(t *testing.T) {
+	var m = NewMemoryAdapter()
+	var ctx, cancel = context.WithCancel(context.Background())
+	var err error
+
+	cancel()
+
+	_, err = m.OpenWriter(ctx, memURL("/file"))
+	if err != context.Canceled {
+		t.Errorf("OpenWriter returned %v, want %v", err, context.Canceled)
+	}
+	_, err = m.ListEntries(ctx, memURL("/"))
+	if err != context.Canceled {
+		t.Errorf("ListEntries returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestMemoryAdapterWatch(t *testing.T) {
+	var m = NewMemoryAdapter()
+	var mu sync.Mutex
+	var reported = make(map[string][]string)
+	var cancel filesystem.CancelWatchFunc
+	var errch chan error
+	var err error
+
+	writeMemoryFile(t, m, "/dir/a", "one")
+
+	cancel, errch, err = m.WatchFile(context.Background(), memURL("/dir"),
+		func(path *url.URL, r filesystem.ReadCloser) {
+			var contents = readAndClose(t, r)
+
+			mu.Lock()
+			defer mu.Unlock()
+			reported[path.Path] = append(reported[path.Path], contents)
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeMemoryFile(t, m, "/dir/a", "two")
+	writeMemoryFile(t, m, "/dir/b", "three")
+	writeMemoryFile(t, m, "/elsewhere", "ignored")
+
+	err = m.WaitForCallbacks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	if !reflect.DeepEqual(reported, map[string][]string{
+		"/dir/a": {"one", "two"},
+		"/dir/b": {"three"},
+	}) {
+		t.Errorf("reported %v", reported)
+	}
+	mu.Unlock()
+
+	err = m.Remove(context.Background(), memURL("/dir/b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err = <-errch:
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("removal reported as %v, want %v", err, os.ErrNotExist)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("removal not reported")
+	}
+
+	// Unread errors must not keep the watch from being cancelled.
+	err = m.Remove(context.Background(), memURL("/dir/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cancel()
+	if err != nil {
+		t.Error(err)
+	}
+	for err = range errch {
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+}
+
+func TestMemoryAdapterWatchConcurrentWriter(t *testing.T) {
+	var m = NewMemoryAdapter()
+	var mu sync.Mutex
+	var reported []string
+	var cancel filesystem.CancelWatchFunc
+	var appender filesystem.WriteCloser
+	var i int
+	var err error
+
+	writeMemoryFile(t, m, "/log", "")
+
+	cancel, _, err = m.WatchFile(context.Background(), memURL("/log"),
+		func(path *url.URL, r filesystem.ReadCloser) {
+			var contents = readAndClose(t, r)
+
+			mu.Lock()
+			defer mu.Unlock()
+			reported = append(reported, contents)
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	appender, err = m.OpenAppender(context.Background(), memURL("/log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = appender.Write(context.Background(), []byte("closed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = appender.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep appending while the close above is being reported; none of this
+	// may show up in the report.
+	appender, err = m.OpenAppender(context.Background(), memURL("/log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i = 0; i < 1000; i++ {
+		_, err = appender.Write(context.Background(), []byte("x"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = m.WaitForCallbacks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reported) != 2 || reported[1] != "closed" {
+		t.Errorf("reported %q, want the empty file and %q", reported, "closed")
+	}
+}
@@ -0,0 +1,171 @@
+package file
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+/*
+mkdirAllReporting works like makeDirs, but returns the directories it
+created, outermost first. Directories created concurrently by someone else
+are not included.
+*/
+func mkdirAllReporting(dir string) ([]string, error) {
+	var missing []string
+	var created []string
+	var current string
+	var i int
+	var err error
+
+	for current = filepath.Clean(dir); ; current = filepath.Dir(current) {
+		var fi os.FileInfo
+
+		fi, err = os.Stat(current)
+		if err == nil {
+			if !fi.IsDir() {
+				return nil, &os.PathError{Op: "mkdir", Path: current, Err: ErrNotDirectory}
+			}
+			break
+		} else if !os.IsNotExist(err) && !errors.Is(err, syscall.ENOTDIR) {
+			// ENOTDIR means that some parent isn't a directory, which the
+			// next iterations will find.
+			return nil, err
+		}
+
+		missing = append(missing, current)
+		if filepath.Dir(current) == current {
+			break
+		}
+	}
+
+	for i = len(missing) - 1; i >= 0; i-- {
+		err = os.Mkdir(missing[i], 0755)
+		if os.IsExist(err) {
+			var fi os.FileInfo
+			var serr error
+
+			fi, serr = os.Stat(missing[i])
+			if serr == nil && fi.IsDir() {
+				continue
+			}
+		}
+		if err != nil {
+			return created, err
+		}
+		created = append(created, missing[i])
+	}
+
+	return created, nil
+}
+
+func asyncMkdirAllReporting(dir string, rch chan []string, errch chan error) {
+	var created []string
+	var err error
+
+	created, err = mkdirAllReporting(dir)
+	rch <- created
+	errch <- err
+}
+
+/*
+parentURL returns a copy of the URL u pointing to the directory levels levels
+above it. Opaque URLs are turned into ones with a regular path; they must
+have been checked by localPath before.
+*/
+func parentURL(u *url.URL, levels int) *url.URL {
+	var parent = *u
+	var p = u.Path
+	var i int
+
+	if u.Opaque != "" {
+		p, _ = url.PathUnescape(u.Opaque)
+		parent.Opaque = ""
+	}
+
+	p = path.Clean(p)
+	for i = 0; i < levels; i++ {
+		p = path.Dir(p)
+	}
+
+	parent.Path = p
+	parent.RawPath = ""
+	return &parent
+}
+
+/*
+MkdirAllReporting creates the directory pointed to along with any missing
+parents, like the writers do implicitly, and returns URLs for the directories
+it actually created, outermost first, so they can be removed again through
+Remove in reverse order. The URLs are derived from dirurl, so they are
+relative to the adapter's BaseDir if there is one. If creating one of the
+directories fails, the error is returned along with the directories created
+up to that point. The actual creation will happen in a subthread so that we
+have a guaranteed response time from this function in case the operation
+exceeds the alotted time limits; if the context is done first, no
+directories are reported even though some may have been created.
+*/
+func (file *FileAdapter) MkdirAllReporting(ctx context.Context, dirurl *url.URL) (
+	[]*url.URL, error) {
+	var rch = make(chan []string, 1)
+	var errch = make(chan error, 1)
+	var created []string
+	var urls []*url.URL
+	var local string
+	var dir string
+	var err error
+
+	dir, err = file.localPath(dirurl)
+	if err != nil {
+		return nil, err
+	}
+
+	go asyncMkdirAllReporting(dir, rch, errch)
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case created = <-rch:
+		err = <-errch
+	}
+
+	// The created directories are dir and its parents, so they can be
+	// told apart by how far up from dir they are.
+	for _, local = range created {
+		var rel string
+		var levels int
+
+		rel, _ = filepath.Rel(local, dir)
+		if rel != "." {
+			levels = len(strings.Split(rel, string(filepath.Separator)))
+		}
+		urls = append(urls, parentURL(dirurl, levels))
+	}
+
+	return urls, urlError("mkdir", dirurl, err)
+}
+
+/*
+EnsureDir makes sure the directory pointed to exists, creating it along with
+any missing parents if necessary, and returns whether it had to be created.
+This makes it suitable for idempotent setup code which wants to know whether
+it is running for the first time. If the path or one of its parents exists
+but isn't a directory, the error wraps ErrNotDirectory. Like for
+MkdirAllReporting, the directories are created in a subthread.
+*/
+func (file *FileAdapter) EnsureDir(ctx context.Context, dirurl *url.URL) (created bool, err error) {
+	var dirs []*url.URL
+
+	dirs, err = file.MkdirAllReporting(ctx, dirurl)
+	if err != nil {
+		return false, err
+	}
+
+	// The directory itself is the last one to be created.
+	return len(dirs) > 0, nil
+}
@@ -0,0 +1,129 @@
+package file
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMkdirAllReporting(t *testing.T) {
+	var dir = testDir(t)
+	var created []*url.URL
+	var fi os.FileInfo
+	var err error
+
+	err = os.Mkdir(filepath.Join(dir, "a"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the directories below the existing one are new.
+	created, err = DefaultAdapter().MkdirAllReporting(context.Background(),
+		fileURL(filepath.Join(dir, "a", "b", "c")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(created, []*url.URL{
+		fileURL(filepath.Join(dir, "a", "b")),
+		fileURL(filepath.Join(dir, "a", "b", "c")),
+	}) {
+		t.Errorf("reported %v as created, want a/b and a/b/c", created)
+	}
+	fi, err = os.Stat(filepath.Join(dir, "a", "b", "c"))
+	if err != nil || !fi.IsDir() {
+		t.Errorf("a/b/c was not created: %v", err)
+	}
+
+	created, err = DefaultAdapter().MkdirAllReporting(context.Background(),
+		fileURL(filepath.Join(dir, "a", "b", "c")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 0 {
+		t.Errorf("reported %v as created, although everything existed", created)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "a", "file"), "file")
+	created, err = DefaultAdapter().MkdirAllReporting(context.Background(),
+		fileURL(filepath.Join(dir, "a", "file", "d")))
+	if !errors.Is(err, ErrNotDirectory) {
+		t.Errorf("creating below a file returned %v, want %v", err, ErrNotDirectory)
+	}
+	if len(created) != 0 {
+		t.Errorf("reported %v as created below a file", created)
+	}
+}
+
+func TestMkdirAllReportingBaseDir(t *testing.T) {
+	var dir = testDir(t)
+	var adapter = &FileAdapter{BaseDir: dir}
+	var created []*url.URL
+	var i int
+	var err error
+
+	// The URLs are relative to the base directory, not local paths.
+	created, err = adapter.MkdirAllReporting(context.Background(),
+		&url.URL{Scheme: "file", Path: "/a/b/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(created, []*url.URL{
+		{Scheme: "file", Path: "/a"},
+		{Scheme: "file", Path: "/a/b"},
+	}) {
+		t.Errorf("reported %v as created, want /a and /a/b", created)
+	}
+
+	// They can be used to roll back through the adapter.
+	for i = len(created) - 1; i >= 0; i-- {
+		err = adapter.Remove(context.Background(), created[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = os.Stat(filepath.Join(dir, "a"))
+	if !os.IsNotExist(err) {
+		t.Errorf("a still exists after rolling back: %v", err)
+	}
+}
+
+func TestEnsureDir(t *testing.T) {
+	var dir = testDir(t)
+	var created bool
+	var fi os.FileInfo
+	var err error
+
+	created, err = DefaultAdapter().EnsureDir(context.Background(), fileURL(filepath.Join(dir, "a", "b")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Error("new directory not reported as created")
+	}
+	fi, err = os.Stat(filepath.Join(dir, "a", "b"))
+	if err != nil || !fi.IsDir() {
+		t.Errorf("directory was not created: %v", err)
+	}
+
+	created, err = DefaultAdapter().EnsureDir(context.Background(), fileURL(filepath.Join(dir, "a", "b")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created {
+		t.Error("existing directory reported as created")
+	}
+
+	writeTestFile(t, filepath.Join(dir, "file"), "file")
+	created, err = DefaultAdapter().EnsureDir(context.Background(), fileURL(filepath.Join(dir, "file")))
+	if !errors.Is(err, ErrNotDirectory) {
+		t.Errorf("ensuring a regular file is a directory returned %v, want %v", err, ErrNotDirectory)
+	}
+	if created {
+		t.Error("regular file reported as created directory")
+	}
+	expectContents(t, filepath.Join(dir, "file"), "file")
+}
@@ -0,0 +1,205 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"io"
+	"net/url"
+	"os"
+)
+
+/*
+errMmapUnsupported is returned by mapFile on platforms which don't support
+memory mapping files.
+*/
+var errMmapUnsupported = errors.New("memory mapping is not supported")
+
+/*
+MappedFile is a read-only file whose contents have been mapped into memory.
+Reads are served directly from the mapping, so they neither require a system
+call nor a goroutine. Closing the file releases the mapping.
+*/
+type MappedFile struct {
+	name   string
+	data   []byte
+	offset int64
+	closed bool
+}
+
+/*
+Read() copies data from the current position in the mapping. Since this never
+blocks, the context is only checked for prior cancellation.
+*/
+func (m *MappedFile) Read(ctx context.Context, p []byte) (int, error) {
+	var n int
+
+	if m.closed {
+		return 0, os.ErrClosed
+	}
+
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	if m.offset >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+
+	n = copy(p, m.data[m.offset:])
+	m.offset += int64(n)
+	return n, nil
+}
+
+/*
+Tell() returns the current position in the mapping.
+*/
+func (m *MappedFile) Tell(ctx context.Context) (int64, error) {
+	if m.closed {
+		return 0, os.ErrClosed
+	}
+	return m.offset, nil
+}
+
+/*
+Seek() sets the current position in the mapping according to whence, as
+with io.Seeker. Seeking past the end is allowed; reads will return io.EOF.
+Like ContextRespectingIoFile, it fails with ErrNegativeOffset for positions
+before the start and with os.ErrInvalid for an unknown whence.
+*/
+func (m *MappedFile) Seek(ctx context.Context, offset int64, whence int) (int64, error) {
+	var abs int64
+
+	if m.closed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = m.offset + offset
+	case io.SeekEnd:
+		abs = int64(len(m.data)) + offset
+	default:
+		return 0, &os.PathError{Op: "seek", Path: m.name, Err: os.ErrInvalid}
+	}
+
+	if abs < 0 {
+		return 0, &os.PathError{Op: "seek", Path: m.name, Err: ErrNegativeOffset}
+	}
+
+	m.offset = abs
+	return abs, nil
+}
+
+/*
+Skip() skips forward by the specified number of bytes.
+*/
+func (m *MappedFile) Skip(ctx context.Context, n int64) error {
+	var err error
+	_, err = m.Seek(ctx, n, io.SeekCurrent)
+	return err
+}
+
+/*
+Close() releases the mapping. Any further calls will fail.
+*/
+func (m *MappedFile) Close(ctx context.Context) error {
+	var data = m.data
+
+	if m.closed {
+		return os.ErrClosed
+	}
+
+	m.closed = true
+	m.data = nil
+	if len(data) == 0 {
+		return nil
+	}
+	return unmapFile(data)
+}
+
+func asyncOpenMapped(fpath string, rchan chan filesystem.ReadCloser, errchan chan error) {
+	var file *os.File
+	var fi os.FileInfo
+	var data []byte
+	var err error
+
+	file, err = os.Open(fpath)
+	if err != nil {
+		errchan <- err
+		return
+	}
+
+	fi, err = file.Stat()
+	if err != nil {
+		file.Close()
+		errchan <- err
+		return
+	}
+	if fi.IsDir() {
+		file.Close()
+		errchan <- &os.PathError{Op: "open", Path: fpath, Err: ErrIsDirectory}
+		return
+	}
+
+	// Empty files cannot be mapped, but there's nothing to map anyway.
+	if fi.Size() == 0 {
+		file.Close()
+		rchan <- &MappedFile{name: fpath}
+		return
+	}
+
+	// The mapping stays valid after the file has been closed. If mapping
+	// isn't supported, OpenMappedReader opens a regular reader instead.
+	data, err = mapFile(file, fi.Size())
+	file.Close()
+	if err != nil {
+		errchan <- err
+		return
+	}
+
+	rchan <- &MappedFile{name: fpath, data: data}
+}
+
+/*
+OpenMappedReader creates a reader for the specified file which maps the file
+into memory rather than reading it through system calls, which is faster for
+large files which are read repeatedly. The file must not be truncated while
+it is mapped. On platforms which don't support memory mapping, a regular
+reader is returned instead, just as OpenReader would.
+*/
+func (file *FileAdapter) OpenMappedReader(
+	ctx context.Context, fileurl *url.URL) (rc filesystem.ReadCloser, err error) {
+	var rchan = make(chan filesystem.ReadCloser, 1)
+	var errchan = make(chan error, 1)
+	var cancel context.CancelFunc
+	var opctx context.Context
+	var fpath string
+
+	fpath, err = file.localPath(fileurl)
+	if err != nil {
+		return
+	}
+
+	opctx, cancel = file.operationContext(ctx)
+	defer cancel()
+
+	go asyncOpenMapped(fpath, rchan, errchan)
+	select {
+	case <-opctx.Done():
+		go discardOpenedReader(rchan, errchan)
+		err = opctx.Err()
+		return
+	case err = <-errchan:
+		if err == errMmapUnsupported {
+			return file.OpenReader(ctx, fileurl)
+		}
+		err = urlError("open", fileurl, err)
+		return
+	case rc = <-rchan:
+		return
+	}
+}
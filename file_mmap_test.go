@@ -0,0 +1,101 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMappedReaderMatchesReader(t *testing.T) {
+	var dir = testDir(t)
+	var data = make([]byte, 1<<20+123)
+	var i int
+
+	for i = range data {
+		data[i] = byte(i * 7)
+	}
+	writeTestFile(t, filepath.Join(dir, "large"), string(data))
+	writeTestFile(t, filepath.Join(dir, "empty"), "")
+
+	for _, name := range []string{"large", "empty"} {
+		var fileurl = fileURL(filepath.Join(dir, name))
+		var mapped, plain filesystem.ReadCloser
+		var err error
+
+		mapped, err = DefaultAdapter().OpenMappedReader(context.Background(), fileurl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err = DefaultAdapter().OpenReader(context.Background(), fileurl)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if readAndClose(t, mapped) != readAndClose(t, plain) {
+			t.Errorf("mapped reader returned different contents for %s", name)
+		}
+	}
+}
+
+func TestMappedReaderClose(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var r filesystem.ReadCloser
+	var err error
+
+	writeTestFile(t, fpath, "mapped")
+
+	r, err = DefaultAdapter().OpenMappedReader(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.Read(context.Background(), make([]byte, 10))
+	if !errors.Is(err, os.ErrClosed) {
+		t.Errorf("reading after Close returned %v, want %v", err, os.ErrClosed)
+	}
+	err = r.Close(context.Background())
+	if !errors.Is(err, os.ErrClosed) {
+		t.Errorf("closing twice returned %v, want %v", err, os.ErrClosed)
+	}
+
+	// The file can be removed once the mapping has been released.
+	err = os.Remove(fpath)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMappedReaderFallbackTimeout(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var adapter = &FileAdapter{OperationTimeout: time.Minute}
+	var r filesystem.ReadCloser
+	var f *ContextRespectingIoFile
+	var ok bool
+	var err error
+
+	writeTestFile(t, fpath, "mapped")
+
+	r, err = adapter.OpenMappedReader(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close(context.Background())
+
+	f, ok = r.(*ContextRespectingIoFile)
+	if !ok {
+		t.Skip("memory mapping is supported")
+	}
+	if f.timeout != adapter.OperationTimeout {
+		t.Errorf("fallback reader has a timeout of %v, want %v", f.timeout,
+			adapter.OperationTimeout)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package file
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+/*
+mapFile maps the first size bytes of the file into memory read-only. Files
+too large for the address space are reported as unsupported, so the caller
+falls back to regular reads.
+*/
+func mapFile(file *os.File, size int64) ([]byte, error) {
+	if int64(int(size)) != size {
+		return nil, errMmapUnsupported
+	}
+	return unix.Mmap(int(file.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+}
+
+/*
+unmapFile releases a mapping created by mapFile.
+*/
+func unmapFile(data []byte) error {
+	return unix.Munmap(data)
+}
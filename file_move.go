@@ -0,0 +1,64 @@
+package file
+
+import (
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+func asyncMoveInto(srcpath, dirpath string, errch chan error) {
+	var fi os.FileInfo
+	var err error
+
+	fi, err = os.Stat(dirpath)
+	if err != nil {
+		errch <- err
+		return
+	}
+	if !fi.IsDir() {
+		errch <- &os.PathError{Op: "rename", Path: dirpath, Err: ErrNotDirectory}
+		return
+	}
+
+	errch <- os.Rename(srcpath, filepath.Join(dirpath, filepath.Base(srcpath)))
+}
+
+/*
+MoveInto asynchronously moves the file or directory pointed to by srcurl into
+the directory pointed to by dsturl, keeping its name, like "mv src dir/".
+The destination has to be an existing directory; for anything else, an error
+wrapping ErrNotDirectory is returned. An existing file of the same name in
+the destination directory is replaced, as with os.Rename. The move is a
+rename, so both have to be on the same file system. The actual move will
+happen in a subthread so that we have a guaranteed response time from this
+function in case the operation exceeds the alotted time limits.
+*/
+func (file *FileAdapter) MoveInto(ctx context.Context, srcurl, dsturl *url.URL) error {
+	var errch = make(chan error, 1)
+	var cancel context.CancelFunc
+	var srcpath, dirpath string
+	var err error
+
+	srcpath, err = file.localPath(srcurl)
+	if err != nil {
+		return err
+	}
+
+	dirpath, err = file.localPath(dsturl)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel = file.operationContext(ctx)
+	defer cancel()
+
+	go asyncMoveInto(srcpath, dirpath, errch)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-errch:
+		return urlError("move", srcurl, err)
+	}
+}
@@ -0,0 +1,61 @@
+package file
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveInto(t *testing.T) {
+	var dir = testDir(t)
+	var err error
+
+	err = os.MkdirAll(filepath.Join(dir, "dst"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "file"), "file")
+	err = os.MkdirAll(filepath.Join(dir, "tree", "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "tree", "sub", "nested"), "nested")
+
+	for _, name := range []string{"file", "tree"} {
+		err = DefaultAdapter().MoveInto(context.Background(), fileURL(filepath.Join(dir, name)),
+			fileURL(filepath.Join(dir, "dst")))
+		if err != nil {
+			t.Fatalf("moving %s: %v", name, err)
+		}
+		_, err = os.Lstat(filepath.Join(dir, name))
+		if !os.IsNotExist(err) {
+			t.Errorf("%s is still in its old place: %v", name, err)
+		}
+	}
+	expectContents(t, filepath.Join(dir, "dst", "file"), "file")
+	expectContents(t, filepath.Join(dir, "dst", "tree", "sub", "nested"), "nested")
+}
+
+func TestMoveIntoFile(t *testing.T) {
+	var dir = testDir(t)
+	var err error
+
+	writeTestFile(t, filepath.Join(dir, "src"), "src")
+	writeTestFile(t, filepath.Join(dir, "dst"), "dst")
+
+	err = DefaultAdapter().MoveInto(context.Background(), fileURL(filepath.Join(dir, "src")),
+		fileURL(filepath.Join(dir, "dst")))
+	if !errors.Is(err, ErrNotDirectory) {
+		t.Errorf("moving into a regular file returned %v, want %v", err, ErrNotDirectory)
+	}
+	expectContents(t, filepath.Join(dir, "src"), "src")
+	expectContents(t, filepath.Join(dir, "dst"), "dst")
+
+	err = DefaultAdapter().MoveInto(context.Background(), fileURL(filepath.Join(dir, "src")),
+		fileURL(filepath.Join(dir, "missing")))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("moving into a missing directory returned %v, want %v", err, os.ErrNotExist)
+	}
+}
@@ -0,0 +1,88 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"net/url"
+)
+
+/*
+MultiWriter writes all data to several writers at once, like a tee.
+*/
+type MultiWriter struct {
+	writers []filesystem.WriteCloser
+}
+
+/*
+Write() writes the data to every writer in turn. If any of them fails, the
+errors of all failing writers are returned together, and the length is the
+smallest amount of data written to any of them.
+*/
+func (m *MultiWriter) Write(ctx context.Context, p []byte) (int, error) {
+	var writer filesystem.WriteCloser
+	var errs []error
+	var length = len(p)
+
+	for _, writer = range m.writers {
+		var n int
+		var err error
+
+		n, err = writer.Write(ctx, p)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if n < length {
+			length = n
+		}
+	}
+
+	return length, errors.Join(errs...)
+}
+
+/*
+Close() closes all writers, returning the errors of all writers which failed
+to close.
+*/
+func (m *MultiWriter) Close(ctx context.Context) error {
+	var writer filesystem.WriteCloser
+	var errs []error
+
+	for _, writer = range m.writers {
+		var err error
+
+		err = writer.Close(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+/*
+OpenMultiWriter opens all of the specified files for writing, like
+OpenWriter, and returns a writer which writes all data to every one of them.
+If any of the files cannot be opened, the ones which were already opened are
+closed again before the error is returned.
+*/
+func (file *FileAdapter) OpenMultiWriter(
+	ctx context.Context, urls ...*url.URL) (filesystem.WriteCloser, error) {
+	var ret = new(MultiWriter)
+	var fileurl *url.URL
+
+	for _, fileurl = range urls {
+		var writer filesystem.WriteCloser
+		var err error
+
+		writer, err = file.OpenWriter(ctx, fileurl)
+		if err != nil {
+			ret.Close(context.Background())
+			return nil, err
+		}
+		ret.writers = append(ret.writers, writer)
+	}
+
+	return ret, nil
+}
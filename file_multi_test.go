@@ -0,0 +1,117 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"path/filepath"
+	"testing"
+)
+
+/*
+failingWriter accepts the first limit bytes written to it and fails after.
+*/
+type failingWriter struct {
+	limit  int
+	closed bool
+}
+
+var errWriterFull = errors.New("writer full")
+
+func (w *failingWriter) Write(ctx context.Context, p []byte) (int, error) {
+	if len(p) > w.limit {
+		var n = w.limit
+
+		w.limit = 0
+		return n, errWriterFull
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func (w *failingWriter) Close(ctx context.Context) error {
+	w.closed = true
+	return nil
+}
+
+func TestMultiWriterTwoDestinations(t *testing.T) {
+	var dir = testDir(t)
+	var w filesystem.WriteCloser
+	var err error
+
+	w, err = DefaultAdapter().OpenMultiWriter(context.Background(),
+		fileURL(filepath.Join(dir, "a")), fileURL(filepath.Join(dir, "b")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range []string{"first ", "second"} {
+		_, err = w.Write(context.Background(), []byte(chunk))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = w.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectContents(t, filepath.Join(dir, "a"), "first second")
+	expectContents(t, filepath.Join(dir, "b"), "first second")
+}
+
+func TestMultiWriterFailingOpen(t *testing.T) {
+	var dir = testDir(t)
+	var before, after int
+	var counted bool
+	var err error
+
+	writeTestFile(t, filepath.Join(dir, "plain"), "plain")
+	before, counted = openDescriptors()
+
+	_, err = DefaultAdapter().OpenMultiWriter(context.Background(),
+		fileURL(filepath.Join(dir, "a")), fileURL(filepath.Join(dir, "plain", "b")))
+	if !errors.Is(err, ErrNotDirectory) {
+		t.Errorf("opening below a file returned %v, want %v", err, ErrNotDirectory)
+	}
+
+	// The file which could be opened was closed again.
+	after, _ = openDescriptors()
+	if counted && after != before {
+		t.Errorf("%d descriptors open after the failed open, %d before", after, before)
+	}
+}
+
+func TestMultiWriterFailingWrite(t *testing.T) {
+	var dir = testDir(t)
+	var good filesystem.WriteCloser
+	var bad = &failingWriter{limit: 8}
+	var w *MultiWriter
+	var n int
+	var err error
+
+	good, err = DefaultAdapter().OpenWriter(context.Background(), fileURL(filepath.Join(dir, "good")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = &MultiWriter{writers: []filesystem.WriteCloser{bad, good}}
+
+	n, err = w.Write(context.Background(), []byte("0123456789"))
+	if !errors.Is(err, errWriterFull) {
+		t.Errorf("write returned %v, want %v", err, errWriterFull)
+	}
+	if n != 8 {
+		t.Errorf("write reported %d bytes, want the 8 the failing writer took", n)
+	}
+
+	err = w.Close(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	if !bad.closed {
+		t.Error("failing writer not closed")
+	}
+
+	// The other destinations still received all data.
+	expectContents(t, filepath.Join(dir, "good"), "0123456789")
+}
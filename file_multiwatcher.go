@@ -0,0 +1,443 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"gopkg.in/fsnotify.v1"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*
+MultiWatcher watches several unrelated files with a single watch backend,
+reporting changes to any of them to the same callback. Like FileWatcher, it
+follows files which are deleted or replaced.
+*/
+type MultiWatcher struct {
+	cb       ContextFileWatchFunc
+	lifetime context.Context
+	cancel   context.CancelFunc
+	watcher  watchBackend
+	adapter  *FileAdapter
+
+	// targets maps the local paths of the watched files to their state.
+	targets map[string]*multiWatchTarget
+
+	// dirs counts the files waiting to be recreated in each directory which
+	// is watched for that purpose. The other files in these directories
+	// aren't watched individually in the meantime.
+	dirs map[string]int
+
+	errch        chan error
+	done         chan struct{}
+	finished     chan struct{}
+	shutdownOnce sync.Once
+
+	// callbacks tracks the callbacks reporting changes which are still
+	// running.
+	callbacks sync.WaitGroup
+}
+
+/*
+multiWatchTarget is a file watched by a MultiWatcher.
+*/
+type multiWatchTarget struct {
+	path       *url.URL
+	local      string
+	recreating bool
+}
+
+/*
+addTarget resolves the file pointed to and registers it with the watcher. It
+returns nil if the file is watched already through a different URL.
+*/
+func (m *MultiWatcher) addTarget(path *url.URL) (*multiWatchTarget, error) {
+	var target *multiWatchTarget
+	var fi os.FileInfo
+	var local string
+	var err error
+
+	local, err = m.adapter.localPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	local, err = resolveSymlinks(local)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err = os.Stat(local)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, &os.PathError{Op: "watch", Path: local, Err: ErrIsDirectory}
+	}
+
+	// The same file may be reached through several URLs.
+	if m.targets[local] != nil {
+		return nil, nil
+	}
+
+	err = m.watcher.Add(local)
+	if err != nil {
+		return nil, err
+	}
+
+	target = &multiWatchTarget{path: path, local: local}
+	m.targets[local] = target
+	return target, nil
+}
+
+/*
+openInitial opens readers for the initial state of all targets. If any of
+them cannot be opened, the readers opened so far are closed again and the
+error is returned along with the URL of the target at fault.
+*/
+func (m *MultiWatcher) openInitial(ctx context.Context, targets []*multiWatchTarget) (
+	[]filesystem.ReadCloser, *url.URL, error) {
+	var readers []filesystem.ReadCloser
+	var reader filesystem.ReadCloser
+	var target *multiWatchTarget
+	var err error
+
+	for _, target = range targets {
+		reader, err = m.adapter.OpenReader(ctx, target.path)
+		if err != nil {
+			for _, reader = range readers {
+				reader.Close(context.Background())
+			}
+			return nil, target.path, err
+		}
+		readers = append(readers, reader)
+	}
+
+	return readers, nil, nil
+}
+
+/*
+watchForChanges is invoked asynchronously and reports changes to any of the
+watched files to the callback. It is the only sender on the error channel,
+which is closed as soon as it returns.
+*/
+func (m *MultiWatcher) watchForChanges() {
+	var ctx = m.lifetime
+
+	defer close(m.finished)
+	defer close(m.errch)
+
+	for {
+		var event fsnotify.Event
+		var target *multiWatchTarget
+		var reader filesystem.ReadCloser
+		var err error
+		var ok bool
+
+		select {
+		case <-m.done:
+			return
+		case err, ok = <-m.watcher.Errors():
+			if !ok {
+				return
+			}
+			m.reportError(err)
+		case event, ok = <-m.watcher.Events():
+			if !ok {
+				return
+			}
+
+			// Events for other files in directories watched while
+			// waiting for a file to be recreated are ignored.
+			target = m.targets[filepath.Clean(event.Name)]
+			if target == nil || !m.follow(target, event) {
+				continue
+			}
+
+			reader, err = m.adapter.OpenReader(ctx, target.path)
+			if err == nil {
+				m.callbacks.Add(1)
+				go func() {
+					defer m.callbacks.Done()
+					m.cb(ctx, target.path, reader)
+				}()
+			} else {
+				m.reportError(err)
+			}
+		}
+	}
+}
+
+/*
+follow keeps track of the target file if it is deleted or replaced, like
+FileWatcher.followFile does, and determines whether event indicates a
+change to be reported.
+*/
+func (m *MultiWatcher) follow(target *multiWatchTarget, event fsnotify.Event) bool {
+	if target.recreating {
+		if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+			return false
+		}
+		return m.rewatch(target)
+	}
+
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		return m.rewatch(target)
+	}
+
+	return event.Op&fsnotify.Write != 0
+}
+
+/*
+rewatch watches the path of the target file again, returning whether that
+worked. If there's no file at the path anymore, its directory is watched
+instead until the file comes back; the directory watch is shared between
+all targets in the same directory. While the directory is watched, it also
+reports the changes to the other targets in it, so their own watches are
+dropped until it is released; otherwise every change to them would be
+reported twice.
+*/
+func (m *MultiWatcher) rewatch(target *multiWatchTarget) bool {
+	var dir = filepath.Dir(target.local)
+	var other *multiWatchTarget
+	var err error
+
+	if m.dirs[dir] > 0 {
+		// The directory watch covers the target already.
+		_, err = os.Stat(target.local)
+		if err == nil {
+			if target.recreating {
+				target.recreating = false
+				m.releaseDir(dir)
+			}
+			return true
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			m.reportError(err)
+			return false
+		}
+		if !target.recreating {
+			target.recreating = true
+			m.dirs[dir]++
+		}
+		return false
+	}
+
+	err = m.watcher.Add(target.local)
+	if err == nil {
+		return true
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		m.reportError(err)
+		return false
+	}
+
+	err = m.watcher.Add(dir)
+	if err != nil {
+		m.reportError(err)
+		return false
+	}
+	m.dirs[dir]++
+	target.recreating = true
+
+	for _, other = range m.targets {
+		if !other.recreating && filepath.Dir(other.local) == dir {
+			m.watcher.Remove(other.local)
+		}
+	}
+
+	// The file may have been recreated before the directory was watched.
+	return m.rewatch(target)
+}
+
+/*
+releaseDir stops watching the directory dir once no file in it is waiting to
+be recreated anymore, watching the files in it individually again. If one of
+them has disappeared in the meantime, it is waited for in turn and the
+directory stays watched.
+*/
+func (m *MultiWatcher) releaseDir(dir string) {
+	var added []string
+	var other *multiWatchTarget
+	var local string
+	var err error
+
+	m.dirs[dir]--
+	if m.dirs[dir] > 0 {
+		return
+	}
+
+	for _, other = range m.targets {
+		if other.recreating || filepath.Dir(other.local) != dir {
+			continue
+		}
+
+		err = m.watcher.Add(other.local)
+		if errors.Is(err, os.ErrNotExist) {
+			other.recreating = true
+			m.dirs[dir]++
+		} else if err != nil {
+			m.reportError(err)
+		} else {
+			added = append(added, other.local)
+		}
+	}
+
+	if m.dirs[dir] > 0 {
+		for _, local = range added {
+			m.watcher.Remove(local)
+		}
+		return
+	}
+
+	delete(m.dirs, dir)
+	m.watcher.Remove(dir)
+}
+
+/*
+reportError hands err to whoever is reading the error channel, unless the
+watcher is being shut down in the meantime, in which case it is dropped.
+*/
+func (m *MultiWatcher) reportError(err error) {
+	select {
+	case m.errch <- err:
+	case <-m.done:
+	}
+}
+
+/*
+Shutdown stops watching all of the files and shuts down the asynchronous
+change watching thread. Once it returns, no more errors will be reported and
+the error channel has been closed. The context passed to callbacks is
+cancelled, but callbacks still running are not waited for; use
+ShutdownAndWait for that. Calling Shutdown more than once is harmless.
+*/
+func (m *MultiWatcher) Shutdown() error {
+	var err error
+
+	m.shutdownOnce.Do(func() {
+		m.cancel()
+		close(m.done)
+
+		// Wait for the change watching thread to stop touching the
+		// watches before removing them.
+		<-m.finished
+
+		m.removeAll()
+		err = m.watcher.Close()
+	})
+
+	return err
+}
+
+/*
+removeAll removes the watches for all files and directories. Errors are
+ignored, since the watches of files which were deleted are gone already.
+*/
+func (m *MultiWatcher) removeAll() {
+	var local string
+	var target *multiWatchTarget
+
+	for local, target = range m.targets {
+		if !target.recreating {
+			m.watcher.Remove(local)
+		}
+	}
+	for local = range m.dirs {
+		m.watcher.Remove(local)
+	}
+}
+
+/*
+abandon releases the watches set up so far by a WatchMultiple call which
+failed, before the change watching thread was started.
+*/
+func (m *MultiWatcher) abandon() {
+	m.cancel()
+	m.removeAll()
+	m.watcher.Close()
+}
+
+/*
+ShutdownAndWait works like FileWatcher.ShutdownAndWait.
+*/
+func (m *MultiWatcher) ShutdownAndWait(ctx context.Context) error {
+	var err error
+
+	err = m.Shutdown()
+	return combineErrors(err, waitForCallbacks(ctx, &m.callbacks))
+}
+
+/*
+Accessor method to get the error reporting channel. The channel is closed
+when the watcher shuts down.
+*/
+func (m *MultiWatcher) ErrChan() chan error {
+	return m.errch
+}
+
+/*
+WatchMultiple watches all of the files pointed to for changes, reporting them
+to notify like WatchFile does for a single file, but using only one watch
+backend and one error channel for all of them. The current state of every
+file is reported initially, once all of them have been set up. Directories
+are not supported. If any of the files cannot be watched or opened for the
+initial report, none of them are watched and notify isn't called at all.
+*/
+func (file *FileAdapter) WatchMultiple(ctx context.Context, urls []*url.URL,
+	notify filesystem.FileWatchFunc) (filesystem.CancelWatchFunc, chan error, error) {
+	var m *MultiWatcher
+	var targets []*multiWatchTarget
+	var target *multiWatchTarget
+	var readers []filesystem.ReadCloser
+	var path *url.URL
+	var i int
+	var err error
+
+	m = &MultiWatcher{
+		cb:       withoutContext(notify),
+		adapter:  file,
+		targets:  make(map[string]*multiWatchTarget),
+		dirs:     make(map[string]int),
+		errch:    make(chan error),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+
+	m.watcher, err = file.openWatchBackend()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m.lifetime, m.cancel = context.WithCancel(context.Background())
+
+	for _, path = range urls {
+		target, err = m.addTarget(path)
+		if err != nil {
+			m.abandon()
+			return nil, nil, urlError("watch", path, err)
+		}
+		if target != nil {
+			targets = append(targets, target)
+		}
+	}
+
+	readers, path, err = m.openInitial(ctx, targets)
+	if err != nil {
+		m.abandon()
+		return nil, nil, urlError("watch", path, err)
+	}
+
+	// The current state of the files is reported as the first change.
+	for i, target = range targets {
+		m.cb(m.lifetime, target.path, readers[i])
+	}
+
+	go m.watchForChanges()
+
+	return m.Shutdown, m.errch, nil
+}
@@ -0,0 +1,188 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"gopkg.in/fsnotify.v1"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchMultipleReportsEachFile(t *testing.T) {
+	var dir = testDir(t)
+	var backend = newFakeWatchBackend()
+	var urls []*url.URL
+	var reports = make(chan [2]string, 10)
+	var reported = make(map[string][]string)
+	var cancel filesystem.CancelWatchFunc
+	var name string
+	var i int
+	var err error
+
+	for _, name = range []string{"a", "b", "c"} {
+		writeTestFile(t, filepath.Join(dir, name), name+"1")
+		urls = append(urls, fileURL(filepath.Join(dir, name)))
+	}
+
+	cancel, _, err = backend.adapter().WatchMultiple(context.Background(), urls,
+		func(path *url.URL, r filesystem.ReadCloser) {
+			reports <- [2]string{filepath.Base(path.Path), readAndClose(t, r)}
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	for _, name = range []string{"a", "b", "c"} {
+		if !backend.isWatched(filepath.Join(dir, name)) {
+			t.Errorf("%s is not watched", name)
+		}
+		writeTestFile(t, filepath.Join(dir, name), name+"2")
+		backend.send(t, filepath.Join(dir, name), fsnotify.Write)
+	}
+
+	for i = 0; i < 6; i++ {
+		var report [2]string
+
+		select {
+		case report = <-reports:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d changes reported: %v", i, reported)
+		}
+		reported[report[0]] = append(reported[report[0]], report[1])
+	}
+
+	// The initial state is reported synchronously, before any changes.
+	for _, name = range []string{"a", "b", "c"} {
+		if len(reported[name]) != 2 || reported[name][0] != name+"1" || reported[name][1] != name+"2" {
+			t.Errorf("reported %v for %s, want [%s1 %s2]", reported[name], name, name, name)
+		}
+	}
+}
+
+func TestWatchMultipleSiblingOfRecreatedFile(t *testing.T) {
+	var dir = testDir(t)
+	var backend = newFakeWatchBackend()
+	var a = filepath.Join(dir, "a")
+	var b = filepath.Join(dir, "b")
+	var reports = make(chan string, 10)
+	var cancel filesystem.CancelWatchFunc
+	var err error
+
+	writeTestFile(t, a, "a1")
+	writeTestFile(t, b, "b1")
+
+	cancel, _, err = backend.adapter().WatchMultiple(context.Background(),
+		[]*url.URL{fileURL(a), fileURL(b)},
+		func(path *url.URL, r filesystem.ReadCloser) {
+			reports <- readAndClose(t, r)
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	for _, want := range []string{"a1", "b1"} {
+		if got := <-reports; got != want {
+			t.Fatalf("initially reported %q, want %q", got, want)
+		}
+	}
+
+	// While a is gone, the directory is watched for it to come back.
+	err = os.Remove(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.sendWatched(t, a, fsnotify.Remove)
+
+	// Changes to b must still be reported once only.
+	writeTestFile(t, b, "b2")
+	backend.sendWatched(t, b, fsnotify.Write)
+	expectReports(t, reports, "b2")
+
+	writeTestFile(t, a, "a2")
+	backend.sendWatched(t, a, fsnotify.Create)
+	expectReports(t, reports, "a2")
+
+	// Once a is back, both files are watched individually again.
+	if backend.isWatched(dir) {
+		t.Errorf("%s is still watched after a was recreated", dir)
+	}
+	for _, fpath := range []string{a, b} {
+		if !backend.isWatched(fpath) {
+			t.Errorf("%s is not watched after a was recreated", fpath)
+		}
+	}
+
+	writeTestFile(t, b, "b3")
+	backend.sendWatched(t, b, fsnotify.Write)
+	expectReports(t, reports, "b3")
+}
+
+/*
+expectReports waits for the contents want to be reported, in any order, and
+fails if anything else is reported shortly afterwards.
+*/
+func expectReports(t *testing.T, reports chan string, want ...string) {
+	var pending = make(map[string]int)
+	var got string
+	var name string
+
+	t.Helper()
+
+	for _, name = range want {
+		pending[name]++
+	}
+
+	for range want {
+		select {
+		case got = <-reports:
+			if pending[got] == 0 {
+				t.Errorf("unexpectedly reported %q", got)
+			}
+			pending[got]--
+		case <-time.After(5 * time.Second):
+			t.Fatalf("not all of %v reported", want)
+		}
+	}
+
+	select {
+	case got = <-reports:
+		t.Errorf("unexpectedly reported %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWatchMultipleFailsAtomically(t *testing.T) {
+	var dir = testDir(t)
+	var backend = newFakeWatchBackend()
+	var called bool
+	var err error
+
+	writeTestFile(t, filepath.Join(dir, "a"), "a")
+	writeTestFile(t, filepath.Join(dir, "c"), "c")
+
+	_, _, err = backend.adapter().WatchMultiple(context.Background(), []*url.URL{
+		fileURL(filepath.Join(dir, "a")),
+		fileURL(filepath.Join(dir, "missing")),
+		fileURL(filepath.Join(dir, "c")),
+	}, func(path *url.URL, r filesystem.ReadCloser) {
+		called = true
+		r.Close(context.Background())
+	})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("watching a missing file returned %v, want %v", err, os.ErrNotExist)
+	}
+
+	if called {
+		t.Error("initial state reported although the watch failed")
+	}
+	if backend.isWatched(filepath.Join(dir, "a")) {
+		t.Error("watch on the first file left behind")
+	}
+}
@@ -0,0 +1,44 @@
+//go:build !unix
+
+package file
+
+import (
+	"os"
+	"syscall"
+)
+
+/*
+openNoFollow opens the file at fpath for reading, failing if it is a symbolic
+link. There is no O_NOFOLLOW on this platform, so the check is done with
+Lstat beforehand, and the opened file is compared against it to catch the
+path being swapped for a link in between.
+*/
+func openNoFollow(fpath string) (*os.File, error) {
+	var fi, ofi os.FileInfo
+	var f *os.File
+	var err error
+
+	fi, err = os.Lstat(fpath)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode()&os.ModeSymlink == os.ModeSymlink {
+		return nil, &os.PathError{Op: "open", Path: fpath, Err: syscall.ELOOP}
+	}
+
+	f, err = os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	ofi, err = f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !os.SameFile(fi, ofi) {
+		f.Close()
+		return nil, &os.PathError{Op: "open", Path: fpath, Err: syscall.ELOOP}
+	}
+	return f, nil
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package file
+
+import (
+	"os"
+	"syscall"
+)
+
+/*
+openNoFollow opens the file at fpath for reading, failing with ELOOP if it is
+a symbolic link.
+*/
+func openNoFollow(fpath string) (*os.File, error) {
+	return os.OpenFile(fpath, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+}
@@ -0,0 +1,104 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+ErrUnsupportedScheme is returned when a URL with a scheme other than "file" is
+passed to the file adapter.
+*/
+var ErrUnsupportedScheme = errors.New("unsupported URL scheme")
+
+/*
+ErrRemoteHost is returned when a file URL names a host other than localhost;
+only files on the local machine can be accessed.
+*/
+var ErrRemoteHost = errors.New("remote hosts are not supported")
+
+/*
+ErrPathEscapesBase is returned by adapters with a BaseDir when a URL refers
+to a path outside of the base directory, e.g. through "..".
+*/
+var ErrPathEscapesBase = errors.New("path escapes the base directory")
+
+/*
+localPath determines the local file system path referred to by the specified
+URL. URLs without any scheme are accepted as local paths as well. The host
+part must either be empty (as in file:///etc/passwd) or "localhost". The path
+is used in its percent-decoded form, so file:///a%20b refers to "/a b".
+Opaque URLs such as file:a/b are interpreted as paths relative to the current
+working directory.
+
+On Windows, drive letter paths like file:///C:/foo refer to C:\foo, and URLs
+naming a host like file://server/share refer to the UNC path \\server\share.
+
+If the adapter has a BaseDir, all paths, including absolute ones, are taken
+to be relative to it, and paths leading outside of it are rejected.
+*/
+func (file *FileAdapter) localPath(fileurl *url.URL) (string, error) {
+	var fpath string
+	var err error
+
+	if fileurl.Scheme != "" && fileurl.Scheme != "file" {
+		return "", fmt.Errorf("%s: %w %q, expected \"file\"",
+			fileurl.Redacted(), ErrUnsupportedScheme, fileurl.Scheme)
+	}
+
+	fpath = fileurl.Path
+	if fileurl.Opaque != "" {
+		fpath, err = url.PathUnescape(fileurl.Opaque)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if fileurl.Host != "" && fileurl.Hostname() != "localhost" {
+		if file.BaseDir != "" {
+			err = ErrRemoteHost
+		} else {
+			fpath, err = hostPath(fileurl.Host, fpath)
+		}
+		if err != nil {
+			return "", fmt.Errorf("%s: %w (host %q)", fileurl.Redacted(),
+				err, fileurl.Host)
+		}
+		return fpath, nil
+	}
+
+	if file.BaseDir != "" {
+		return rootedPath(file.BaseDir, fpath)
+	}
+
+	return nativePath(fpath), nil
+}
+
+/*
+rootedPath interprets fpath relative to the directory base, making sure that
+the result does not end up outside of base after resolving any ".."
+components.
+*/
+func rootedPath(base, fpath string) (string, error) {
+	var joined string
+	var rel string
+	var err error
+
+	base = filepath.Clean(base)
+	joined = filepath.Join(base, filepath.FromSlash(fpath))
+
+	rel, err = filepath.Rel(base, joined)
+	if err != nil {
+		return "", err
+	}
+
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &os.PathError{Op: "resolve", Path: fpath, Err: ErrPathEscapesBase}
+	}
+
+	return joined, nil
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package file
+
+/*
+nativePath converts the path of a file URL into a local path, which on POSIX
+systems is the path itself.
+*/
+func nativePath(fpath string) string {
+	return fpath
+}
+
+/*
+hostPath would convert a file URL naming a host into a local path, but
+there's no way to reach files on other hosts this way outside of Windows.
+*/
+func hostPath(host, fpath string) (string, error) {
+	return "", ErrRemoteHost
+}
@@ -0,0 +1,145 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestUnsupportedScheme(t *testing.T) {
+	var u = &url.URL{Scheme: "s3", Host: "bucket", Path: "/key"}
+	var err error
+
+	_, err = DefaultAdapter().OpenReader(context.Background(), u)
+	if !errors.Is(err, ErrUnsupportedScheme) {
+		t.Fatalf("opening %s returned %v, want %v", u, err, ErrUnsupportedScheme)
+	}
+	if !strings.Contains(err.Error(), `"s3"`) || !strings.Contains(err.Error(), "s3://bucket/key") {
+		t.Errorf("error %q names neither the scheme nor the URL", err)
+	}
+
+	err = DefaultAdapter().Remove(context.Background(), u)
+	if !errors.Is(err, ErrUnsupportedScheme) {
+		t.Errorf("removing %s returned %v, want %v", u, err, ErrUnsupportedScheme)
+	}
+}
+
+func TestPathErrorsRedactPasswords(t *testing.T) {
+	var err error
+
+	for _, u := range []*url.URL{
+		{Scheme: "s3", User: url.UserPassword("user", "hunter2"), Host: "bucket", Path: "/key"},
+		{Scheme: "file", User: url.UserPassword("user", "hunter2"), Host: "example.com", Path: "/key"},
+	} {
+		_, err = DefaultAdapter().localPath(u)
+		if err == nil {
+			t.Errorf("mapping %s succeeded", u.Redacted())
+		} else if strings.Contains(err.Error(), "hunter2") {
+			t.Errorf("error %q reveals the password", err)
+		}
+	}
+}
+
+/*
+openURL opens u for reading through the default adapter.
+*/
+func openURL(t *testing.T, u *url.URL) filesystem.ReadCloser {
+	var r filesystem.ReadCloser
+	var err error
+
+	t.Helper()
+
+	r, err = DefaultAdapter().OpenReader(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestURLHosts(t *testing.T) {
+	var dir = testDir(t)
+	var u *url.URL
+	var err error
+
+	writeTestFile(t, filepath.Join(dir, "a b"), "spaced")
+
+	u = fileURL(filepath.Join(dir, "a b"))
+	u.Host = "localhost"
+	if got := readAndClose(t, openURL(t, u)); got != "spaced" {
+		t.Errorf("read %q through %s, want %q", got, u, "spaced")
+	}
+
+	// Paths are percent-decoded.
+	u, err = url.Parse(fileURL(dir).String() + "/a%20b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAndClose(t, openURL(t, u)); got != "spaced" {
+		t.Errorf("read %q through %s, want %q", got, u, "spaced")
+	}
+
+	if runtime.GOOS == "windows" {
+		// Hosts name UNC paths here.
+		return
+	}
+	u = fileURL(filepath.Join(dir, "a b"))
+	u.Host = "example.com"
+	_, err = DefaultAdapter().OpenReader(context.Background(), u)
+	if !errors.Is(err, ErrRemoteHost) {
+		t.Errorf("opening %s returned %v, want %v", u, err, ErrRemoteHost)
+	}
+}
+
+func TestRootedFileAdapter(t *testing.T) {
+	var dir = testDir(t)
+	var base = filepath.Join(dir, "base")
+	var rooted = NewRootedFileAdapter(base)
+	var r filesystem.ReadCloser
+	var u *url.URL
+	var err error
+
+	err = os.MkdirAll(filepath.Join(base, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(base, "sub", "inside"), "inside")
+	writeTestFile(t, filepath.Join(dir, "outside"), "outside")
+
+	for _, path := range []string{"/sub/inside", "sub/inside", "/sub/../sub/inside"} {
+		r, err = rooted.OpenReader(context.Background(), &url.URL{Path: path})
+		if err != nil {
+			t.Errorf("opening %s: %v", path, err)
+			continue
+		}
+		if got := readAndClose(t, r); got != "inside" {
+			t.Errorf("read %q through %s, want %q", got, path, "inside")
+		}
+	}
+
+	// Absolute paths are relative to the base as well, not the root.
+	u = fileURL(filepath.Join(dir, "outside"))
+	_, err = rooted.OpenReader(context.Background(), u)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("opening %s returned %v, want %v", u, err, os.ErrNotExist)
+	}
+
+	for _, path := range []string{"/../outside", "sub/../../outside", ".."} {
+		_, err = rooted.OpenReader(context.Background(), &url.URL{Path: path})
+		if !errors.Is(err, ErrPathEscapesBase) {
+			t.Errorf("opening %s returned %v, want %v", path, err, ErrPathEscapesBase)
+		}
+	}
+
+	err = rooted.Remove(context.Background(), &url.URL{Path: "../outside"})
+	if !errors.Is(err, ErrPathEscapesBase) {
+		t.Errorf("removing ../outside returned %v, want %v", err, ErrPathEscapesBase)
+	}
+	expectContents(t, filepath.Join(dir, "outside"), "outside")
+}
@@ -0,0 +1,28 @@
+package file
+
+import (
+	"path/filepath"
+)
+
+/*
+nativePath converts the path of a file URL into a Windows path, dropping the
+slash in front of drive letters (so /C:/foo becomes C:\foo).
+*/
+func nativePath(fpath string) string {
+	if len(fpath) >= 3 && fpath[0] == '/' && fpath[2] == ':' && isDriveLetter(fpath[1]) {
+		fpath = fpath[1:]
+	}
+	return filepath.FromSlash(fpath)
+}
+
+/*
+hostPath converts a file URL naming a host into the corresponding UNC path,
+so file://server/share/foo becomes \\server\share\foo.
+*/
+func hostPath(host, fpath string) (string, error) {
+	return `\\` + host + filepath.FromSlash(fpath), nil
+}
+
+func isDriveLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
@@ -0,0 +1,57 @@
+package file
+
+import (
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalPathWindows(t *testing.T) {
+	var file = DefaultAdapter()
+
+	for _, tc := range []struct {
+		url  string
+		want string
+	}{
+		{"file:///C:/foo/bar", `C:\foo\bar`},
+		{"file:///c:/foo", `c:\foo`},
+		{"file://localhost/C:/foo", `C:\foo`},
+		{"file:///C:/a%20b", `C:\a b`},
+		{"file://server/share/foo", `\\server\share\foo`},
+		{"file://server/share", `\\server\share`},
+		// Without a drive letter, the path is relative to the current drive.
+		{"file:///foo/bar", `\foo\bar`},
+		{"/C:/foo", `C:\foo`},
+	} {
+		var u *url.URL
+		var got string
+		var err error
+
+		u, err = url.Parse(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = file.localPath(u)
+		if err != nil {
+			t.Errorf("resolving %s: %v", tc.url, err)
+		} else if got != tc.want {
+			t.Errorf("%s refers to %s, want %s", tc.url, got, tc.want)
+		}
+	}
+}
+
+func TestDriveLetterURL(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var u *url.URL
+	var err error
+
+	writeTestFile(t, fpath, "windows")
+
+	u, err = url.Parse("file:///" + filepath.ToSlash(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAndClose(t, openURL(t, u)); got != "windows" {
+		t.Errorf("read %q through %s, want %q", got, u, "windows")
+	}
+}
@@ -0,0 +1,78 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+)
+
+func (f *ContextRespectingIoFile) asyncPreallocate(size int64, errch chan error) {
+	errch <- preallocate(f.actualFile, size)
+}
+
+/*
+preallocate reserves size bytes for the open file in a subthread, with support
+for cancelling the operation or providing deadlines for it.
+*/
+func (f *ContextRespectingIoFile) preallocate(ctx context.Context, size int64) error {
+	var errch = make(chan error, 1)
+	var opctx context.Context
+	var cancel context.CancelFunc
+	var err error
+
+	err = f.checkUsable()
+	if err != nil {
+		return err
+	}
+
+	// There's nothing to allocate, and fallocate rejects empty ranges.
+	if size == 0 {
+		return nil
+	}
+
+	opctx, cancel = f.operationContext(ctx)
+	defer cancel()
+
+	go f.asyncPreallocate(size, errch)
+
+	select {
+	case <-opctx.Done():
+		return f.operationAborted(ctx, opctx)
+	case err = <-errch:
+		return err
+	}
+}
+
+/*
+OpenWriterPreallocated works like OpenWriter, but makes the new file size
+bytes large before returning it. Where the operating system supports it, the
+space is actually allocated with fallocate, so later writes within the file
+cannot fail for lack of disk space; elsewhere, the file is merely extended
+with Truncate, which may leave it sparse. Writes start at the beginning of
+the file. A size of zero just creates an empty file; negative sizes are
+rejected with an error wrapping os.ErrInvalid before anything is created.
+*/
+func (file *FileAdapter) OpenWriterPreallocated(
+	ctx context.Context, fileurl *url.URL, size int64) (filesystem.WriteCloser, error) {
+	var f *ContextRespectingIoFile
+	var err error
+
+	if size < 0 {
+		return nil, urlError("open", fileurl, os.ErrInvalid)
+	}
+
+	f, err = file.openForWriting(ctx, fileurl, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.preallocate(ctx, size)
+	if err != nil {
+		f.Close(context.Background())
+		return nil, urlError("allocate", fileurl, err)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,38 @@
+package file
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"os"
+	"syscall"
+)
+
+/*
+preallocate allocates size bytes of disk space for the file using fallocate,
+extending it to that size. File systems which don't support fallocate get
+the file truncated to the size instead.
+*/
+func preallocate(file *os.File, size int64) error {
+	var conn syscall.RawConn
+	var err error
+	var aerr error
+
+	conn, err = file.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	err = conn.Control(func(fd uintptr) {
+		aerr = unix.Fallocate(int(fd), 0, 0, size)
+	})
+	if err != nil {
+		return err
+	}
+
+	if errors.Is(aerr, unix.EOPNOTSUPP) || errors.Is(aerr, unix.ENOSYS) {
+		return file.Truncate(size)
+	} else if aerr != nil {
+		return &os.PathError{Op: "fallocate", Path: file.Name(), Err: aerr}
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package file
+
+import (
+	"os"
+)
+
+/*
+preallocate extends the file to size bytes. There is no fallocate on this
+platform, so no disk space is actually reserved.
+*/
+func preallocate(file *os.File, size int64) error {
+	return file.Truncate(size)
+}
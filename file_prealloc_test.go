@@ -0,0 +1,56 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenWriterPreallocatedSize(t *testing.T) {
+	var dir = testDir(t)
+	var size int64
+
+	for _, size = range []int64{0, 1, 1 << 20} {
+		var fpath = filepath.Join(dir, "prealloc")
+		var w filesystem.WriteCloser
+		var fi os.FileInfo
+		var err error
+
+		w, err = DefaultAdapter().OpenWriterPreallocated(context.Background(), fileURL(fpath), size)
+		if err != nil {
+			t.Fatalf("preallocating %d bytes: %v", size, err)
+		}
+
+		fi, err = os.Stat(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() != size {
+			t.Errorf("file preallocated with %d bytes has size %d", size, fi.Size())
+		}
+
+		err = w.Close(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestOpenWriterPreallocatedNegativeSize(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "prealloc")
+	var err error
+
+	_, err = DefaultAdapter().OpenWriterPreallocated(context.Background(), fileURL(fpath), -1)
+	if !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("negative size returned %v, want %v", err, os.ErrInvalid)
+	}
+
+	_, err = os.Stat(fpath)
+	if !os.IsNotExist(err) {
+		t.Errorf("file created despite the invalid size: %v", err)
+	}
+}
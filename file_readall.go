@@ -0,0 +1,106 @@
+package file
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"io"
+	"math"
+	"net/url"
+	"os"
+)
+
+/*
+ErrFileTooLarge is returned by ReadFileLimited for files exceeding the limit.
+*/
+var ErrFileTooLarge = errors.New("file too large")
+
+func asyncReadFileLimited(fpath string, max int64, rch chan []byte, errch chan error) {
+	var f *os.File
+	var fi os.FileInfo
+	var data []byte
+	var limit = max
+	var err error
+
+	f, err = os.Open(fpath)
+	if err != nil {
+		errch <- err
+		return
+	}
+	defer f.Close()
+
+	fi, err = f.Stat()
+	if err != nil {
+		errch <- err
+		return
+	}
+	if fi.IsDir() {
+		errch <- &os.PathError{Op: "read", Path: fpath, Err: ErrIsDirectory}
+		return
+	}
+	if fi.Size() > max {
+		errch <- &os.PathError{Op: "read", Path: fpath, Err: ErrFileTooLarge}
+		return
+	}
+
+	// The file may have grown since the stat, so read at most one byte more
+	// than allowed to find out whether it did. A limit of math.MaxInt64 can't
+	// be exceeded anyway and mustn't overflow.
+	if limit < math.MaxInt64 {
+		limit++
+	}
+	data, err = io.ReadAll(io.LimitReader(f, limit))
+	if err != nil {
+		errch <- err
+		return
+	}
+	if int64(len(data)) > max {
+		errch <- &os.PathError{Op: "read", Path: fpath, Err: ErrFileTooLarge}
+		return
+	}
+	rch <- data
+}
+
+/*
+ReadFileLimited reads the entire contents of the file pointed to into memory,
+as long as it is no larger than max bytes. Larger files are rejected with an
+error wrapping ErrFileTooLarge instead of being read, so that untrusted paths
+cannot be used to exhaust memory; files of exactly max bytes are still read in
+full. A max of math.MaxInt64 effectively removes the limit, while negative
+limits are rejected. The limit is checked against the size of the file up
+front and enforced again while reading, in case the file grows in the
+meantime. The actual reading will happen in a subthread so that we have a
+guaranteed response time from this function in case the operation exceeds the
+alotted time limits.
+*/
+func (file *FileAdapter) ReadFileLimited(ctx context.Context, fileurl *url.URL, max int64) (
+	[]byte, error) {
+	var rch = make(chan []byte, 1)
+	var errch = make(chan error, 1)
+	var cancel context.CancelFunc
+	var data []byte
+	var fpath string
+	var err error
+
+	if max < 0 {
+		return nil, urlError("read", fileurl, os.ErrInvalid)
+	}
+
+	fpath, err = file.localPath(fileurl)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel = file.operationContext(ctx)
+	defer cancel()
+
+	go asyncReadFileLimited(fpath, max, rch, errch)
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case err = <-errch:
+		return nil, urlError("read", fileurl, err)
+	case data = <-rch:
+		return data, nil
+	}
+}
@@ -0,0 +1,56 @@
+package file
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadFileLimited(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var data []byte
+	var err error
+
+	writeTestFile(t, fpath, "0123456789")
+
+	for _, max := range []int64{math.MaxInt64, 11, 10} {
+		data, err = DefaultAdapter().ReadFileLimited(context.Background(), fileURL(fpath), max)
+		if err != nil {
+			t.Errorf("reading with a limit of %d: %v", max, err)
+		} else if string(data) != "0123456789" {
+			t.Errorf("read %q with a limit of %d, want the entire file", data, max)
+		}
+	}
+
+	data, err = DefaultAdapter().ReadFileLimited(context.Background(), fileURL(fpath), 9)
+	if !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("reading with a limit of 9 returned %v, want %v", err, ErrFileTooLarge)
+	}
+	if data != nil {
+		t.Errorf("reading a file over the limit returned %q", data)
+	}
+
+	_, err = DefaultAdapter().ReadFileLimited(context.Background(), fileURL(fpath), -1)
+	if !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("reading with a negative limit returned %v, want %v", err, os.ErrInvalid)
+	}
+}
+
+func TestReadFileLimitedGrowing(t *testing.T) {
+	var err error
+
+	// Like a file which grew after being checked, this claims to be empty.
+	_, err = os.Stat("/proc/self/status")
+	if err != nil {
+		t.Skip("no /proc file system")
+	}
+
+	_, err = DefaultAdapter().ReadFileLimited(context.Background(),
+		fileURL("/proc/self/status"), 10)
+	if !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("reading past the limit returned %v, want %v", err, ErrFileTooLarge)
+	}
+}
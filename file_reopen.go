@@ -0,0 +1,83 @@
+package file
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"os"
+)
+
+func asyncReopen(reopen func() (*os.File, error), rchan chan *ContextRespectingIoFile,
+	errchan chan error) {
+	var file *os.File
+	var err error
+
+	file, err = reopen()
+	if err != nil {
+		errchan <- err
+	} else {
+		rchan <- NewContextRespectingIoFile(file)
+	}
+}
+
+func asyncCloseFile(file *os.File, errchan chan error) {
+	errchan <- file.Close()
+}
+
+/*
+Reopen opens the path the file was originally opened from again and replaces
+the underlying file descriptor with the new one, closing the old one. This is
+meant for log files which are moved away by an external log rotation tool:
+after Reopen, writes go to a file at the original path, which is created if
+needed. Writers are always reopened in append mode and never truncated.
+
+The old descriptor is only closed once the new one has been opened, so the
+file stays usable if reopening fails. Reopen must not be called concurrently
+with other operations on the same file. Files which weren't opened through a
+FileAdapter cannot be reopened; for them, an error wrapping
+errors.ErrUnsupported is returned.
+*/
+func (f *ContextRespectingIoFile) Reopen(ctx context.Context) error {
+	var rchan = make(chan *ContextRespectingIoFile, 1)
+	var errchan = make(chan error, 1)
+	var opctx context.Context
+	var cancel context.CancelFunc
+	var reopened *ContextRespectingIoFile
+	var old *os.File
+	var err error
+
+	err = f.checkUsable()
+	if err != nil {
+		return err
+	}
+
+	if f.reopen == nil {
+		return &os.PathError{Op: "reopen", Path: f.actualFile.Name(), Err: errors.ErrUnsupported}
+	}
+
+	opctx, cancel = f.operationContext(ctx)
+	defer cancel()
+
+	go asyncReopen(f.reopen, rchan, errchan)
+
+	select {
+	case <-opctx.Done():
+		go discardOpenedFile(rchan, errchan)
+		return f.operationAborted(ctx, opctx)
+	case err = <-errchan:
+		return err
+	case reopened = <-rchan:
+	}
+
+	old = f.actualFile
+	f.actualFile = reopened.actualFile
+	f.pollable = reopened.pollable
+
+	go asyncCloseFile(old, errchan)
+
+	select {
+	case <-opctx.Done():
+		return f.operationAborted(ctx, opctx)
+	case err = <-errchan:
+		return err
+	}
+}
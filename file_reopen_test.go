@@ -0,0 +1,88 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestReopenAfterRotation(t *testing.T) {
+	var dir = testDir(t)
+	var fpath = filepath.Join(dir, "log")
+	var w filesystem.WriteCloser
+	var f *ContextRespectingIoFile
+	var err error
+
+	w, err = DefaultAdapter().OpenWriter(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = w.(*ContextRespectingIoFile)
+	defer f.Close(context.Background())
+
+	_, err = f.Write(context.Background(), []byte("before\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Rename(fpath, fpath+".1")
+	if err != nil && runtime.GOOS == "windows" {
+		t.Skipf("cannot rename open files: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without reopening, writes still end up in the rotated file.
+	_, err = f.Write(context.Background(), []byte("late\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Reopen(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write(context.Background(), []byte("after\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectContents(t, fpath+".1", "before\nlate\n")
+	expectContents(t, fpath, "after\n")
+
+	// Reopening a file which still exists appends to it.
+	err = f.Reopen(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write(context.Background(), []byte("again\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectContents(t, fpath, "after\nagain\n")
+}
+
+func TestReopenUnsupported(t *testing.T) {
+	var r, w *os.File
+	var f *ContextRespectingIoFile
+	var err error
+
+	r, w, err = os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	f = NewContextRespectingIoFile(w)
+	defer f.Close(context.Background())
+
+	err = f.Reopen(context.Background())
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("reopening a pipe returned %v, want %v", err, errors.ErrUnsupported)
+	}
+}
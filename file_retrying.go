@@ -0,0 +1,114 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"io"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+)
+
+/*
+retryableFile is the part of ContextRespectingIoFile used by RetryingReader.
+*/
+type retryableFile interface {
+	ReadAt(ctx context.Context, p []byte, off int64) (int, error)
+	Reopen(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+/*
+RetryingReader reads a file sequentially, retrying reads which fail with
+errors network file systems are known to report transiently.
+*/
+type RetryingReader struct {
+	file       retryableFile
+	offset     int64
+	maxRetries int
+	backoff    time.Duration
+}
+
+/*
+isTransient determines whether err is worth retrying a read for. ESTALE means
+the file handle went stale, so the file needs to be reopened first.
+*/
+func isTransient(err error) bool {
+	return errors.Is(err, syscall.EIO) || errors.Is(err, syscall.ESTALE)
+}
+
+/*
+Read() reads from the current position in the file. If the read fails with
+EIO or ESTALE, it is retried up to the configured number of times, waiting
+for the backoff duration before the first retry and twice as long before
+every further one. After ESTALE, the file is reopened from its path before
+retrying. Data is read with positional reads, so a failed attempt never
+moves the position. The context is honored while waiting.
+*/
+func (r *RetryingReader) Read(ctx context.Context, p []byte) (int, error) {
+	var delay = r.backoff
+	var attempt int
+
+	for attempt = 0; ; attempt++ {
+		var n int
+		var err error
+
+		n, err = r.file.ReadAt(ctx, p, r.offset)
+		r.offset += int64(n)
+		if n > 0 && err != nil && err != io.EOF {
+			// Report what we got; the next read will run into the
+			// error again if it persists.
+			return n, nil
+		}
+		if !isTransient(err) || attempt >= r.maxRetries {
+			return n, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+
+		if errors.Is(err, syscall.ESTALE) {
+			err = r.file.Reopen(ctx)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+/*
+Close() closes the underlying file.
+*/
+func (r *RetryingReader) Close(ctx context.Context) error {
+	return r.file.Close(ctx)
+}
+
+/*
+OpenReaderRetrying works like OpenReader, but the resulting reader retries
+reads failing with errors which are often transient on network file systems
+such as NFS or CIFS, up to maxRetries times per read with exponential
+backoff starting at backoff; see RetryingReader.Read for details.
+*/
+func (file *FileAdapter) OpenReaderRetrying(ctx context.Context, fileurl *url.URL,
+	maxRetries int, backoff time.Duration) (filesystem.ReadCloser, error) {
+	var f *ContextRespectingIoFile
+	var err error
+
+	f, err = file.openForReading(ctx, fileurl, os.Open)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RetryingReader{
+		file:       f,
+		maxRetries: maxRetries,
+		backoff:    backoff,
+	}, nil
+}
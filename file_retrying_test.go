@@ -0,0 +1,116 @@
+package file
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+/*
+flakyFile fails the first reads from the file with the injected errors, and
+counts how often it was reopened.
+*/
+type flakyFile struct {
+	*ContextRespectingIoFile
+	failures []error
+	reads    int
+	reopened int
+}
+
+func (f *flakyFile) ReadAt(ctx context.Context, p []byte, off int64) (int, error) {
+	var err error
+
+	f.reads++
+	if len(f.failures) > 0 {
+		err, f.failures = f.failures[0], f.failures[1:]
+		return 0, &os.PathError{Op: "read", Path: "data", Err: err}
+	}
+	return f.ContextRespectingIoFile.ReadAt(ctx, p, off)
+}
+
+func (f *flakyFile) Reopen(ctx context.Context) error {
+	f.reopened++
+	return f.ContextRespectingIoFile.Reopen(ctx)
+}
+
+/*
+flakyReader creates a RetryingReader for the file fpath whose first reads
+fail with failures.
+*/
+func flakyReader(t *testing.T, fpath string, maxRetries int, failures ...error) (
+	*RetryingReader, *flakyFile) {
+	var f *flakyFile
+	var r *RetryingReader
+
+	t.Helper()
+
+	f = &flakyFile{ContextRespectingIoFile: openTestFile(t, fpath), failures: failures}
+	r = &RetryingReader{file: f, maxRetries: maxRetries, backoff: time.Millisecond}
+	return r, f
+}
+
+func TestRetryingReaderTransientErrors(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var r *RetryingReader
+	var f *flakyFile
+	var data []byte
+	var err error
+
+	writeTestFile(t, fpath, "survives")
+
+	r, f = flakyReader(t, fpath, 3, syscall.EIO, syscall.ESTALE, syscall.EIO)
+	data, err = io.ReadAll(ioReader{ctx: context.Background(), r: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "survives" {
+		t.Errorf("read %q, want %q", data, "survives")
+	}
+	if f.reopened != 1 {
+		t.Errorf("reopened %d times, want once after ESTALE", f.reopened)
+	}
+
+	// Persistent errors are given up on eventually.
+	r, f = flakyReader(t, fpath, 2, syscall.EIO, syscall.EIO, syscall.EIO)
+	_, err = r.Read(context.Background(), make([]byte, 10))
+	if !errors.Is(err, syscall.EIO) {
+		t.Errorf("read returned %v, want %v", err, syscall.EIO)
+	}
+	if f.reads != 3 {
+		t.Errorf("read %d times, want 3", f.reads)
+	}
+
+	// Other errors aren't retried at all.
+	r, f = flakyReader(t, fpath, 2, syscall.EACCES)
+	_, err = r.Read(context.Background(), make([]byte, 10))
+	if !errors.Is(err, syscall.EACCES) || f.reads != 1 {
+		t.Errorf("read returned %v after %d attempts, want %v after one",
+			err, f.reads, syscall.EACCES)
+	}
+}
+
+func TestRetryingReaderCancelled(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var ctx, cancel = context.WithCancel(context.Background())
+	var r *RetryingReader
+	var err error
+
+	writeTestFile(t, fpath, "data")
+
+	r, _ = flakyReader(t, fpath, 3, syscall.EIO)
+	r.backoff = time.Hour
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err = r.Read(ctx, make([]byte, 10))
+	if err != context.Canceled {
+		t.Errorf("read returned %v while backing off, want %v", err, context.Canceled)
+	}
+}
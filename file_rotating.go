@@ -0,0 +1,220 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"sync"
+)
+
+/*
+RotatingWriter appends to a file until it would grow beyond a maximum size,
+at which point the file is rotated: it is renamed to have the suffix ".1",
+existing rotated files are shifted to ".2", ".3" and so on, the oldest ones
+are removed and writing continues with a new, empty file.
+*/
+type RotatingWriter struct {
+	adapter  *FileAdapter
+	url      *url.URL
+	local    string
+	maxBytes int64
+	keep     int
+
+	// mu serializes writes and rotations.
+	mu     sync.Mutex
+	file   *ContextRespectingIoFile
+	size   int64
+	closed bool
+}
+
+/*
+rotatedName returns the name of the n-th rotated file for fpath.
+*/
+func rotatedName(fpath string, n int) string {
+	return fmt.Sprintf("%s.%d", fpath, n)
+}
+
+func asyncRotate(fpath string, keep int, errch chan error) {
+	var err error
+	var i int
+
+	if keep == 0 {
+		err = os.Remove(fpath)
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		errch <- err
+		return
+	}
+
+	err = os.Remove(rotatedName(fpath, keep))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		errch <- err
+		return
+	}
+
+	for i = keep - 1; i >= 1; i-- {
+		err = os.Rename(rotatedName(fpath, i), rotatedName(fpath, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errch <- err
+			return
+		}
+	}
+
+	errch <- os.Rename(fpath, rotatedName(fpath, 1))
+}
+
+/*
+rotate closes the current file, shifts the rotated files and opens a new,
+empty file. The caller must hold the lock.
+*/
+func (w *RotatingWriter) rotate(ctx context.Context) error {
+	var errch = make(chan error, 1)
+	var err error
+
+	if w.file != nil {
+		err = w.file.Close(ctx)
+		w.file = nil
+		if err != nil {
+			return err
+		}
+	}
+
+	go asyncRotate(w.local, w.keep, errch)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-errch:
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.open(ctx)
+}
+
+/*
+open opens the file for appending and determines its current size. The
+caller must hold the lock.
+*/
+func (w *RotatingWriter) open(ctx context.Context) error {
+	var f *ContextRespectingIoFile
+	var size int64
+	var err error
+
+	f, err = w.adapter.openForWriting(ctx, w.url, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+	if err != nil {
+		return err
+	}
+
+	size, err = f.SizeFromFd(ctx)
+	if err != nil {
+		f.Close(context.Background())
+		return urlError("stat", w.url, err)
+	}
+
+	w.file = f
+	w.size = size
+	return nil
+}
+
+/*
+Write() appends p to the current file, rotating it first if p would make it
+exceed the maximum size. Writes are never split across files, so a single
+write larger than the maximum size ends up in a file of its own. If a
+previous rotation failed, the file is opened again first. Write can be
+called from several goroutines at once.
+*/
+func (w *RotatingWriter) Write(ctx context.Context, p []byte) (int, error) {
+	var n int
+	var err error
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	if w.file == nil {
+		err = w.open(ctx)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if w.size > 0 && w.size+int64(len(p)) > w.maxBytes {
+		err = w.rotate(ctx)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err = w.file.Write(ctx, p)
+	w.size += int64(n)
+	return n, err
+}
+
+/*
+Close() closes the current file.
+*/
+func (w *RotatingWriter) Close(ctx context.Context) error {
+	var f *ContextRespectingIoFile
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return os.ErrClosed
+	}
+	w.closed = true
+
+	f, w.file = w.file, nil
+	if f == nil {
+		return nil
+	}
+	return f.Close(ctx)
+}
+
+/*
+OpenRotatingWriter opens the file pointed to by baseurl for appending, and
+rotates it whenever a write would make it larger than maxBytes, keeping at
+most keep rotated files around. See RotatingWriter for details. A keep of 0
+discards the data instead of rotating it; maxBytes must be positive and keep
+must not be negative, otherwise an error wrapping os.ErrInvalid is returned.
+*/
+func (file *FileAdapter) OpenRotatingWriter(ctx context.Context, baseurl *url.URL,
+	maxBytes int64, keep int) (filesystem.WriteCloser, error) {
+	var w *RotatingWriter
+	var local string
+	var err error
+
+	if maxBytes <= 0 || keep < 0 {
+		return nil, urlError("open", baseurl, fmt.Errorf(
+			"%w: rotating after %d bytes, keeping %d files", os.ErrInvalid, maxBytes, keep))
+	}
+
+	local, err = file.localPath(baseurl)
+	if err != nil {
+		return nil, err
+	}
+
+	w = &RotatingWriter{
+		adapter:  file,
+		url:      baseurl,
+		local:    local,
+		maxBytes: maxBytes,
+		keep:     keep,
+	}
+
+	err = w.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
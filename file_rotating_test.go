@@ -0,0 +1,135 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRotatingWriter(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "log")
+	var w filesystem.WriteCloser
+	var err error
+
+	w, err = DefaultAdapter().OpenRotatingWriter(context.Background(), fileURL(fpath), 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first write fills the file exactly, all others overflow it.
+	for _, data := range []string{"0123456789", "aaaaa", "bbbbbbbb", "cccccccc"} {
+		_, err = w.Write(context.Background(), []byte(data))
+		if err != nil {
+			t.Fatalf("writing %q: %v", data, err)
+		}
+	}
+	err = w.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectContents(t, fpath, "cccccccc")
+	expectContents(t, rotatedName(fpath, 1), "bbbbbbbb")
+	expectContents(t, rotatedName(fpath, 2), "aaaaa")
+	_, err = os.Stat(rotatedName(fpath, 3))
+	if !os.IsNotExist(err) {
+		t.Errorf("oldest file was not pruned: %v", err)
+	}
+
+	_, err = w.Write(context.Background(), []byte("late"))
+	if err != os.ErrClosed {
+		t.Errorf("writing after close returned %v, want %v", err, os.ErrClosed)
+	}
+}
+
+func TestRotatingWriterInvalidLimits(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "log")
+	var err error
+
+	for _, test := range []struct {
+		maxBytes int64
+		keep     int
+	}{{0, 2}, {-1, 2}, {10, -1}} {
+		_, err = DefaultAdapter().OpenRotatingWriter(context.Background(), fileURL(fpath),
+			test.maxBytes, test.keep)
+		if !errors.Is(err, os.ErrInvalid) {
+			t.Errorf("rotating after %d bytes keeping %d returned %v, want %v",
+				test.maxBytes, test.keep, err, os.ErrInvalid)
+		}
+	}
+
+	_, err = os.Stat(fpath)
+	if !os.IsNotExist(err) {
+		t.Errorf("file created despite invalid limits: %v", err)
+	}
+}
+
+func TestRotatingWriterConcurrent(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "log")
+	var w filesystem.WriteCloser
+	var wg sync.WaitGroup
+	var records []string
+	var data []byte
+	var i int
+	var err error
+
+	// Keep everything, so that no record gets lost to pruning.
+	w, err = DefaultAdapter().OpenRotatingWriter(context.Background(), fileURL(fpath), 100, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i = 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var _, err = w.Write(context.Background(), []byte(fmt.Sprintf("record%03d\n", i)))
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	err = w.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every file holds exactly ten records.
+	for i = 0; i < 10; i++ {
+		var name = fpath
+		if i > 0 {
+			name = rotatedName(fpath, i)
+		}
+		data, err = os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) > 100 {
+			t.Errorf("%s has %d bytes, more than the maximum", name, len(data))
+		}
+		records = append(records, strings.Fields(string(data))...)
+	}
+	_, err = os.Stat(rotatedName(fpath, 10))
+	if !os.IsNotExist(err) {
+		t.Errorf("more files than needed were written: %v", err)
+	}
+
+	sort.Strings(records)
+	if len(records) != 100 {
+		t.Fatalf("found %d records, want 100", len(records))
+	}
+	for i = range records {
+		if records[i] != fmt.Sprintf("record%03d", i) {
+			t.Fatalf("found record %q at position %d", records[i], i)
+		}
+	}
+}
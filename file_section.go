@@ -0,0 +1,149 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"math"
+	"net/url"
+	"os"
+)
+
+/*
+sectionReader provides a read-only view into a byte range of an open file. It
+keeps its own read offset and uses positional reads, so any number of
+sections over the same file can be used concurrently without disturbing
+each other or the file's own offset.
+*/
+type sectionReader struct {
+	file   *ContextRespectingIoFile
+	offset int64
+	limit  int64
+	closed bool
+
+	// err, if set, is returned by every read, since the section was
+	// invalid from the start.
+	err error
+
+	// owned is set if the file was opened just for this section and must
+	// be closed along with it.
+	owned bool
+}
+
+/*
+sectionLimit returns the offset of the end of the section of n bytes starting
+at off, clamped so that sections reaching beyond the largest possible offset
+don't wrap around.
+*/
+func sectionLimit(off, n int64) int64 {
+	var limit = off + n
+
+	if n > 0 && limit < off {
+		return math.MaxInt64
+	}
+	return limit
+}
+
+/*
+Read() reads from the current position in the section, returning io.EOF once
+the end of the section (or the file) has been reached. Reads never return
+data from beyond the end of the section; once it has been reached, every
+read returns (0, io.EOF), so sections compose with io.Copy, io.ReadAll and
+bufio like any other reader.
+*/
+func (s *sectionReader) Read(ctx context.Context, p []byte) (int, error) {
+	var length = len(p)
+	var n int
+	var err error
+
+	if s.closed {
+		return 0, os.ErrClosed
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	if s.offset >= s.limit {
+		return 0, io.EOF
+	}
+
+	if int64(length) > s.limit-s.offset {
+		length = int(s.limit - s.offset)
+	}
+
+	n, err = s.file.ReadAt(ctx, p[:length], s.offset)
+	s.offset += int64(n)
+	return n, err
+}
+
+/*
+Close() releases the section. The underlying file stays open, since it may
+still be shared with other sections, unless it was opened for the section.
+*/
+func (s *sectionReader) Close(ctx context.Context) error {
+	if s.closed {
+		return os.ErrClosed
+	}
+	s.closed = true
+	if s.owned {
+		return s.file.Close(ctx)
+	}
+	return nil
+}
+
+/*
+Section returns a reader over the n bytes of the file starting at offset off.
+Sections read using positional reads and keep their own offset, so they can
+be handed to different goroutines and read concurrently with each other. The
+context is currently unused since creating a section involves no I/O. As
+Section cannot fail, invalid sections are reported by every read without
+touching the file: a negative off with an error wrapping ErrNegativeOffset,
+a negative n with one wrapping os.ErrInvalid.
+*/
+func (f *ContextRespectingIoFile) Section(ctx context.Context, off, n int64) filesystem.ReadCloser {
+	var s = &sectionReader{
+		file:   f,
+		offset: off,
+		limit:  sectionLimit(off, n),
+	}
+
+	if off < 0 {
+		s.err = &os.PathError{Op: "read", Path: f.actualFile.Name(), Err: ErrNegativeOffset}
+	} else if n < 0 {
+		s.err = &os.PathError{Op: "read", Path: f.actualFile.Name(),
+			Err: fmt.Errorf("%w: section of %d bytes", os.ErrInvalid, n)}
+	}
+	return s
+}
+
+/*
+OpenRangeReader opens the file pointed to and returns a reader over the length
+bytes starting at offset off, which reports io.EOF once length bytes have been
+read. If the file ends before that, io.EOF is reported at the end of the file
+instead. Either way, io.EOF itself is returned rather than an error wrapping
+it, as io.Copy and bufio expect. Closing the reader closes the file.
+*/
+func (file *FileAdapter) OpenRangeReader(ctx context.Context, fileurl *url.URL,
+	off, length int64) (filesystem.ReadCloser, error) {
+	var f *ContextRespectingIoFile
+	var err error
+
+	if off < 0 || length < 0 {
+		return nil, urlError("open", fileurl, fmt.Errorf("%w: range of %d bytes at offset %d",
+			os.ErrInvalid, length, off))
+	}
+
+	f, err = file.openForReading(ctx, fileurl, os.Open)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sectionReader{
+		file:   f,
+		offset: off,
+		limit:  sectionLimit(off, length),
+		owned:  true,
+	}, nil
+}
@@ -0,0 +1,204 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"bufio"
+	"bytes"
+	"errors"
+	"golang.org/x/net/context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/iotest"
+)
+
+/*
+sectionTestData is the contents of the files read in the section tests;
+every byte differs from its neighbours, so reads from the wrong offset show.
+*/
+func sectionTestData() []byte {
+	var data = make([]byte, 4096)
+	var i int
+
+	for i = range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func TestSectionsReadConcurrently(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var data = sectionTestData()
+	var f *ContextRespectingIoFile
+	var wg sync.WaitGroup
+	var sections = []struct {
+		off, n int64
+		want   []byte
+	}{
+		{0, 1000, data[:1000]},
+		{500, 1000, data[500:1500]},
+		{900, 3000, data[900:3900]},
+		{1200, 1, data[1200:1201]},
+		// Sections reaching beyond the end of the file stop at the end.
+		{4000, 1000, data[4000:]},
+	}
+	var i int
+
+	writeTestFile(t, fpath, string(data))
+	f = openTestFile(t, fpath)
+
+	for i = 0; i < 4; i++ {
+		for _, section := range sections {
+			var r filesystem.ReadCloser = f.Section(context.Background(), section.off, section.n)
+
+			wg.Add(1)
+			go func(want []byte) {
+				defer wg.Done()
+
+				if got := readAndClose(t, r); got != string(want) {
+					t.Errorf("section read %d bytes which differ from the %d expected",
+						len(got), len(want))
+				}
+			}(section.want)
+		}
+	}
+	wg.Wait()
+
+	// The sections don't move the file's own offset.
+	if got := readAndClose(t, f); got != string(data) {
+		t.Errorf("reading the file after the sections returned %d bytes", len(got))
+	}
+}
+
+func TestSectionInvalid(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var f *ContextRespectingIoFile
+	var err error
+
+	writeTestFile(t, fpath, string(sectionTestData()))
+	f = openTestFile(t, fpath)
+	defer f.Close(context.Background())
+
+	for _, test := range []struct {
+		off, n int64
+		err    error
+	}{
+		{-1, 10, ErrNegativeOffset},
+		{-10, 5, ErrNegativeOffset},
+		{10, -1, os.ErrInvalid},
+	} {
+		var r = f.Section(context.Background(), test.off, test.n)
+		var n int
+
+		n, err = r.Read(context.Background(), make([]byte, 10))
+		if n != 0 || !errors.Is(err, test.err) {
+			t.Errorf("reading %d bytes at %d returned %d, %v, want 0, %v",
+				test.n, test.off, n, err, test.err)
+		}
+		r.Close(context.Background())
+	}
+}
+
+func TestOpenRangeReader(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var data = sectionTestData()
+	var r filesystem.ReadCloser
+	var err error
+
+	writeTestFile(t, fpath, string(data))
+
+	for _, test := range []struct {
+		name   string
+		off, n int64
+		want   []byte
+	}{
+		{"middle", 1000, 2000, data[1000:3000]},
+		{"past the end", 4000, 1000, data[4000:]},
+		{"beyond the end", 5000, 10, nil},
+		{"empty", 1000, 0, nil},
+	} {
+		var buf = make([]byte, 10)
+		var n int
+
+		r, err = DefaultAdapter().OpenRangeReader(context.Background(), fileURL(fpath), test.off, test.n)
+		if err != nil {
+			t.Errorf("opening the %s range: %v", test.name, err)
+			continue
+		}
+		if got := readAndClose(t, r); got != string(test.want) {
+			t.Errorf("read %d bytes from the %s range, want %d", len(got), test.name, len(test.want))
+		}
+
+		// EOF is reported as such, without any data once the range is up.
+		r, err = DefaultAdapter().OpenRangeReader(context.Background(), fileURL(fpath),
+			test.off+int64(len(test.want)), test.n-int64(len(test.want)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err = r.Read(context.Background(), buf)
+		if n != 0 || err != io.EOF {
+			t.Errorf("reading at the end of the %s range returned %d, %v, want 0, io.EOF",
+				test.name, n, err)
+		}
+		r.Close(context.Background())
+	}
+
+	_, err = DefaultAdapter().OpenRangeReader(context.Background(), fileURL(fpath), -1, 10)
+	if !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("opening a range at a negative offset returned %v, want %v", err, os.ErrInvalid)
+	}
+}
+
+func TestRangeReaderComposes(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var data = sectionTestData()
+	var f *ContextRespectingIoFile
+	var open = func(off, n int64) *contextReader {
+		var r, err = DefaultAdapter().OpenRangeReader(context.Background(), fileURL(fpath), off, n)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			r.Close(context.Background())
+		})
+		return &contextReader{ctx: context.Background(), r: r}
+	}
+	var got []byte
+	var n int64
+	var err error
+
+	writeTestFile(t, fpath, string(data))
+
+	got, err = io.ReadAll(open(1000, 2000))
+	if err != nil || !bytes.Equal(got, data[1000:3000]) {
+		t.Errorf("io.ReadAll returned %d bytes, %v, want exactly 2000", len(got), err)
+	}
+
+	n, err = io.Copy(io.Discard, bufio.NewReaderSize(open(10, 3000), 16))
+	if err != nil || n != 3000 {
+		t.Errorf("copying through bufio returned %d bytes, %v, want exactly 3000", n, err)
+	}
+
+	got, err = io.ReadAll(io.LimitReader(open(100, 1000), 300))
+	if err != nil || !bytes.Equal(got, data[100:400]) {
+		t.Errorf("io.LimitReader returned %d bytes, %v, want exactly 300", len(got), err)
+	}
+
+	// iotest checks reads of all sizes, and that EOF sticks.
+	err = iotest.TestReader(open(4000, 1000), data[4000:])
+	if err != nil {
+		t.Error(err)
+	}
+	f = openTestFile(t, fpath)
+	err = iotest.TestReader(&contextReader{
+		ctx: context.Background(),
+		r:   f.Section(context.Background(), 7, 777),
+	}, data[7:784])
+	if err != nil {
+		t.Error(err)
+	}
+}
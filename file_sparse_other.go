@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || freebsd)
+
+package file
+
+import (
+	"golang.org/x/net/context"
+	"os"
+)
+
+/*
+copySparse is not supported on this platform.
+*/
+func copySparse(ctx context.Context, in, out *os.File, buf []byte,
+	report func(copied int64)) error {
+	return errSparseUnsupported
+}
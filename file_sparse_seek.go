@@ -0,0 +1,57 @@
+//go:build linux || darwin || freebsd
+
+package file
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+/*
+copySparse copies only the data regions of in to the same offsets in out,
+leaving holes in out wherever in has them. The regions are found using
+SEEK_DATA and SEEK_HOLE; out must be empty to begin with. If the file system
+doesn't support these, errSparseUnsupported is returned.
+*/
+func copySparse(ctx context.Context, in, out *os.File, buf []byte,
+	report func(copied int64)) error {
+	var fd = int(in.Fd())
+	var fi os.FileInfo
+	var off int64
+	var err error
+
+	fi, err = in.Stat()
+	if err != nil {
+		return err
+	}
+
+	for off < fi.Size() {
+		var data, hole int64
+
+		data, err = unix.Seek(fd, off, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// Only a hole is left until the end of the file.
+			break
+		} else if off == 0 && errors.Is(err, unix.EINVAL) {
+			return errSparseUnsupported
+		} else if err != nil {
+			return err
+		}
+
+		hole, err = unix.Seek(fd, data, unix.SEEK_HOLE)
+		if err != nil {
+			return err
+		}
+
+		err = copyRange(ctx, in, out, data, hole-data, buf, report)
+		if err != nil {
+			return err
+		}
+		off = hole
+	}
+
+	// Extend the file to its full size in case it ends in a hole.
+	return out.Truncate(fi.Size())
+}
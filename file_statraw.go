@@ -0,0 +1,47 @@
+package file
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+)
+
+/*
+StatRaw determines the raw operating system information about the file
+pointed to, such as inode and device numbers or the number of links, which
+os.FileInfo doesn't expose. On POSIX systems, the result is the
+syscall.Stat_t of the file; elsewhere, an error wrapping
+errors.ErrUnsupported is returned. Symbolic links are followed. The actual
+lookup will happen in a subthread so that we have a guaranteed response time
+from this function in case the operation exceeds the alotted time limits.
+*/
+func (file *FileAdapter) StatRaw(ctx context.Context, fileurl *url.URL) (*RawStat, error) {
+	var rch = make(chan os.FileInfo, 1)
+	var errch = make(chan error, 1)
+	var fi os.FileInfo
+	var st *RawStat
+	var fpath string
+	var ok bool
+	var err error
+
+	fpath, err = file.localPath(fileurl)
+	if err != nil {
+		return nil, err
+	}
+
+	go asyncStat(fpath, rch, errch)
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case err = <-errch:
+		return nil, urlError("stat", fileurl, err)
+	case fi = <-rch:
+		st, ok = rawStat(fi)
+		if !ok {
+			return nil, urlError("stat", fileurl, errors.ErrUnsupported)
+		}
+		return st, nil
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package file
+
+import (
+	"os"
+)
+
+/*
+RawStat is the raw file information returned by StatRaw. There is no such
+information on this platform.
+*/
+type RawStat struct{}
+
+/*
+rawStat is not supported on this platform.
+*/
+func rawStat(fi os.FileInfo) (*RawStat, bool) {
+	return nil, false
+}
@@ -0,0 +1,22 @@
+//go:build !unix
+
+package file
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"path/filepath"
+	"testing"
+)
+
+func TestStatRawUnsupported(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var err error
+
+	writeTestFile(t, fpath, "data")
+
+	_, err = DefaultAdapter().StatRaw(context.Background(), fileURL(fpath))
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("raw stat returned %v, want %v", err, errors.ErrUnsupported)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package file
+
+import (
+	"os"
+	"syscall"
+)
+
+/*
+RawStat is the raw file information returned by StatRaw.
+*/
+type RawStat = syscall.Stat_t
+
+/*
+rawStat extracts the raw file information from fi.
+*/
+func rawStat(fi os.FileInfo) (*RawStat, bool) {
+	var st *syscall.Stat_t
+	var ok bool
+
+	st, ok = fi.Sys().(*syscall.Stat_t)
+	return st, ok
+}
@@ -0,0 +1,95 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+func asyncOpenTemp(dir, pattern string, perm os.FileMode, rchan chan *ContextRespectingIoFile,
+	errchan chan error) {
+	var file *os.File
+	var err error
+
+	err = makeDirs(dir)
+	if err != nil {
+		errchan <- err
+		return
+	}
+
+	file, err = os.CreateTemp(dir, pattern)
+	if err != nil {
+		errchan <- err
+		return
+	}
+
+	// CreateTemp always uses 0600.
+	err = file.Chmod(perm)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		errchan <- err
+		return
+	}
+
+	rchan <- NewContextRespectingIoFile(file)
+}
+
+/*
+discardTempFile is like discardOpenedFile, but also removes the file again,
+since nobody will ever learn its name.
+*/
+func discardTempFile(rchan chan *ContextRespectingIoFile, errchan chan error) {
+	var f *ContextRespectingIoFile
+
+	select {
+	case <-errchan:
+	case f = <-rchan:
+		f.actualFile.Close()
+		os.Remove(f.actualFile.Name())
+	}
+}
+
+/*
+OpenTemp creates a new file with a unique name in the directory pointed to by
+dirurl and opens it for writing, creating the directory if needed. The name
+is generated from pattern as described for os.CreateTemp. Besides the writer,
+the URL of the new file is returned; the file is not removed automatically.
+
+The file gets the adapter's FileMode, so it can be renamed into place once
+written. The mode is applied with chmod after creation and is therefore not
+subject to the umask, as if ExactFileMode was set.
+*/
+func (file *FileAdapter) OpenTemp(ctx context.Context, dirurl *url.URL, pattern string) (
+	filesystem.WriteCloser, *url.URL, error) {
+	var rchan = make(chan *ContextRespectingIoFile, 1)
+	var errchan = make(chan error, 1)
+	var cancel context.CancelFunc
+	var f *ContextRespectingIoFile
+	var dir string
+	var err error
+
+	dir, err = file.localPath(dirurl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel = file.operationContext(ctx)
+	defer cancel()
+
+	go asyncOpenTemp(dir, pattern, file.fileMode(), rchan, errchan)
+
+	select {
+	case <-ctx.Done():
+		go discardTempFile(rchan, errchan)
+		return nil, nil, ctx.Err()
+	case err = <-errchan:
+		return nil, nil, urlError("create", dirurl, err)
+	case f = <-rchan:
+		f.timeout = file.OperationTimeout
+		return f, childURL(dirurl, filepath.Base(f.actualFile.Name())), nil
+	}
+}
@@ -0,0 +1,69 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestOpenTemp(t *testing.T) {
+	var dir = filepath.Join(testDir(t), "scratch")
+	var adapter = &FileAdapter{FileMode: 0640}
+	var urls [2]*url.URL
+	var r filesystem.ReadCloser
+	var i int
+	var err error
+
+	// The directory is created as needed.
+	for i = range urls {
+		var w filesystem.WriteCloser
+
+		w, urls[i], err = adapter.OpenTemp(context.Background(), fileURL(dir), "tmp-*.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write(context.Background(), []byte(urls[i].Path))
+		if err != nil {
+			t.Error(err)
+		}
+		err = w.Close(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if urls[0].String() == urls[1].String() {
+		t.Fatalf("both temporary files are %s", urls[0])
+	}
+
+	for i = range urls {
+		var name = filepath.Base(urls[i].Path)
+		var fi os.FileInfo
+
+		if !strings.HasPrefix(name, "tmp-") || !strings.HasSuffix(name, ".txt") {
+			t.Errorf("name %s doesn't follow the pattern", name)
+		}
+
+		r, err = adapter.OpenReader(context.Background(), urls[i])
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got := readAndClose(t, r); got != urls[i].Path {
+			t.Errorf("read %q from %s, want what was written to it", got, urls[i])
+		}
+
+		fi, err = os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+		} else if runtime.GOOS != "windows" && fi.Mode().Perm() != 0640 {
+			t.Errorf("%s has mode %v, want %v", name, fi.Mode().Perm(), os.FileMode(0640))
+		}
+	}
+}
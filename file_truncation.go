@@ -0,0 +1,101 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"io"
+	"net/url"
+	"os"
+)
+
+/*
+ErrFileTruncated is returned by readers from OpenReaderDetectTruncation when
+the file turns out to have been truncated while it was being read.
+*/
+var ErrFileTruncated = errors.New("file truncated while reading")
+
+/*
+TruncationDetectingReader remembers the size of the file when it was opened
+and checks, once the end of the file is reached, whether all of it could be
+read and whether the file is still as large.
+*/
+type TruncationDetectingReader struct {
+	file *ContextRespectingIoFile
+	path string
+	size int64
+	read int64
+	err  error
+}
+
+/*
+Read() reads data from the file. When the end of the file is reached before
+as many bytes as the file had when it was opened have been read, or the file
+has become smaller since, an error wrapping ErrFileTruncated is returned
+instead of io.EOF.
+*/
+func (t *TruncationDetectingReader) Read(ctx context.Context, p []byte) (int, error) {
+	var n int
+	var size int64
+	var err error
+
+	if t.err != nil {
+		return 0, t.err
+	}
+
+	n, err = t.file.Read(ctx, p)
+	t.read += int64(n)
+	if err != io.EOF {
+		return n, err
+	}
+
+	size, err = t.file.SizeFromFd(ctx)
+	if err != nil {
+		return n, err
+	}
+
+	t.err = io.EOF
+	if t.read < t.size || size < t.size {
+		t.err = &os.PathError{Op: "read", Path: t.path, Err: ErrFileTruncated}
+	}
+	return n, t.err
+}
+
+/*
+Close() closes the underlying file.
+*/
+func (t *TruncationDetectingReader) Close(ctx context.Context) error {
+	return t.file.Close(ctx)
+}
+
+/*
+OpenReaderDetectTruncation works like OpenReader, but the resulting reader
+reports an error wrapping ErrFileTruncated instead of io.EOF if the file was
+truncated after it was opened, so that callers can tell a short file from
+one they only got part of. Files which only grow while being read are not
+affected; the data appended may or may not be read.
+*/
+func (file *FileAdapter) OpenReaderDetectTruncation(
+	ctx context.Context, fileurl *url.URL) (filesystem.ReadCloser, error) {
+	var f *ContextRespectingIoFile
+	var size int64
+	var err error
+
+	f, err = file.openForReading(ctx, fileurl, os.Open)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err = f.SizeFromFd(ctx)
+	if err != nil {
+		f.Close(ctx)
+		return nil, urlError("stat", fileurl, err)
+	}
+
+	return &TruncationDetectingReader{
+		file: f,
+		path: f.actualFile.Name(),
+		size: size,
+	}, nil
+}
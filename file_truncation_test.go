@@ -0,0 +1,107 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+readToEnd reads from r until the end or an error, returning the number of
+bytes read and the error ending the read.
+*/
+func readToEnd(r filesystem.ReadCloser) (int, error) {
+	var buf = make([]byte, 100)
+	var total int
+	var err error
+
+	for {
+		var n int
+
+		n, err = r.Read(context.Background(), buf)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+func TestOpenReaderDetectTruncation(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var r filesystem.ReadCloser
+	var n int
+	var err error
+
+	writeTestFile(t, fpath, strings.Repeat("x", 1000))
+
+	r, err = DefaultAdapter().OpenReaderDetectTruncation(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close(context.Background())
+
+	_, err = r.Read(context.Background(), make([]byte, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Truncate(fpath, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err = readToEnd(r)
+	if !errors.Is(err, ErrFileTruncated) {
+		t.Errorf("reading a truncated file returned %v, want %v", err, ErrFileTruncated)
+	}
+	if n != 200 {
+		t.Errorf("read %d bytes after the truncation, want the remaining 200", n)
+	}
+
+	// The error sticks.
+	_, err = r.Read(context.Background(), make([]byte, 100))
+	if !errors.Is(err, ErrFileTruncated) {
+		t.Errorf("reading on returned %v, want %v", err, ErrFileTruncated)
+	}
+}
+
+func TestOpenReaderDetectTruncationUnchanged(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "data")
+	var r filesystem.ReadCloser
+	var n int
+	var err error
+
+	writeTestFile(t, fpath, strings.Repeat("x", 1000))
+
+	r, err = DefaultAdapter().OpenReaderDetectTruncation(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close(context.Background())
+
+	n, err = readToEnd(r)
+	if err != io.EOF || n != 1000 {
+		t.Errorf("read %d bytes and %v from an unchanged file, want 1000 and io.EOF", n, err)
+	}
+
+	// Plain readers just see the file end early.
+	r, err = DefaultAdapter().OpenReader(context.Background(), fileURL(fpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close(context.Background())
+	err = os.Truncate(fpath, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = readToEnd(r)
+	if err != io.EOF || n != 300 {
+		t.Errorf("read %d bytes and %v from a truncated file, want 300 and io.EOF", n, err)
+	}
+}
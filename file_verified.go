@@ -0,0 +1,102 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"bytes"
+	"errors"
+	"golang.org/x/net/context"
+	"hash"
+	"io"
+	"net/url"
+)
+
+/*
+ErrChecksumMismatch is returned by verifying readers when the digest of the
+data read doesn't match the expected one.
+*/
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+/*
+VerifyingReader passes all data read through a hash and compares the result
+against the expected digest once the end of the file is reached.
+*/
+type VerifyingReader struct {
+	file     filesystem.ReadCloser
+	hash     hash.Hash
+	expected []byte
+	verified bool
+	err      error
+}
+
+/*
+Read() reads data from the file and adds it to the digest. When the end of
+the file is reached and the digest doesn't match the expected one,
+ErrChecksumMismatch is returned instead of io.EOF.
+*/
+func (v *VerifyingReader) Read(ctx context.Context, p []byte) (int, error) {
+	var n int
+	var err error
+
+	if v.verified {
+		return 0, v.finalError()
+	}
+
+	n, err = v.file.Read(ctx, p)
+	v.hash.Write(p[:n])
+
+	if err == io.EOF {
+		v.verified = true
+		if !bytes.Equal(v.hash.Sum(nil), v.expected) {
+			v.err = ErrChecksumMismatch
+		}
+		return n, v.finalError()
+	}
+
+	return n, err
+}
+
+func (v *VerifyingReader) finalError() error {
+	if v.err != nil {
+		return v.err
+	}
+	return io.EOF
+}
+
+/*
+Close() closes the underlying file. If the whole file has been read and the
+digest didn't match, ErrChecksumMismatch is returned so the problem isn't lost
+on callers which don't look at the final read error. Nothing can be said
+about files which have not been read to the end.
+*/
+func (v *VerifyingReader) Close(ctx context.Context) error {
+	var err error
+
+	err = v.file.Close(ctx)
+	if v.err != nil {
+		return v.err
+	}
+	return err
+}
+
+/*
+OpenReaderVerified works like OpenReader, but all data read is passed through
+a hash created by h, and reaching the end of the file yields
+ErrChecksumMismatch instead of io.EOF if the digest doesn't match expected.
+*/
+func (file *FileAdapter) OpenReaderVerified(ctx context.Context, fileurl *url.URL,
+	expected []byte, h func() hash.Hash) (filesystem.ReadCloser, error) {
+	var rc filesystem.ReadCloser
+	var err error
+
+	rc, err = file.OpenReader(ctx, fileurl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VerifyingReader{
+		file:     rc,
+		hash:     h(),
+		expected: expected,
+	}, nil
+}
@@ -0,0 +1,51 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"crypto/sha256"
+	"errors"
+	"golang.org/x/net/context"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifiedReader(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "download")
+	var good = sha256.Sum256([]byte("payload"))
+	var bad = sha256.Sum256([]byte("something else"))
+	var r filesystem.ReadCloser
+	var data []byte
+	var err error
+
+	writeTestFile(t, fpath, "payload")
+
+	r, err = DefaultAdapter().OpenReaderVerified(context.Background(), fileURL(fpath),
+		good[:], sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAndClose(t, r); got != "payload" {
+		t.Errorf("read %q, want %q", got, "payload")
+	}
+
+	r, err = DefaultAdapter().OpenReaderVerified(context.Background(), fileURL(fpath),
+		bad[:], sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The data is still returned, but the end of the file isn't reached.
+	data, err = io.ReadAll(ioReader{ctx: context.Background(), r: r})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("reading returned %v, want %v", err, ErrChecksumMismatch)
+	}
+	if string(data) != "payload" {
+		t.Errorf("read %q, want %q", data, "payload")
+	}
+	err = r.Close(context.Background())
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("closing returned %v, want %v", err, ErrChecksumMismatch)
+	}
+}
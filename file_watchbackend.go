@@ -0,0 +1,81 @@
+package file
+
+import (
+	"gopkg.in/fsnotify.v1"
+)
+
+/*
+watchBackend is the part of an fsnotify.Watcher used by file and directory
+watchers. It allows for feeding the watchers with events from something other
+than the actual file system, e.g. in tests.
+*/
+type watchBackend interface {
+	// Add starts watching the file or directory at the specified path.
+	Add(name string) error
+
+	// Remove stops watching the file or directory at the specified path.
+	Remove(name string) error
+
+	// Close stops watching anything and closes the channels.
+	Close() error
+
+	// Events returns the channel on which changes are reported.
+	Events() <-chan fsnotify.Event
+
+	// Errors returns the channel on which problems with watching are
+	// reported.
+	Errors() <-chan error
+}
+
+/*
+fsnotifyBackend implements watchBackend on top of an actual fsnotify.Watcher.
+*/
+type fsnotifyBackend struct {
+	watcher *fsnotify.Watcher
+}
+
+func (b *fsnotifyBackend) Add(name string) error {
+	return b.watcher.Add(name)
+}
+
+func (b *fsnotifyBackend) Remove(name string) error {
+	return b.watcher.Remove(name)
+}
+
+func (b *fsnotifyBackend) Close() error {
+	return b.watcher.Close()
+}
+
+func (b *fsnotifyBackend) Events() <-chan fsnotify.Event {
+	return b.watcher.Events
+}
+
+func (b *fsnotifyBackend) Errors() <-chan error {
+	return b.watcher.Errors
+}
+
+/*
+newFsnotifyBackend creates a backend watching the actual file system.
+*/
+func newFsnotifyBackend() (watchBackend, error) {
+	var watcher *fsnotify.Watcher
+	var err error
+
+	watcher, err = fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &fsnotifyBackend{watcher: watcher}, nil
+}
+
+/*
+openWatchBackend creates the backend for a new watcher set up through the
+adapter. Unless the adapter was given a different factory, e.g. in tests,
+this is an actual fsnotify.Watcher.
+*/
+func (file *FileAdapter) openWatchBackend() (watchBackend, error) {
+	if file.newWatchBackend != nil {
+		return file.newWatchBackend()
+	}
+	return newFsnotifyBackend()
+}
@@ -0,0 +1,321 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"golang.org/x/net/context"
+	"gopkg.in/fsnotify.v1"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+fakeWatchBackend is a watchBackend which only reports the events and errors
+a test sends it. Like fsnotify, it refuses to watch paths which don't exist.
+*/
+type fakeWatchBackend struct {
+	mu        sync.Mutex
+	watched   map[string]bool
+	events    chan fsnotify.Event
+	errors    chan error
+	closeOnce sync.Once
+}
+
+func newFakeWatchBackend() *fakeWatchBackend {
+	return &fakeWatchBackend{
+		watched: make(map[string]bool),
+		events:  make(chan fsnotify.Event),
+		errors:  make(chan error),
+	}
+}
+
+func (b *fakeWatchBackend) Add(name string) error {
+	var err error
+
+	_, err = os.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.watched[filepath.Clean(name)] = true
+	return nil
+}
+
+func (b *fakeWatchBackend) Remove(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.watched[filepath.Clean(name)] {
+		return errors.New("can't remove non-existent watch")
+	}
+	delete(b.watched, filepath.Clean(name))
+	return nil
+}
+
+func (b *fakeWatchBackend) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.events)
+		close(b.errors)
+	})
+	return nil
+}
+
+func (b *fakeWatchBackend) Events() <-chan fsnotify.Event {
+	return b.events
+}
+
+func (b *fakeWatchBackend) Errors() <-chan error {
+	return b.errors
+}
+
+/*
+isWatched determines whether the path is currently being watched.
+*/
+func (b *fakeWatchBackend) isWatched(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.watched[filepath.Clean(name)]
+}
+
+/*
+adapter returns a file adapter whose watchers all use the backend.
+*/
+func (b *fakeWatchBackend) adapter() *FileAdapter {
+	return &FileAdapter{
+		newWatchBackend: func() (watchBackend, error) {
+			return b, nil
+		},
+	}
+}
+
+/*
+send delivers a synthetic event to the watcher using the backend, failing the
+test if the watcher doesn't pick it up in time.
+*/
+func (b *fakeWatchBackend) send(t *testing.T, name string, op fsnotify.Op) {
+	t.Helper()
+
+	select {
+	case b.events <- fsnotify.Event{Name: name, Op: op}:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("watcher didn't accept %v event for %s", op, name)
+	}
+}
+
+/*
+sendWatched delivers a synthetic event for name once for every watch which
+covers it, i.e. for the path itself and for its directory, just like inotify
+reports changes to files which are also watched through their directory
+twice.
+*/
+func (b *fakeWatchBackend) sendWatched(t *testing.T, name string, op fsnotify.Op) {
+	var watch string
+
+	t.Helper()
+
+	for _, watch = range []string{name, filepath.Dir(name)} {
+		if b.isWatched(watch) {
+			b.send(t, name, op)
+		}
+	}
+}
+
+/*
+watchedContents collects what a watch callback reports: the contents of the
+file for every change, in the order the callbacks finished reading.
+*/
+type watchedContents struct {
+	t        *testing.T
+	contents chan string
+}
+
+func newWatchedContents(t *testing.T) *watchedContents {
+	return &watchedContents{t: t, contents: make(chan string, 100)}
+}
+
+func (w *watchedContents) notify(path *url.URL, r filesystem.ReadCloser) {
+	w.contents <- readAndClose(w.t, r)
+}
+
+/*
+expect waits for the next change to be reported and checks its contents.
+*/
+func (w *watchedContents) expect(want string) {
+	w.t.Helper()
+
+	select {
+	case got := <-w.contents:
+		if got != want {
+			w.t.Errorf("reported contents %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		w.t.Fatalf("change with contents %q not reported", want)
+	}
+}
+
+/*
+expectNone checks that no further changes have been reported. It should only
+be called once no callbacks can be running anymore.
+*/
+func (w *watchedContents) expectNone() {
+	w.t.Helper()
+
+	select {
+	case got := <-w.contents:
+		w.t.Errorf("unexpected change with contents %q reported", got)
+	default:
+	}
+}
+
+/*
+watchWithFake sets up a watcher for fpath using a fake backend, returning the
+backend and the collected changes after checking the initial report.
+*/
+func watchWithFake(t *testing.T, fpath, initial string) (*fakeWatchBackend,
+	*watchedContents, *FileWatcher) {
+	var backend = newFakeWatchBackend()
+	var contents = newWatchedContents(t)
+	var watcher *FileWatcher
+	var err error
+
+	watcher, err = newFileWatcher(context.Background(), backend.adapter(), fileURL(fpath),
+		withoutContext(contents.notify), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents.expect(initial)
+
+	return backend, contents, watcher
+}
+
+func TestFakeBackendWriteReportsContents(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "config")
+	var backend *fakeWatchBackend
+	var contents *watchedContents
+	var watcher *FileWatcher
+
+	writeTestFile(t, fpath, "one")
+	backend, contents, watcher = watchWithFake(t, fpath, "one")
+
+	if !backend.isWatched(fpath) {
+		t.Errorf("%s is not being watched", fpath)
+	}
+
+	writeTestFile(t, fpath, "two")
+	backend.send(t, fpath, fsnotify.Write)
+	contents.expect("two")
+
+	// Changes to the attributes only don't change the contents.
+	backend.send(t, fpath, fsnotify.Chmod)
+
+	if err := watcher.ShutdownAndWait(context.Background()); err != nil {
+		t.Error(err)
+	}
+	contents.expectNone()
+}
+
+func TestFakeBackendRemoveWaitsForRecreation(t *testing.T) {
+	var dir = testDir(t)
+	var fpath = filepath.Join(dir, "config")
+	var backend *fakeWatchBackend
+	var contents *watchedContents
+	var watcher *FileWatcher
+	var err error
+
+	writeTestFile(t, fpath, "one")
+	backend, contents, watcher = watchWithFake(t, fpath, "one")
+
+	err = os.Remove(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.send(t, fpath, fsnotify.Remove)
+
+	// Other files in the directory are of no interest.
+	writeTestFile(t, filepath.Join(dir, "other"), "other")
+	backend.send(t, filepath.Join(dir, "other"), fsnotify.Create)
+
+	// The event for the other file was only handled once the removal was.
+	if !backend.isWatched(dir) {
+		t.Errorf("directory %s not watched while the file is missing", dir)
+	}
+
+	writeTestFile(t, fpath, "three")
+	backend.send(t, fpath, fsnotify.Create)
+	contents.expect("three")
+
+	err = watcher.ShutdownAndWait(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	if backend.isWatched(dir) {
+		t.Errorf("directory %s still watched after the file was recreated", dir)
+	}
+	if !backend.isWatched(fpath) {
+		t.Errorf("recreated file %s not watched", fpath)
+	}
+	contents.expectNone()
+}
+
+func TestFakeBackendRenameOverReportsNewFile(t *testing.T) {
+	var dir = testDir(t)
+	var fpath = filepath.Join(dir, "config")
+	var backend *fakeWatchBackend
+	var contents *watchedContents
+	var watcher *FileWatcher
+	var err error
+
+	writeTestFile(t, fpath, "one")
+	backend, contents, watcher = watchWithFake(t, fpath, "one")
+
+	// Editors save by renaming a new version over the old one.
+	writeTestFile(t, filepath.Join(dir, "config.new"), "two")
+	err = os.Rename(filepath.Join(dir, "config.new"), fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.send(t, fpath, fsnotify.Rename)
+	contents.expect("two")
+
+	err = watcher.ShutdownAndWait(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	if backend.isWatched(dir) {
+		t.Errorf("directory %s watched although the file was never missing", dir)
+	}
+	contents.expectNone()
+}
+
+func TestFakeBackendErrorsAreReported(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "config")
+	var backend *fakeWatchBackend
+	var watcher *FileWatcher
+	var injected = errors.New("queue overflow")
+
+	writeTestFile(t, fpath, "one")
+	backend, _, watcher = watchWithFake(t, fpath, "one")
+	defer watcher.Shutdown()
+
+	select {
+	case backend.errors <- injected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher didn't accept the error")
+	}
+
+	select {
+	case err := <-watcher.ErrChan():
+		if err != injected {
+			t.Errorf("reported error %v, want %v", err, injected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("error not reported")
+	}
+}
@@ -3,10 +3,17 @@ package file
 import (
 	"github.com/childoftheuniverse/filesystem"
 
+	"errors"
+	"fmt"
 	"golang.org/x/net/context"
 	"gopkg.in/fsnotify.v1"
 	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 )
 
 /*
@@ -15,10 +22,149 @@ track of changes to specific files in the file system. They follow the
 specified semantics of the filesystem API.
 */
 type FileWatcher struct {
-	cb       filesystem.FileWatchFunc
-	watcher  *fsnotify.Watcher
-	path     *url.URL
-	shutdown bool
+	cb           ContextFileWatchFunc
+	filter       func(path *url.URL) bool
+	lifetime     context.Context
+	cancel       context.CancelFunc
+	watcher      watchBackend
+	adapter      *FileAdapter
+	path         *url.URL
+	local        string
+	isDir        bool
+	recreating   bool
+	errch        chan error
+	done         chan struct{}
+	finished     chan struct{}
+	shutdownOnce sync.Once
+
+	// callbacks tracks the callbacks reporting changes which are still
+	// running.
+	callbacks sync.WaitGroup
+}
+
+/*
+ContextFileWatchFunc is like filesystem.FileWatchFunc, but additionally
+receives a context which is cancelled once the watcher is shut down, so any
+I/O the callback performs can be aborted along with it.
+*/
+type ContextFileWatchFunc func(ctx context.Context, path *url.URL, r filesystem.ReadCloser)
+
+/*
+WatchOptions holds optional settings for file watchers. A nil *WatchOptions
+is equivalent to the zero value.
+*/
+type WatchOptions struct {
+	// Filter, if set, is consulted for every file before its state is
+	// reported, both initially and on changes. Files for which it returns
+	// false are ignored without being opened.
+	Filter func(path *url.URL) bool
+}
+
+/*
+initialScanWorkers is the maximum number of files in a watched directory whose
+initial state is being opened at the same time.
+*/
+const initialScanWorkers = 8
+
+/*
+ErrSymlinkCycle is returned when resolving the path to be watched runs into a
+chain of symbolic links which point back to themselves.
+*/
+var ErrSymlinkCycle = errors.New("symbolic link cycle")
+
+/*
+maxSymlinkHops is the number of symbolic links resolveSymlinks follows before
+concluding that they form a cycle, like the operating system does.
+*/
+const maxSymlinkHops = 255
+
+/*
+resolveSymlinks follows the symbolic links in fpath, including those in its
+parent directories, and returns the path of the file or directory it ends up
+at. The path is resolved one component at a time, so relative link targets
+are interpreted relative to the real directory containing the link. Chains
+of links longer than maxSymlinkHops, which in practice means cycles, are
+reported as ErrSymlinkCycle rather than looping forever.
+*/
+func resolveSymlinks(fpath string) (string, error) {
+	var resolved string
+	var rest string
+	var volume string
+	var hops int
+	var err error
+
+	// The path must not be cleaned, since that would apply .. lexically.
+	rest = filepath.FromSlash(fpath)
+	if !filepath.IsAbs(rest) {
+		var wd string
+
+		wd, err = os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(rest, string(filepath.Separator)) {
+			// Rooted, but without a volume, as possible on Windows.
+			rest = filepath.VolumeName(wd) + rest
+		} else {
+			rest = wd + string(filepath.Separator) + rest
+		}
+	}
+	volume = filepath.VolumeName(rest)
+	resolved = volume + string(filepath.Separator)
+	rest = rest[len(volume):]
+
+	// resolved never contains any symbolic links, so .. can be applied to
+	// it lexically.
+	for rest != "" {
+		var fi os.FileInfo
+		var name, next, target string
+		var i int
+
+		i = strings.IndexRune(rest, filepath.Separator)
+		if i < 0 {
+			name, rest = rest, ""
+		} else {
+			name, rest = rest[:i], rest[i+1:]
+		}
+
+		if name == "" || name == "." {
+			continue
+		} else if name == ".." {
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next = filepath.Join(resolved, name)
+		fi, err = os.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink != os.ModeSymlink {
+			resolved = next
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinkHops {
+			return "", fmt.Errorf("%s: %w", fpath, ErrSymlinkCycle)
+		}
+
+		target, err = os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		target = filepath.FromSlash(target)
+		if filepath.IsAbs(target) || strings.HasPrefix(target, string(filepath.Separator)) {
+			if filepath.VolumeName(target) != "" {
+				volume = filepath.VolumeName(target)
+				target = target[len(volume):]
+			}
+			resolved = volume + string(filepath.Separator)
+		}
+		rest = target + string(filepath.Separator) + rest
+	}
+
+	return resolved, nil
 }
 
 /*
@@ -26,56 +172,100 @@ NewFileWatcher creates a new FileWatcher watching for any changes in the
 specified file or, when pointed to a directory, any files inside of it.
 Changes will be reported using the callback. The initial version of the file
 is also reported as a change, allowing to use this for e.g. loading a
-configuration file in case of modifications.
+configuration file in case of modifications. For directories, the initial
+versions are opened by several goroutines at once but reported one at a time,
+and cancelling the context aborts the initial scan.
 */
 func NewFileWatcher(ctx context.Context, path *url.URL, cb filesystem.FileWatchFunc) (
 	*FileWatcher, error) {
+	return newFileWatcher(ctx, globalFileAdapter, path, withoutContext(cb), nil)
+}
+
+/*
+NewFileWatcherContext works like NewFileWatcher, but the callback is passed a
+context which is cancelled as soon as Shutdown is called. The context passed
+to NewFileWatcherContext itself only governs setting up the watch.
+*/
+func NewFileWatcherContext(ctx context.Context, path *url.URL, cb ContextFileWatchFunc) (
+	*FileWatcher, error) {
+	return newFileWatcher(ctx, globalFileAdapter, path, cb, nil)
+}
+
+/*
+NewFileWatcherWithOptions works like NewFileWatcherContext, but takes
+additional settings from opts, which may be nil.
+*/
+func NewFileWatcherWithOptions(ctx context.Context, path *url.URL, cb ContextFileWatchFunc,
+	opts *WatchOptions) (*FileWatcher, error) {
+	return newFileWatcher(ctx, globalFileAdapter, path, cb, opts)
+}
+
+/*
+withoutContext adapts a filesystem.FileWatchFunc to ignore the context.
+*/
+func withoutContext(cb filesystem.FileWatchFunc) ContextFileWatchFunc {
+	return func(ctx context.Context, path *url.URL, r filesystem.ReadCloser) {
+		cb(path, r)
+	}
+}
+
+/*
+newFileWatcher implements NewFileWatcher, mapping URLs to local paths and
+opening files through the specified adapter.
+*/
+func newFileWatcher(ctx context.Context, adapter *FileAdapter, path *url.URL,
+	cb ContextFileWatchFunc, opts *WatchOptions) (*FileWatcher, error) {
 	var fi os.FileInfo
 	var ret *FileWatcher
-	var watcher *fsnotify.Watcher
+	var watcher watchBackend
+	var local string
 	var err error
 
-	watcher, err = fsnotify.NewWatcher()
+	if opts == nil {
+		opts = new(WatchOptions)
+	}
+
+	local, err = adapter.localPath(path)
 	if err != nil {
 		return nil, err
 	}
 
-	ret = &FileWatcher{
-		cb:      cb,
-		watcher: watcher,
-		path:    path,
+	// Resolve symbolic links before we do anything.
+	local, err = resolveSymlinks(local)
+	if err != nil {
+		return nil, err
 	}
 
-	fi, err = os.Stat(path.Path)
+	fi, err = os.Stat(local)
 	if err != nil {
 		return nil, err
 	}
 
-	// Resolve symbolic links before we do anything.
-	for fi.Mode()&os.ModeSymlink == os.ModeSymlink {
-		var subpath string
-
-		subpath, err = os.Readlink(path.Path)
-		if err != nil {
-			return nil, err
-		}
-
-		path, err = path.Parse(subpath)
-		if err != nil {
-			return nil, err
-		}
+	watcher, err = adapter.openWatchBackend()
+	if err != nil {
+		return nil, err
+	}
 
-		// Stat the resulting link again to figure out whether it's still
-		// a symbolic link.
-		fi, err = os.Stat(path.Path)
-		if err != nil {
-			return nil, err
-		}
+	ret = &FileWatcher{
+		cb:       cb,
+		filter:   opts.Filter,
+		watcher:  watcher,
+		adapter:  adapter,
+		path:     path,
+		local:    local,
+		isDir:    fi.IsDir(),
+		errch:    make(chan error),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
 	}
 
+	ret.lifetime, ret.cancel = context.WithCancel(context.Background())
+
 	// Start watching for changes.
-	err = watcher.Add(path.Path)
+	err = watcher.Add(local)
 	if err != nil {
+		ret.cancel()
+		watcher.Close()
 		return nil, err
 	}
 
@@ -83,114 +273,448 @@ func NewFileWatcher(ctx context.Context, path *url.URL, cb filesystem.FileWatchF
 		// Watch for changes in any files below the directory. Watcher will
 		// already have done that for us, but we should report the initial
 		// versions of every file in the subtree.
-		var dpath *url.URL
-		var names []string
-		var name string
-		var f *os.File
-
-		// Create a copy of the path with an extra "/" appended.
-		dpath, err = path.Parse(path.Path + "/")
+		err = ret.reportInitialDirectory(ctx)
 		if err != nil {
+			ret.cancel()
+			watcher.Close()
 			return nil, err
 		}
+	} else if ret.wanted(path) {
+		var reader filesystem.ReadCloser
 
-		f, err = os.Open(path.Path)
+		reader, err = adapter.OpenReader(ctx, path)
 		if err != nil {
+			ret.cancel()
+			watcher.Close()
 			return nil, err
 		}
 
-		names, err = f.Readdirnames(-1)
-		if err != nil {
-			return nil, err
-		}
+		// The current state of the file is reported as the first change.
+		cb(ret.lifetime, path, reader)
+	}
+
+	// Watching for and reporting future changes is handled asynchronously.
+	go ret.watchForChanges()
+
+	return ret, nil
+}
+
+/*
+initialFile is a file in a watched directory whose initial state has been
+opened for reporting. The reader is nil if the file couldn't be opened.
+*/
+type initialFile struct {
+	path   *url.URL
+	reader filesystem.ReadCloser
+}
+
+/*
+reportInitialDirectory reports the current state of every file in the
+watched directory to the callback. Up to initialScanWorkers files are opened
+at the same time, but they are handed to the callback one at a time and in
+lexical order, just as if they had been opened one after the other. Entries
+which resolve to the same file through symbolic links are only reported once.
+Cancelling the context stops the scan and returns the context error right
+away, without waiting for a callback which is still running; files which
+have not been reported by then are closed and skipped.
+*/
+func (f *FileWatcher) reportInitialDirectory(ctx context.Context) error {
+	var dir *os.File
+	var names []string
+	var pending = make(chan chan initialFile, initialScanWorkers)
+	var reported = make(chan struct{})
+	var feedErr, reportErr error
+	var err error
+
+	dir, err = os.Open(f.local)
+	if err != nil {
+		return err
+	}
+
+	names, err = readDirNames(ctx, dir)
+	dir.Close()
+	if err != nil {
+		return err
+	}
+	names = uniqueEntries(f.local, names)
+
+	// Start opening the files in order. Since pending is bounded, only a
+	// limited number of them can be opened before being reported.
+	go func() {
+		var name string
+
+		defer close(pending)
 
 		for _, name = range names {
-			var combined *url.URL
-			var reader filesystem.ReadCloser
+			var child = childURL(f.path, name)
+			var result = make(chan initialFile, 1)
 
-			combined, err = dpath.Parse(name)
-			if err != nil {
-				return nil, err
+			if !f.wanted(child) {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				feedErr = ctx.Err()
+				return
+			case pending <- result:
 			}
 
-			// The current state of the file is reported as the first change.
-			reader, err = globalFileAdapter.OpenReader(ctx, combined)
-			cb(combined, reader)
+			go func(child *url.URL, result chan initialFile) {
+				var reader filesystem.ReadCloser
+				var rerr error
+
+				reader, rerr = f.adapter.OpenReader(ctx, child)
+				if rerr != nil {
+					reader = nil
+				}
+				result <- initialFile{path: child, reader: reader}
+			}(child, result)
 		}
+	}()
 
-		f.Close()
-	} else {
-		var reader filesystem.ReadCloser
+	// Report the files one at a time, in the order they were queued.
+	go func() {
+		var result chan initialFile
+
+		defer close(reported)
+
+		for result = range pending {
+			var file = <-result
 
-		reader, err = globalFileAdapter.OpenReader(ctx, path)
+			if file.reader == nil {
+				continue
+			}
+			if ctx.Err() != nil {
+				reportErr = ctx.Err()
+				file.reader.Close(context.Background())
+				continue
+			}
+
+			// The current state of the file is reported as the first
+			// change.
+			f.cb(f.lifetime, file.path, file.reader)
+		}
+	}()
+
+	select {
+	case <-reported:
+	case <-ctx.Done():
+		select {
+		case <-reported:
+		default:
+			// A callback may still be running. The reporter closes
+			// whatever is left once it returns.
+			return ctx.Err()
+		}
+	}
+
+	if feedErr != nil {
+		return feedErr
+	}
+	return reportErr
+}
+
+/*
+uniqueEntries filters the entries names of the directory dir so that only one
+name remains for every distinct file they resolve to. Names which aren't
+symbolic links are preferred over the links pointing to them; among links,
+the first name in lexical order wins. Entries which cannot be resolved, e.g.
+dangling links, are dropped.
+*/
+func uniqueEntries(dir string, names []string) []string {
+	var resolvedNames = make(map[string]string)
+	var chosen = make(map[string]string)
+	var result []string
+	var name string
+
+	names = append([]string(nil), names...)
+	sort.Strings(names)
+
+	for _, name = range names {
+		var joined = filepath.Join(dir, name)
+		var resolved string
+		var prev string
+		var found bool
+		var err error
+
+		resolved, err = filepath.EvalSymlinks(joined)
 		if err != nil {
-			return nil, err
+			continue
 		}
+		resolvedNames[name] = resolved
 
-		// The current state of the file is reported as the first change.
-		cb(path, reader)
+		prev, found = chosen[resolved]
+		if !found || (resolved == joined && filepath.Join(dir, prev) != resolved) {
+			chosen[resolved] = name
+		}
 	}
 
-	// Watching for and reporting future changes is handled asynchronously.
-	go ret.watchForChanges()
+	for _, name = range names {
+		var resolved string
+		var found bool
 
-	return ret, nil
+		resolved, found = resolvedNames[name]
+		if found && chosen[resolved] == name {
+			result = append(result, name)
+		}
+	}
+
+	return result
+}
+
+/*
+childURL creates a copy of the URL dir pointing to the entry name inside of
+the directory. The name is used verbatim rather than being parsed as a URL
+reference, so names containing characters like "%" or "?" are preserved.
+*/
+func childURL(dir *url.URL, name string) *url.URL {
+	var child = *dir
+
+	child.Path = path.Join(dir.Path, name)
+	child.RawPath = ""
+	return &child
+}
+
+/*
+subjectURL determines the URL under which changes to the local file fpath
+are reported. This is the watched URL itself or, for watched directories, a
+URL for the entry inside of it, so that callers get to see URLs in terms of
+what they asked to watch rather than wherever symbolic links led.
+*/
+func (f *FileWatcher) subjectURL(fpath string) (*url.URL, error) {
+	var rel string
+	var err error
+
+	rel, err = filepath.Rel(f.local, fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	if rel == "." {
+		return f.path, nil
+	}
+	return childURL(f.path, filepath.ToSlash(rel)), nil
 }
 
 /*
 watchForChanges is invoked asynchronously and handles changes events from the
 file system, routing the relevant ones (write, rename, etc.) to the
-callback as requested.
+callback as requested. It is the only sender on the error channel, which is
+closed as soon as it returns.
 */
 func (f *FileWatcher) watchForChanges() {
-	// Use background context as this is not a synchronous process.
-	var ctx = context.Background()
-
-	for !f.shutdown {
-		var event fsnotify.Event
+	// This is not a synchronous process, so operations are bounded by the
+	// lifetime of the watcher only.
+	var ctx = f.lifetime
 
-		event = <-f.watcher.Events
+	defer close(f.finished)
+	defer close(f.errch)
 
-		if event.Op&(fsnotify.Write|fsnotify.Remove|fsnotify.Rename) != 0 {
-			var subject *url.URL
-			var reader filesystem.ReadCloser
-			var err error
+	for {
+		var event fsnotify.Event
+		var relevant bool
+		var err error
+		var ok bool
+
+		select {
+		case <-f.done:
+			return
+		case err, ok = <-f.watcher.Errors():
+			if !ok {
+				return
+			}
+			f.reportError(err)
+		case event, ok = <-f.watcher.Events():
+			if !ok {
+				return
+			}
 
-			subject, err = f.path.Parse(event.Name)
-			if err != nil {
-				f.watcher.Errors <- err
-				continue
+			relevant = event.Op&(fsnotify.Write|fsnotify.Remove|fsnotify.Rename) != 0
+			if !f.isDir {
+				relevant = f.followFile(event)
 			}
 
-			reader, err = globalFileAdapter.OpenReader(ctx, subject)
-			if err == nil {
-				go f.cb(subject, reader)
-			} else {
-				f.watcher.Errors <- err
+			if relevant {
+				var subject *url.URL
+				var reader filesystem.ReadCloser
+
+				subject, err = f.subjectURL(event.Name)
+				if err != nil {
+					f.reportError(err)
+					continue
+				}
+				if !f.wanted(subject) {
+					continue
+				}
+
+				// Subdirectories have no contents to report.
+				reader, err = f.adapter.OpenReader(ctx, subject)
+				if err == nil {
+					f.callbacks.Add(1)
+					go func() {
+						defer f.callbacks.Done()
+						f.cb(ctx, subject, reader)
+					}()
+				} else if !errors.Is(err, ErrIsDirectory) {
+					f.reportError(err)
+				}
 			}
 		}
 	}
 }
 
 /*
-Shutdown tells the system to stop watching for changes to the file(s) and
-shuts down the asynchronous change watching thread.
+followFile keeps track of the watched file if it is deleted or replaced and
+determines whether event indicates a change to be reported. Editors often
+save files by renaming a new file over the old one, and the watch on the old
+one is lost along with it. So once the file disappears, the watch is set up
+again for whatever is at the path by then or, if there's nothing, the
+directory containing it is watched until the file is recreated; the new file
+is then reported as a change.
 */
-func (f *FileWatcher) Shutdown() error {
+func (f *FileWatcher) followFile(event fsnotify.Event) bool {
+	// While waiting for the file to be recreated, there are events for
+	// other files in its directory as well.
+	if filepath.Clean(event.Name) != f.local {
+		return false
+	}
+
+	if f.recreating {
+		if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+			return false
+		}
+		return f.rewatch()
+	}
+
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		return f.rewatch()
+	}
+
+	return event.Op&fsnotify.Write != 0
+}
+
+/*
+rewatch watches the path of the watched file again, returning whether that
+worked. If there's no file at the path anymore, its directory is watched
+instead, so that followFile notices when it comes back.
+*/
+func (f *FileWatcher) rewatch() bool {
+	var dir = filepath.Dir(f.local)
 	var err error
 
-	f.shutdown = true
-	err = f.watcher.Remove(f.path.Path)
+	err = f.watcher.Add(f.local)
+	if err == nil {
+		if f.recreating {
+			f.watcher.Remove(dir)
+			f.recreating = false
+		}
+		return true
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		f.reportError(err)
+		return false
+	}
+	if f.recreating {
+		return false
+	}
+
+	err = f.watcher.Add(dir)
 	if err != nil {
-		return err
+		f.reportError(err)
+		return false
 	}
+	f.recreating = true
 
-	return f.watcher.Close()
+	// The file may have been recreated before the directory was watched.
+	return f.rewatch()
+}
+
+/*
+wanted determines whether changes to the file at the specified URL should be
+reported according to the filter.
+*/
+func (f *FileWatcher) wanted(path *url.URL) bool {
+	return f.filter == nil || f.filter(path)
+}
+
+/*
+reportError hands err to whoever is reading the error channel, unless the
+watcher is being shut down in the meantime, in which case it is dropped.
+*/
+func (f *FileWatcher) reportError(err error) {
+	select {
+	case f.errch <- err:
+	case <-f.done:
+	}
+}
+
+/*
+Shutdown tells the system to stop watching for changes to the file(s) and
+shuts down the asynchronous change watching thread. Once it returns, no more
+errors will be reported and the error channel has been closed. The context
+passed to callbacks is cancelled, but callbacks still running are not waited
+for; use ShutdownAndWait for that. Calling Shutdown more than once is
+harmless.
+*/
+func (f *FileWatcher) Shutdown() error {
+	var err error
+
+	f.shutdownOnce.Do(func() {
+		f.cancel()
+		close(f.done)
+
+		// Closing the watcher removes all watches, including any on the
+		// directory of a watched file which was deleted.
+		err = f.watcher.Close()
+
+		// Wait for the change watching thread to let go of the error
+		// channel.
+		<-f.finished
+	})
+
+	return err
+}
+
+/*
+ShutdownAndWait works like Shutdown, but then also waits for callbacks which
+are still running to return, so that state they use can be torn down safely
+afterwards. If the context is done before all callbacks have returned, its
+error is returned; the callbacks keep running. It must not be called from a
+callback, since it would wait for itself.
+*/
+func (f *FileWatcher) ShutdownAndWait(ctx context.Context) error {
+	var err error
+
+	err = f.Shutdown()
+	return combineErrors(err, waitForCallbacks(ctx, &f.callbacks))
+}
+
+/*
+waitForCallbacks waits for the callbacks tracked by wg to return, as long as
+the context permits.
+*/
+func waitForCallbacks(ctx context.Context, wg *sync.WaitGroup) error {
+	var returned = make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(returned)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-returned:
+		return nil
+	}
 }
 
 /*
-Accessor method to get the error reporting channel.
+Accessor method to get the error reporting channel. The channel is closed
+when the watcher shuts down.
 */
 func (f *FileWatcher) ErrChan() chan error {
-	return f.watcher.Errors
+	return f.errch
 }
@@ -0,0 +1,572 @@
+package file
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"gopkg.in/fsnotify.v1"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResolveSymlinks(t *testing.T) {
+	var dir = testDir(t)
+	var target = filepath.Join(dir, "target")
+	var resolved string
+	var err error
+
+	writeTestFile(t, target, "target")
+	err = os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Relative targets are relative to the directory holding the link.
+	symlinkOrSkip(t, filepath.Join("..", "target"), filepath.Join(dir, "sub", "relative"))
+	symlinkOrSkip(t, target, filepath.Join(dir, "absolute"))
+	symlinkOrSkip(t, filepath.Join("sub", "relative"), filepath.Join(dir, "chain"))
+
+	for _, name := range []string{"target", "sub/relative", "absolute", "chain"} {
+		resolved, err = resolveSymlinks(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("resolving %s: %v", name, err)
+		} else if resolved != target {
+			t.Errorf("%s resolved to %s, want %s", name, resolved, target)
+		}
+	}
+}
+
+func TestResolveSymlinksThroughLinkedDirectory(t *testing.T) {
+	var dir = testDir(t)
+	var target = filepath.Join(dir, "x", "y", "t")
+	var resolved string
+	var err error
+
+	err = os.MkdirAll(filepath.Join(dir, "x", "y", "w"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, target, "target")
+	writeTestFile(t, filepath.Join(dir, "t"), "decoy")
+
+	// The .. in the target must be taken relative to x/y/w, where the link
+	// really lives, not relative to the linked directory w.
+	symlinkOrSkip(t, filepath.Join(dir, "x", "y", "w"), filepath.Join(dir, "w"))
+	symlinkOrSkip(t, filepath.Join("..", "t"), filepath.Join(dir, "x", "y", "w", "l"))
+
+	resolved, err = resolveSymlinks(filepath.Join(dir, "w", "l"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved != target {
+		t.Errorf("w/l resolved to %s, want %s", resolved, target)
+	}
+
+	// The same goes for .. following the linked directory in the path.
+	resolved, err = resolveSymlinks(filepath.Join(dir, "w") + string(filepath.Separator) +
+		filepath.Join("..", "t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved != target {
+		t.Errorf("w/../t resolved to %s, want %s", resolved, target)
+	}
+}
+
+func TestResolveSymlinksCycle(t *testing.T) {
+	var dir = testDir(t)
+	var err error
+
+	symlinkOrSkip(t, "b", filepath.Join(dir, "a"))
+	symlinkOrSkip(t, "a", filepath.Join(dir, "b"))
+	symlinkOrSkip(t, "self", filepath.Join(dir, "self"))
+
+	for _, name := range []string{"a", "b", "self"} {
+		_, err = resolveSymlinks(filepath.Join(dir, name))
+		if !errors.Is(err, ErrSymlinkCycle) {
+			t.Errorf("resolving %s returned %v, want %v", name, err, ErrSymlinkCycle)
+		}
+	}
+
+	_, err = NewFileWatcher(context.Background(), fileURL(filepath.Join(dir, "a")), nil)
+	if !errors.Is(err, ErrSymlinkCycle) {
+		t.Errorf("watching a cycle returned %v, want %v", err, ErrSymlinkCycle)
+	}
+}
+
+func TestWatchThroughSymlink(t *testing.T) {
+	var dir = testDir(t)
+	var target = filepath.Join(dir, "target")
+	var link = filepath.Join(dir, "link")
+	var backend *fakeWatchBackend
+	var watcher *FileWatcher
+
+	writeTestFile(t, target, "one")
+	symlinkOrSkip(t, "target", link)
+
+	// The file the link points to is watched, not the link itself.
+	backend, _, watcher = watchWithFake(t, link, "one")
+	defer watcher.Shutdown()
+
+	if !backend.isWatched(target) {
+		t.Errorf("%s is not watched", target)
+	}
+	if backend.isWatched(link) {
+		t.Errorf("link %s is watched instead of its target", link)
+	}
+}
+
+func TestShutdownDuringEventStorm(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "busy")
+	var i int
+
+	writeTestFile(t, fpath, "0")
+
+	for i = 0; i < 20; i++ {
+		var stop = make(chan struct{})
+		var writers sync.WaitGroup
+		var reported atomic.Int64
+		var drained = make(chan struct{})
+		var deadline time.Time
+		var watcher *FileWatcher
+		var err error
+
+		watcher, err = NewFileWatcher(context.Background(), fileURL(fpath),
+			func(path *url.URL, r filesystem.ReadCloser) {
+				reported.Add(1)
+				r.Close(context.Background())
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go func() {
+			defer close(drained)
+			for range watcher.ErrChan() {
+			}
+		}()
+
+		writers.Add(1)
+		go func() {
+			var n int
+
+			defer writers.Done()
+			for n = 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				os.WriteFile(fpath, []byte(fmt.Sprint(n)), 0644)
+			}
+		}()
+
+		// Shut down while events are still pouring in.
+		deadline = time.Now().Add(5 * time.Second)
+		for reported.Load() < int64(i%5+2) && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		err = watcher.Shutdown()
+		if err != nil {
+			t.Error(err)
+		}
+
+		select {
+		case <-drained:
+		case <-time.After(5 * time.Second):
+			t.Fatal("error channel not closed after Shutdown returned")
+		}
+
+		close(stop)
+		writers.Wait()
+	}
+}
+
+func TestInitialScanCancelled(t *testing.T) {
+	var dir = testDir(t)
+	var ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	var reported atomic.Int64
+	var start time.Time
+	var elapsed time.Duration
+	var i int
+	var err error
+
+	defer cancel()
+
+	for i = 0; i < 3000; i++ {
+		writeTestFile(t, filepath.Join(dir, fmt.Sprintf("file%04d", i)), "")
+	}
+
+	// Reporting everything would take several seconds.
+	start = time.Now()
+	_, err = NewFileWatcherContext(ctx, fileURL(dir),
+		func(ctx context.Context, path *url.URL, r filesystem.ReadCloser) {
+			r.Close(context.Background())
+			reported.Add(1)
+			time.Sleep(10 * time.Millisecond)
+		})
+	elapsed = time.Since(start)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("watching returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed > time.Second {
+		t.Errorf("watching took %v to give up", elapsed)
+	}
+	if reported.Load() >= 3000 {
+		t.Error("all files were reported despite the context timing out")
+	}
+}
+
+func TestInitialScanReportsSerially(t *testing.T) {
+	var dir = testDir(t)
+	var backend = newFakeWatchBackend()
+	var running, overlaps atomic.Int64
+	var reported []string
+	var expected []string
+	var watcher *FileWatcher
+	var i int
+	var err error
+
+	for i = 0; i < 50; i++ {
+		var name = fmt.Sprintf("file%02d", i)
+
+		writeTestFile(t, filepath.Join(dir, name), name)
+		expected = append(expected, name)
+	}
+
+	// The callback deliberately doesn't lock anything.
+	watcher, err = newFileWatcher(context.Background(), backend.adapter(), fileURL(dir),
+		func(ctx context.Context, path *url.URL, r filesystem.ReadCloser) {
+			if running.Add(1) > 1 {
+				overlaps.Add(1)
+			}
+			r.Close(context.Background())
+			reported = append(reported, filepath.Base(path.Path))
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+		}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Shutdown()
+
+	if overlaps.Load() > 0 {
+		t.Errorf("callback was run concurrently %d times", overlaps.Load())
+	}
+	if fmt.Sprint(reported) != fmt.Sprint(expected) {
+		t.Errorf("reported %v, want %v", reported, expected)
+	}
+}
+
+func TestInitialScanCancelledDuringCallback(t *testing.T) {
+	var dir = testDir(t)
+	var backend = newFakeWatchBackend()
+	var ctx, cancel = context.WithCancel(context.Background())
+	var started = make(chan struct{})
+	var release = make(chan struct{})
+	var interrupted = make(chan error, 1)
+	var calls atomic.Int64
+	var returned = make(chan error, 1)
+	var i int
+	var err error
+
+	defer cancel()
+	defer close(release)
+
+	for i = 0; i < 5; i++ {
+		writeTestFile(t, filepath.Join(dir, fmt.Sprintf("file%d", i)), "")
+	}
+
+	go func() {
+		var werr error
+
+		_, werr = newFileWatcher(ctx, backend.adapter(), fileURL(dir),
+			func(ctx context.Context, path *url.URL, r filesystem.ReadCloser) {
+				r.Close(context.Background())
+				if calls.Add(1) > 1 {
+					return
+				}
+
+				close(started)
+				<-ctx.Done()
+				interrupted <- ctx.Err()
+				<-release
+			}, nil)
+		returned <- werr
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("initial state not reported")
+	}
+	cancel()
+
+	// The callback is still running, which mustn't keep the constructor
+	// from giving up.
+	select {
+	case err = <-returned:
+		if err != context.Canceled {
+			t.Errorf("watching returned %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watching waited for the running callback")
+	}
+
+	select {
+	case err = <-interrupted:
+		if err != context.Canceled {
+			t.Errorf("callback context ended with %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback context not cancelled")
+	}
+}
+
+func TestShutdownInterruptsCallback(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "config")
+	var backend = newFakeWatchBackend()
+	var calls atomic.Int64
+	var started = make(chan struct{})
+	var interrupted = make(chan error, 1)
+	var watcher *FileWatcher
+	var err error
+
+	writeTestFile(t, fpath, "one")
+
+	watcher, err = newFileWatcher(context.Background(), backend.adapter(), fileURL(fpath),
+		func(ctx context.Context, path *url.URL, r filesystem.ReadCloser) {
+			r.Close(context.Background())
+			if calls.Add(1) == 1 {
+				// Let the initial report through.
+				return
+			}
+
+			close(started)
+			select {
+			case <-ctx.Done():
+				interrupted <- ctx.Err()
+			case <-time.After(5 * time.Second):
+				interrupted <- nil
+			}
+		}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, fpath, "two")
+	backend.send(t, fpath, fsnotify.Write)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("change not reported")
+	}
+
+	err = watcher.Shutdown()
+	if err != nil {
+		t.Error(err)
+	}
+	err = <-interrupted
+	if err != context.Canceled {
+		t.Errorf("callback context ended with %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestInitialScanReportsLinkedFilesOnce(t *testing.T) {
+	var dir = testDir(t)
+	var backend = newFakeWatchBackend()
+	var mu sync.Mutex
+	var reported = make(map[string]int)
+	var watcher *FileWatcher
+	var err error
+
+	writeTestFile(t, filepath.Join(dir, "b"), "b")
+	writeTestFile(t, filepath.Join(dir, "c"), "c")
+	symlinkOrSkip(t, "b", filepath.Join(dir, "a-link"))
+	symlinkOrSkip(t, "c", filepath.Join(dir, "x-link"))
+	symlinkOrSkip(t, "c", filepath.Join(dir, "y-link"))
+	symlinkOrSkip(t, "missing", filepath.Join(dir, "dangling"))
+
+	watcher, err = newFileWatcher(context.Background(), backend.adapter(), fileURL(dir),
+		func(ctx context.Context, path *url.URL, r filesystem.ReadCloser) {
+			r.Close(context.Background())
+
+			mu.Lock()
+			defer mu.Unlock()
+			reported[filepath.Base(path.Path)]++
+		}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = watcher.ShutdownAndWait(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+
+	// The files are reported under their own names, not those of the links.
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reported) != 2 || reported["b"] != 1 || reported["c"] != 1 {
+		t.Errorf("reported %v, want b and c once each", reported)
+	}
+}
+
+func TestWatchFilter(t *testing.T) {
+	var dir = testDir(t)
+	var backend = newFakeWatchBackend()
+	var contents = newWatchedContents(t)
+	var consulted = make(map[string]bool)
+	var mu sync.Mutex
+	var watcher *FileWatcher
+	var err error
+
+	writeTestFile(t, filepath.Join(dir, "a.conf"), "a.conf")
+	writeTestFile(t, filepath.Join(dir, "b.txt"), "b.txt")
+
+	watcher, err = newFileWatcher(context.Background(), backend.adapter(), fileURL(dir),
+		withoutContext(contents.notify), &WatchOptions{
+			Filter: func(path *url.URL) bool {
+				mu.Lock()
+				defer mu.Unlock()
+				consulted[filepath.Base(path.Path)] = true
+				return filepath.Ext(path.Path) == ".conf"
+			},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents.expect("a.conf")
+
+	writeTestFile(t, filepath.Join(dir, "b.txt"), "b.txt changed")
+	backend.send(t, filepath.Join(dir, "b.txt"), fsnotify.Write)
+	writeTestFile(t, filepath.Join(dir, "c.conf"), "c.conf")
+	backend.send(t, filepath.Join(dir, "c.conf"), fsnotify.Write)
+	contents.expect("c.conf")
+
+	err = watcher.ShutdownAndWait(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	contents.expectNone()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !consulted["b.txt"] {
+		t.Error("the filter wasn't consulted for b.txt")
+	}
+}
+
+func TestWatchDeletedAndRecreated(t *testing.T) {
+	var dir = testDir(t)
+	var fpath = filepath.Join(dir, "config")
+	var staging = filepath.Join(dir, "staging")
+	var contents = newWatchedContents(t)
+	var watcher *FileWatcher
+	var err error
+
+	writeTestFile(t, fpath, "one")
+	err = os.Mkdir(staging, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	watcher, err = NewFileWatcher(context.Background(), fileURL(fpath), contents.notify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.ShutdownAndWait(context.Background())
+	contents.expect("one")
+
+	err = os.Remove(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Give the watcher time to notice the file is gone.
+	time.Sleep(100 * time.Millisecond)
+
+	// New versions are moved into place complete, so they are reported
+	// with their final contents.
+	for _, version := range []string{"two", "three"} {
+		writeTestFile(t, filepath.Join(staging, version), version)
+		err = os.Rename(filepath.Join(staging, version), fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents.expect(version)
+	}
+}
+
+func TestShutdownAndWaitForCallback(t *testing.T) {
+	var fpath = filepath.Join(testDir(t), "config")
+	var backend = newFakeWatchBackend()
+	var calls atomic.Int64
+	var started = make(chan struct{})
+	var release = make(chan struct{})
+	var finished atomic.Bool
+	var returned = make(chan error, 1)
+	var ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	var watcher *FileWatcher
+	var err error
+
+	defer cancel()
+	writeTestFile(t, fpath, "one")
+
+	watcher, err = newFileWatcher(context.Background(), backend.adapter(), fileURL(fpath),
+		func(ctx context.Context, path *url.URL, r filesystem.ReadCloser) {
+			r.Close(context.Background())
+			if calls.Add(1) == 1 {
+				return
+			}
+
+			// A slow callback which doesn't care about its context.
+			close(started)
+			<-release
+			finished.Store(true)
+		}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, fpath, "two")
+	backend.send(t, fpath, fsnotify.Write)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("change not reported")
+	}
+
+	err = watcher.ShutdownAndWait(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("giving up on the callback returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	go func() {
+		returned <- watcher.ShutdownAndWait(context.Background())
+	}()
+	select {
+	case err = <-returned:
+		t.Fatalf("returned %v while the callback was still running", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case err = <-returned:
+		if err != nil {
+			t.Error(err)
+		}
+		if !finished.Load() {
+			t.Error("returned before the callback finished")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("didn't return after the callback finished")
+	}
+}
@@ -0,0 +1,31 @@
+//go:build zstd
+
+package file
+
+import (
+	"github.com/klauspost/compress/zstd"
+	"io"
+)
+
+/*
+zstdSupported indicates whether OpenReaderAutoDecompress decompresses zstd
+compressed files. The standard library has no zstd decoder, so this requires
+building with the zstd tag, which pulls in github.com/klauspost/compress.
+*/
+const zstdSupported = true
+
+/*
+newZstdReader creates a decompressor for the zstd stream read from r. Frames
+are decoded one at a time in the calling goroutine, so that no more data is
+read ahead than necessary.
+*/
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	var d *zstd.Decoder
+	var err error
+
+	d, err = zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
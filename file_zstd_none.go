@@ -0,0 +1,19 @@
+//go:build !zstd
+
+package file
+
+import (
+	"errors"
+	"io"
+)
+
+/*
+zstdSupported is false unless building with the zstd tag; see file_zstd.go.
+zstd compressed files are then read as they are, like any other format which
+isn't recognized.
+*/
+const zstdSupported = false
+
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	return nil, errors.ErrUnsupported
+}